@@ -0,0 +1,222 @@
+// Package api serves a synchronous HTTP JSON and gRPC API for Commands.
+//
+// Commands received through the API are handled by the same command.Handler
+// pipeline as the Commands consumed from Kafka, and their Events are still
+// produced to Kafka. The resulting model.Document is returned to the caller
+// instead of being produced to a ResponseTopic.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/TerrexTech/agg-userauth-cmd/auth"
+	"github.com/TerrexTech/agg-userauth-cmd/command"
+	"github.com/TerrexTech/agg-userauth-cmd/logger"
+	"github.com/TerrexTech/agg-userauth-cmd/metrics"
+	"github.com/TerrexTech/agg-userauth-cmd/tracing"
+	"github.com/TerrexTech/go-common-models/model"
+	"github.com/TerrexTech/uuuid"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Request is a Command issued through the API.
+type Request struct {
+	Action string `json:"action"`
+	// Data is the Command's data, such as the user to register.
+	Data json.RawMessage `json:"data"`
+	// CorrelationID is optional, and is generated if blank.
+	CorrelationID string `json:"correlationID,omitempty"`
+	// ResponseTopic is optional. If set, the Response is also produced to it.
+	ResponseTopic string `json:"responseTopic,omitempty"`
+}
+
+// RequestError is returned for invalid Requests.
+type RequestError struct {
+	Message string
+}
+
+func (e *RequestError) Error() string {
+	return e.Message
+}
+
+// Executor handles the Command and returns its Response, such as
+// command.Handler.Execute.
+type Executor func(ctx context.Context, cmd *model.Command) *model.Document
+
+// Explainer decides whether the Command is authorized without handling it,
+// such as command.Handler.Explain.
+type Explainer func(ctx context.Context, cmd *model.Command) (*auth.Decision, error)
+
+// Config is the config for the API-Service.
+type Config struct {
+	// BuildState builds the Aggregate-state before each Command,
+	// as is done for the Commands consumed from Kafka.
+	BuildState func(ctx context.Context) error
+	Handle     Executor
+	// Explain is optional, and serves the dry-run endpoint if set.
+	Explain Explainer
+	// Lock is optional. If set, it is held while the Aggregate-state is
+	// built and the Command is handled or explained.
+	Lock sync.Locker
+
+	// ServiceName is set as the Source of the Commands.
+	ServiceName string
+
+	// Logger is optional, and defaults to logger.DefaultLogger().
+	Logger *logger.Logger
+}
+
+// Service executes the Requests received by the HTTP and gRPC servers.
+type Service struct {
+	*Config
+}
+
+// NewService creates a new API-Service.
+func NewService(config *Config) (*Service, error) {
+	if config == nil {
+		return nil, errors.New("config cannot be nil")
+	}
+	if config.BuildState == nil {
+		return nil, errors.New("BuildState cannot be nil")
+	}
+	if config.Handle == nil {
+		return nil, errors.New("Handle cannot be nil")
+	}
+	if config.ServiceName == "" {
+		return nil, errors.New("ServiceName cannot be blank")
+	}
+	return &Service{
+		config,
+	}, nil
+}
+
+// Execute runs the Request as a Command, and returns the Command's Response.
+// A *RequestError is returned if the Request is invalid, and other errors if
+// the Command could not be handled.
+func (s *Service) Execute(
+	ctx context.Context,
+	transport string,
+	req *Request,
+) (doc *model.Document, err error) {
+	ctx = logger.NewContext(ctx, s.Logger)
+	ctx, span := tracing.Start(
+		ctx,
+		"api.Execute",
+		attribute.String("api.transport", transport),
+		attribute.String("command.action", req.Action),
+	)
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	cmd, err := s.newCommand(req)
+	if err != nil {
+		return nil, err
+	}
+	metrics.CommandsReceived.WithLabelValues(cmd.Action).Inc()
+	logger.FromContext(ctx).WithCommand(cmd).Infof(
+		"Received Command with ID: %s over %s", cmd.UUID, transport,
+	)
+
+	unlock := s.acquire()
+	defer unlock()
+
+	err = s.BuildState(ctx)
+	if err != nil {
+		err = errors.Wrap(err, "Error building Aggregate-state")
+		return nil, err
+	}
+	return s.Handle(ctx, cmd), nil
+}
+
+// ExplainRequest decides whether the Request's Command would be authorized,
+// without handling it. Errors are returned as for Execute.
+func (s *Service) ExplainRequest(
+	ctx context.Context,
+	transport string,
+	req *Request,
+) (decision *auth.Decision, err error) {
+	ctx = logger.NewContext(ctx, s.Logger)
+	ctx, span := tracing.Start(
+		ctx,
+		"api.Explain",
+		attribute.String("api.transport", transport),
+		attribute.String("command.action", req.Action),
+	)
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	cmd, err := s.newCommand(req)
+	if err != nil {
+		return nil, err
+	}
+	unlock := s.acquire()
+	defer unlock()
+
+	err = s.BuildState(ctx)
+	if err != nil {
+		err = errors.Wrap(err, "Error building Aggregate-state")
+		return nil, err
+	}
+	return s.Explain(ctx, cmd)
+}
+
+// acquire holds the Lock, if set, until the returned func is called.
+func (s *Service) acquire() func() {
+	if s.Lock == nil {
+		return func() {}
+	}
+	s.Lock.Lock()
+	return s.Lock.Unlock
+}
+
+// newCommand validates the Request and creates its Command.
+func (s *Service) newCommand(req *Request) (*model.Command, error) {
+	if req.Action == "" {
+		return nil, &RequestError{"action cannot be blank"}
+	}
+	if !command.IsAction(req.Action) {
+		return nil, &RequestError{fmt.Sprintf("unknown action: %s", req.Action)}
+	}
+	if len(req.Data) == 0 {
+		return nil, &RequestError{"data cannot be blank"}
+	}
+
+	var (
+		cid uuuid.UUID
+		err error
+	)
+	if req.CorrelationID != "" {
+		cid, err = uuuid.FromString(req.CorrelationID)
+		if err != nil {
+			return nil, &RequestError{fmt.Sprintf("invalid correlationID: %s", err)}
+		}
+	} else {
+		cid, err = uuuid.NewV4()
+		if err != nil {
+			err = errors.Wrap(err, "Error generating CorrelationID")
+			return nil, err
+		}
+	}
+	cmdID, err := uuuid.NewV4()
+	if err != nil {
+		err = errors.Wrap(err, "Error generating Command-UUID")
+		return nil, err
+	}
+
+	return &model.Command{
+		Action:        req.Action,
+		CorrelationID: cid,
+		Data:          req.Data,
+		ResponseTopic: req.ResponseTopic,
+		Source:        s.ServiceName,
+		Timestamp:     time.Now().UTC().Unix(),
+		UUID:          cmdID,
+	}, nil
+}
@@ -0,0 +1,287 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/TerrexTech/agg-userauth-cmd/auth"
+	"github.com/TerrexTech/go-common-models/model"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// TestAPI tests the synchronous Command-API.
+func TestAPI(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "API Suite")
+}
+
+// testLock records whether it is held.
+type testLock struct {
+	held bool
+}
+
+func (l *testLock) Lock() {
+	Expect(l.held).To(BeFalse())
+	l.held = true
+}
+
+func (l *testLock) Unlock() {
+	l.held = false
+}
+
+var _ = Describe("API", func() {
+	var (
+		service  *Service
+		buildErr error
+		handled  []*model.Command
+		tokens   []string
+		result   *model.Document
+	)
+
+	BeforeEach(func() {
+		buildErr = nil
+		handled = []*model.Command{}
+		tokens = []string{}
+		result = &model.Document{
+			Data:   []byte(`{"userID":"test-user"}`),
+			Source: "test-service",
+		}
+
+		var err error
+		service, err = NewService(&Config{
+			BuildState: func(context.Context) error {
+				return buildErr
+			},
+			Handle: func(ctx context.Context, cmd *model.Command) *model.Document {
+				handled = append(handled, cmd)
+				tokens = append(tokens, auth.TokenFromContext(ctx))
+				return result
+			},
+			Explain: func(ctx context.Context, cmd *model.Command) (*auth.Decision, error) {
+				return &auth.Decision{
+					Action: cmd.Action,
+					Reason: "test-reason",
+				}, nil
+			},
+			ServiceName: "test-service",
+		})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should run Requests as Commands", func() {
+		doc, err := service.Execute(context.Background(), TransportHTTP, &Request{
+			Action: "RegisterUser",
+			Data:   json.RawMessage(`{"userName":"test"}`),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(doc).To(Equal(result))
+
+		Expect(handled).To(HaveLen(1))
+		Expect(handled[0].Action).To(Equal("RegisterUser"))
+		Expect(handled[0].Data).To(MatchJSON(`{"userName":"test"}`))
+		Expect(handled[0].Source).To(Equal("test-service"))
+		Expect(handled[0].ResponseTopic).To(BeEmpty())
+		Expect(handled[0].Timestamp).ToNot(BeZero())
+	})
+
+	It("should return RequestError for invalid Requests", func() {
+		_, err := service.Execute(context.Background(), TransportHTTP, &Request{
+			Action: "DropUsers",
+			Data:   json.RawMessage(`{}`),
+		})
+		Expect(err).To(BeAssignableToTypeOf(&RequestError{}))
+
+		_, err = service.Execute(context.Background(), TransportHTTP, &Request{
+			Action: "DeleteUser",
+		})
+		Expect(err).To(BeAssignableToTypeOf(&RequestError{}))
+		Expect(handled).To(BeEmpty())
+	})
+
+	It("should not handle Commands if state cannot be built", func() {
+		buildErr = errors.New("some-error")
+		_, err := service.Execute(context.Background(), TransportHTTP, &Request{
+			Action: "DeleteUser",
+			Data:   json.RawMessage(`{"userID":"test-user"}`),
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(handled).To(BeEmpty())
+	})
+
+	It("should hold the Lock while building state and handling Commands", func() {
+		lock := &testLock{}
+		heldDuring := []bool{}
+		service.Lock = lock
+		service.BuildState = func(context.Context) error {
+			heldDuring = append(heldDuring, lock.held)
+			return nil
+		}
+		service.Handle = func(context.Context, *model.Command) *model.Document {
+			heldDuring = append(heldDuring, lock.held)
+			return result
+		}
+
+		_, err := service.Execute(context.Background(), TransportHTTP, &Request{
+			Action: "DeleteUser",
+			Data:   json.RawMessage(`{"userID":"test-user"}`),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(heldDuring).To(Equal([]bool{true, true}))
+		Expect(lock.held).To(BeFalse())
+	})
+
+	Describe("HTTP", func() {
+		var mux *http.ServeMux
+
+		BeforeEach(func() {
+			mux = http.NewServeMux()
+			service.RegisterHTTP(mux)
+		})
+
+		post := func(body string) (*httptest.ResponseRecorder, *model.Document) {
+			req := httptest.NewRequest(http.MethodPost, HTTPPath, bytes.NewBufferString(body))
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			doc := &model.Document{}
+			err := json.Unmarshal(rec.Body.Bytes(), doc)
+			Expect(err).ToNot(HaveOccurred())
+			return rec, doc
+		}
+
+		It("should return the Response-Document", func() {
+			rec, doc := post(`{"action":"DeleteUser","data":{"userID":"test-user"}}`)
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Header().Get("Content-Type")).To(Equal("application/json"))
+			Expect(doc).To(Equal(result))
+		})
+
+		It("should map errors to HTTP-status", func() {
+			rec, doc := post(`{"action":`)
+			Expect(rec.Code).To(Equal(http.StatusBadRequest))
+			Expect(doc.ErrorCode).To(Equal(model.UserError))
+
+			result = &model.Document{
+				Error:     "user not found",
+				ErrorCode: model.UserError,
+			}
+			rec, _ = post(`{"action":"DeleteUser","data":{"userID":"test-user"}}`)
+			Expect(rec.Code).To(Equal(http.StatusBadRequest))
+
+			buildErr = errors.New("some-error")
+			rec, doc = post(`{"action":"DeleteUser","data":{"userID":"test-user"}}`)
+			Expect(rec.Code).To(Equal(http.StatusServiceUnavailable))
+			Expect(doc.ErrorCode).To(Equal(model.InternalError))
+		})
+
+		It("should pass the bearer-token to the Handler", func() {
+			result = &model.Document{
+				Error:     "unauthorized",
+				ErrorCode: auth.UnauthorizedError,
+			}
+			req := httptest.NewRequest(
+				http.MethodPost,
+				HTTPPath,
+				bytes.NewBufferString(`{"action":"DeleteUser","data":{"userID":"test-user"}}`),
+			)
+			req.Header.Set("Authorization", "Bearer test-token")
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			Expect(rec.Code).To(Equal(http.StatusForbidden))
+			Expect(tokens).To(Equal([]string{"test-token"}))
+		})
+
+		It("should explain decisions without handling Commands", func() {
+			req := httptest.NewRequest(
+				http.MethodPost,
+				ExplainPath,
+				bytes.NewBufferString(`{"action":"DeleteUser","data":{"userID":"test-user"}}`),
+			)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Body.String()).To(MatchJSON(
+				`{"allowed":false,"action":"DeleteUser","reason":"test-reason"}`,
+			))
+			Expect(handled).To(BeEmpty())
+		})
+
+		It("should only accept POST", func() {
+			req := httptest.NewRequest(http.MethodGet, HTTPPath, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			Expect(rec.Code).To(Equal(http.StatusMethodNotAllowed))
+		})
+	})
+
+	Describe("gRPC", func() {
+		var (
+			server *grpc.Server
+			conn   *grpc.ClientConn
+		)
+
+		BeforeEach(func() {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+			server = service.NewGRPCServer()
+			go server.Serve(listener)
+
+			conn, err = grpc.Dial(
+				listener.Addr().String(),
+				grpc.WithTransportCredentials(insecure.NewCredentials()),
+				grpc.WithDefaultCallOptions(grpc.ForceCodec(Codec{})),
+			)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			conn.Close()
+			server.Stop()
+		})
+
+		It("should return the Response-Document", func() {
+			doc := &model.Document{}
+			err := conn.Invoke(context.Background(), GRPCExecuteMethod, &Request{
+				Action: "UpdateUser",
+				Data:   json.RawMessage(`{"filter":{"userID":"test-user"}}`),
+			}, doc)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(doc).To(Equal(result))
+			Expect(handled[0].Action).To(Equal("UpdateUser"))
+		})
+
+		It("should pass the bearer-token from metadata to the Handler", func() {
+			ctx := metadata.AppendToOutgoingContext(
+				context.Background(),
+				auth.HeaderAuthorization, "Bearer test-token",
+			)
+			err := conn.Invoke(ctx, GRPCExecuteMethod, &Request{
+				Action: "DeleteUser",
+				Data:   json.RawMessage(`{"userID":"test-user"}`),
+			}, &model.Document{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tokens).To(Equal([]string{"test-token"}))
+		})
+
+		It("should return InvalidArgument for invalid Requests", func() {
+			err := conn.Invoke(context.Background(), GRPCExecuteMethod, &Request{
+				Action: "DropUsers",
+			}, &model.Document{})
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+		})
+	})
+})
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+
+	"github.com/TerrexTech/agg-userauth-cmd/auth"
+	"github.com/TerrexTech/go-common-models/model"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCServiceName is the full name of the gRPC service.
+const GRPCServiceName = "agg.userauth.CommandService"
+
+// GRPCExecuteMethod is the full name of the gRPC method for executing
+// Requests. The method receives a Request and returns a model.Document.
+const GRPCExecuteMethod = "/" + GRPCServiceName + "/Execute"
+
+// Codec marshals the gRPC-messages as JSON, so the API can be used without
+// generated protobuf-code. Clients set it using grpc.ForceCodec.
+type Codec struct{}
+
+// Marshal returns the JSON-encoding of v.
+func (Codec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal decodes the JSON-data into v.
+func (Codec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// Name returns the content-subtype of the Codec.
+func (Codec) Name() string {
+	return "json"
+}
+
+// grpcServer is the HandlerType of the gRPC service.
+type grpcServer interface {
+	executeGRPC(ctx context.Context, req *Request) (*model.Document, error)
+}
+
+var grpcServiceDesc = grpc.ServiceDesc{
+	ServiceName: GRPCServiceName,
+	HandlerType: (*grpcServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Execute",
+			Handler:    grpcExecuteHandler,
+		},
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+func grpcExecuteHandler(
+	srv interface{},
+	ctx context.Context,
+	dec func(interface{}) error,
+	interceptor grpc.UnaryServerInterceptor,
+) (interface{}, error) {
+	req := &Request{}
+	err := dec(req)
+	if err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(grpcServer).executeGRPC(ctx, req)
+	}
+
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: GRPCExecuteMethod,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(grpcServer).executeGRPC(ctx, req.(*Request))
+	}
+	return interceptor(ctx, req, info, handler)
+}
+
+// executeGRPC executes the Request. Errors from handling the Command are
+// returned in the Document, while invalid Requests are returned as
+// InvalidArgument, and Commands that could not be handled as Unavailable.
+// The signed token and userUUID are read from the request's metadata.
+func (s *Service) executeGRPC(ctx context.Context, req *Request) (*model.Document, error) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(auth.HeaderAuthorization); len(values) > 0 {
+			ctx = auth.WithToken(ctx, auth.BearerToken(values[0]))
+		}
+		if values := md.Get(auth.HeaderUserUUID); len(values) > 0 {
+			ctx = auth.WithUserUUID(ctx, values[0])
+		}
+	}
+	doc, err := s.Execute(ctx, TransportGRPC, req)
+	if err != nil {
+		if _, ok := err.(*RequestError); ok {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		s.Logger.Error(err)
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return doc, nil
+}
+
+// NewGRPCServer creates a gRPC-server serving the API.
+func (s *Service) NewGRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts, grpc.ForceServerCodec(Codec{}))
+	server := grpc.NewServer(opts...)
+	server.RegisterService(&grpcServiceDesc, s)
+	return server
+}
+
+// ServeGRPC serves the gRPC API on addr until the context is done.
+func (s *Service) ServeGRPC(ctx context.Context, g *errgroup.Group, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		err = errors.Wrapf(err, "Error listening on %s", addr)
+		return err
+	}
+	server := s.NewGRPCServer()
+
+	g.Go(func() error {
+		s.Logger.Infof("Starting gRPC-server on %s", addr)
+		err := server.Serve(listener)
+		if err != nil {
+			err = errors.Wrap(err, "Error in gRPC-server")
+			return err
+		}
+		return nil
+	})
+
+	go func() {
+		<-ctx.Done()
+		server.GracefulStop()
+	}()
+	return nil
+}
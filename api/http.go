@@ -0,0 +1,136 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/TerrexTech/agg-userauth-cmd/auth"
+	"github.com/TerrexTech/go-common-models/model"
+	"github.com/pkg/errors"
+)
+
+// Transports the Requests are received over.
+const (
+	TransportHTTP = "http"
+	TransportGRPC = "grpc"
+)
+
+// HTTPPath is the path of the HTTP API. Requests are POSTed as JSON, and
+// the Command's Response-Document is returned as JSON.
+const HTTPPath = "/v1/commands"
+
+// ExplainPath is the path of the dry-run endpoint. Requests are POSTed as
+// to HTTPPath, and the auth.Decision for their Command is returned as JSON
+// without handling the Command.
+const ExplainPath = HTTPPath + "/explain"
+
+// maxBodyBytes limits the size of HTTP request-bodies.
+const maxBodyBytes = 1 << 20
+
+// RegisterHTTP adds the HTTP API to the mux.
+func (s *Service) RegisterHTTP(mux *http.ServeMux) {
+	mux.HandleFunc(HTTPPath, s.serveHTTP)
+	if s.Explain != nil {
+		mux.HandleFunc(ExplainPath, s.serveExplain)
+	}
+}
+
+func (s *Service) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, req, ok := s.readRequest(w, r)
+	if !ok {
+		return
+	}
+	doc, err := s.Execute(ctx, TransportHTTP, req)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	s.writeJSON(w, httpStatus(doc), doc)
+}
+
+func (s *Service) serveExplain(w http.ResponseWriter, r *http.Request) {
+	ctx, req, ok := s.readRequest(w, r)
+	if !ok {
+		return
+	}
+	decision, err := s.ExplainRequest(ctx, TransportHTTP, req)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, decision)
+}
+
+// readRequest decodes the Request, and returns a context carrying the
+// request's bearer-token. The error-response is written if the request
+// is invalid.
+func (s *Service) readRequest(
+	w http.ResponseWriter,
+	r *http.Request,
+) (context.Context, *Request, bool) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		doc := s.errorDoc(model.UserError, "method not allowed")
+		s.writeJSON(w, http.StatusMethodNotAllowed, doc)
+		return nil, nil, false
+	}
+
+	req := &Request{}
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes)).Decode(req)
+	if err != nil {
+		err = errors.Wrap(err, "Error decoding request-body")
+		s.writeJSON(w, http.StatusBadRequest, s.errorDoc(model.UserError, err.Error()))
+		return nil, nil, false
+	}
+
+	token := auth.BearerToken(r.Header.Get(auth.HeaderAuthorization))
+	ctx := auth.WithToken(r.Context(), token)
+	ctx = auth.WithUserUUID(ctx, r.Header.Get(auth.HeaderUserUUID))
+	return ctx, req, true
+}
+
+// writeError writes the error-response for Requests that failed before
+// their Command was handled.
+func (s *Service) writeError(w http.ResponseWriter, err error) {
+	if _, ok := err.(*RequestError); ok {
+		s.writeJSON(w, http.StatusBadRequest, s.errorDoc(model.UserError, err.Error()))
+		return
+	}
+	s.Logger.Error(err)
+	s.writeJSON(w, http.StatusServiceUnavailable, s.errorDoc(model.InternalError, err.Error()))
+}
+
+// httpStatus returns the HTTP-status for the Command's Response.
+func httpStatus(doc *model.Document) int {
+	switch doc.ErrorCode {
+	case 0:
+		return http.StatusOK
+	case model.UserError:
+		return http.StatusBadRequest
+	case auth.UnauthorizedError:
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// errorDoc creates a Response-Document for Requests
+// that failed before their Command was handled.
+func (s *Service) errorDoc(code int16, msg string) *model.Document {
+	return &model.Document{
+		Error:     msg,
+		ErrorCode: code,
+		Source:    s.ServiceName,
+	}
+}
+
+func (s *Service) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		err = errors.Wrap(err, "Error writing HTTP-response")
+		s.Logger.Error(err)
+	}
+}
@@ -0,0 +1,351 @@
+// Package auth authenticates the actor issuing a Command, and authorizes the
+// Command against the policy.
+//
+// The actor is the subject of the signed token carried with the Command, or
+// the userUUID carried with it if tokens are not required. Deactivated and
+// deleted actors are rejected. Commands are decided by the rules of the
+// attribute-based Policy if any matches, else actors may issue the Commands
+// permitted by their roles, and may update their own profile.
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/TerrexTech/agg-userauth-cmd/fieldcrypt"
+	"github.com/TerrexTech/agg-userauth-cmd/lifecycle"
+	"github.com/TerrexTech/agg-userauth-cmd/rbac"
+	"github.com/TerrexTech/agg-userauth-cmd/secrets"
+	"github.com/TerrexTech/agg-userauth-model/user"
+	"github.com/TerrexTech/go-common-models/model"
+	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/pkg/errors"
+)
+
+// UnauthorizedError is the error-code for Commands whose actor could not be
+// authenticated, or is not permitted to issue the Command.
+const UnauthorizedError int16 = 4
+
+// selfActions are the Command-Actions actors may issue for themselves
+// without holding the permission, and the only fields they may change doing
+// so. Privileged fields, such as the role, cannot be changed by the users
+// themselves.
+var selfActions = map[string][]string{
+	"UpdateUser": []string{"email", "firstName", "lastName", "password"},
+	// Users may access the data kept on them
+	"ExportUserData": nil,
+}
+
+// Actor is the user issuing a Command.
+type Actor struct {
+	UserID string
+}
+
+// Resource is what a Command applies to.
+type Resource struct {
+	// Targets are the users the Command applies to.
+	Targets []*user.User
+	// Fields are the user-fields the Command changes.
+	Fields []string
+}
+
+// Decision explains whether a Command is authorized.
+type Decision struct {
+	Allowed bool   `json:"allowed"`
+	Action  string `json:"action"`
+	Actor   string `json:"actor,omitempty"`
+	// Rule is the policy-rule that decided the Command, and is blank if the
+	// Command was decided by the actor's role-permissions.
+	Rule   string `json:"rule,omitempty"`
+	Reason string `json:"reason"`
+	// Rules are the results of all policy-rules.
+	Rules []*RuleResult `json:"rules,omitempty"`
+}
+
+// Config is the config for the Authorizer.
+type Config struct {
+	Users *mongo.Collection
+	// Fields is optional, and decrypts the encrypted fields of Users.
+	Fields *fieldcrypt.Codec
+	// Roles is optional. If set, the permissions of actors are those of their
+	// roles in the role-catalogue.
+	Roles *rbac.Store
+	// Policy is optional. If set, its rules decide Commands before the
+	// role-permissions are checked.
+	Policy *Policy
+
+	// SigningKey verifies signed tokens. Tokens are rejected while it is blank.
+	SigningKey *secrets.Value
+	// RequireToken rejects Commands without a signed token. Else the
+	// userUUID carried with the Command is trusted as actor.
+	RequireToken bool
+	// AdminRole is the role holding all permissions if Roles is not set.
+	AdminRole string
+	// Lifecycle is optional. If set, deactivated and deleted actors are
+	// not authenticated.
+	Lifecycle *lifecycle.Store
+}
+
+// Authorizer authorizes Commands.
+type Authorizer struct {
+	*Config
+}
+
+// NewAuthorizer creates a new Authorizer.
+func NewAuthorizer(config *Config) (*Authorizer, error) {
+	if config == nil {
+		return nil, errors.New("config cannot be nil")
+	}
+	if config.Users == nil {
+		return nil, errors.New("Users cannot be nil")
+	}
+	if config.SigningKey == nil {
+		return nil, errors.New("SigningKey cannot be nil")
+	}
+	return &Authorizer{
+		config,
+	}, nil
+}
+
+// Authorize returns an error if the actor of the Command is not permitted to
+// issue it for the resource.
+func (a *Authorizer) Authorize(
+	ctx context.Context,
+	cmd *model.Command,
+	res *Resource,
+) *model.Error {
+	decision, err := a.Decide(ctx, cmd, res)
+	if err != nil {
+		err = errors.Wrap(err, "Error authorizing Command")
+		return model.NewError(model.DatabaseError, err.Error())
+	}
+	if decision.Allowed {
+		return nil
+	}
+	err = errors.New("unauthorized: " + decision.Reason)
+	return model.NewError(UnauthorizedError, err.Error())
+}
+
+// Decide decides whether the actor of the Command is permitted to issue it
+// for the resource, and explains why. Errors are only returned if the
+// actor's attributes could not be fetched.
+func (a *Authorizer) Decide(
+	ctx context.Context,
+	cmd *model.Command,
+	res *Resource,
+) (*Decision, error) {
+	decision := &Decision{
+		Action: cmd.Action,
+	}
+	actor, err := a.Authenticate(ctx)
+	if err != nil {
+		decision.Reason = "authentication failed: " + err.Error()
+		return decision, nil
+	}
+	decision.Actor = actor.UserID
+
+	attrs, roles, err := a.actorAttributes(actor)
+	if err != nil {
+		return nil, err
+	}
+
+	targetIDs := make([]string, len(res.Targets))
+	targetAttrs := make([]Attributes, len(res.Targets))
+	for i, t := range res.Targets {
+		targetIDs[i] = t.UserID
+		targetAttrs[i] = userAttributes(t)
+	}
+
+	if a.Policy != nil {
+		var rule *RuleResult
+		decision.Rules, rule = a.Policy.evaluate(&policyInput{
+			action:  cmd.Action,
+			actor:   attrs,
+			targets: targetAttrs,
+			fields:  res.Fields,
+		})
+		if rule != nil {
+			decision.Allowed = rule.Effect == EffectAllow
+			decision.Rule = rule.Rule
+			decision.Reason = "decided by policy-rule " + rule.Rule
+			return decision, nil
+		}
+	}
+
+	permissions, err := a.permissions(roles)
+	if err != nil {
+		return nil, err
+	}
+	decision.Allowed = Allow(actor, permissions, cmd.Action, targetIDs, res.Fields)
+	if decision.Allowed {
+		decision.Reason = "permitted by actor's roles"
+	} else {
+		decision.Reason = "actor is not permitted to issue " + cmd.Action
+	}
+	return decision, nil
+}
+
+// Authenticate returns the actor issuing the Command, from the token and
+// userUUID carried by the context. Deactivated and deleted actors are
+// rejected.
+func (a *Authorizer) Authenticate(ctx context.Context) (*Actor, error) {
+	actor, err := a.identify(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if a.Lifecycle != nil {
+		record, err := a.Lifecycle.Record(actor.UserID)
+		if err != nil {
+			err = errors.Wrap(err, "Error finding lifecycle-record of actor")
+			return nil, err
+		}
+		if record != nil {
+			return nil, errors.Errorf("actor is %s", record.Status)
+		}
+	}
+	return actor, nil
+}
+
+// identify returns the actor from the token and userUUID carried by the
+// context.
+func (a *Authorizer) identify(ctx context.Context) (*Actor, error) {
+	userUUID := UserUUIDFromContext(ctx)
+	token := TokenFromContext(ctx)
+	if token == "" {
+		if a.RequireToken {
+			return nil, errors.New("missing token")
+		}
+		if userUUID == "" {
+			return nil, errors.New("missing token or userUUID")
+		}
+		return &Actor{
+			UserID: userUUID,
+		}, nil
+	}
+
+	key := a.SigningKey.Get()
+	if key == "" {
+		return nil, errors.New("tokens are not accepted without a signing-key")
+	}
+	claims, err := ParseToken([]byte(key), token, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if userUUID != "" && userUUID != claims.Subject {
+		return nil, errors.New("userUUID does not match token-subject")
+	}
+	return &Actor{
+		UserID: claims.Subject,
+	}, nil
+}
+
+// actorAttributes returns the attributes and roles of the actor. Actors
+// without a user, such as services, only have the userID and roles attributes.
+func (a *Authorizer) actorAttributes(actor *Actor) (Attributes, []string, error) {
+	result, err := a.Users.Find(&user.User{
+		UserID: actor.UserID,
+	})
+	if err != nil {
+		err = errors.Wrap(err, "Error finding actor")
+		return nil, nil, err
+	}
+	err = a.Fields.DecryptResults(result)
+	if err != nil {
+		err = errors.Wrap(err, "Error decrypting actor")
+		return nil, nil, err
+	}
+	attrs := Attributes{}
+	roles := []string{}
+	for _, r := range result {
+		if u, assertOK := r.(*user.User); assertOK {
+			attrs = userAttributes(u)
+			if u.Role != "" {
+				roles = append(roles, u.Role)
+			}
+		}
+	}
+	attrs["userID"] = actor.UserID
+
+	if a.Roles != nil {
+		roles, err = a.Roles.UserRoles(actor.UserID)
+		if err != nil {
+			err = errors.Wrap(err, "Error finding roles of actor")
+			return nil, nil, err
+		}
+	}
+	attrs["roles"] = roles
+	return attrs, roles, nil
+}
+
+// permissions returns the permissions of the roles.
+func (a *Authorizer) permissions(roles []string) ([]string, error) {
+	if a.Roles == nil {
+		if a.AdminRole != "" && rbac.HasRole(roles, a.AdminRole) {
+			return []string{rbac.PermissionAll}, nil
+		}
+		return nil, nil
+	}
+
+	permissions := []string{}
+	for _, name := range roles {
+		role, err := a.Roles.Role(name)
+		if err != nil {
+			err = errors.Wrap(err, "Error finding role")
+			return nil, err
+		}
+		if role != nil {
+			permissions = append(permissions, role.Permissions...)
+		}
+	}
+	return permissions, nil
+}
+
+// userAttributes returns the fields of the user, except its password.
+func userAttributes(u *user.User) Attributes {
+	attrs := Attributes{}
+	data, err := json.Marshal(u)
+	if err == nil {
+		err = json.Unmarshal(data, &attrs)
+	}
+	if err != nil {
+		attrs = Attributes{
+			"userID": u.UserID,
+		}
+	}
+	delete(attrs, "password")
+	return attrs
+}
+
+// Allow returns true if the actor holding the permissions may issue the
+// Command-Action for the targets, changing the fields. Actors may issue
+// selfActions if they are the only target, and only change the fields
+// allowed for the Action.
+func Allow(
+	actor *Actor,
+	permissions []string,
+	action string,
+	targets []string,
+	fields []string,
+) bool {
+	for _, p := range permissions {
+		if p == rbac.PermissionAll || p == action {
+			return true
+		}
+	}
+	selfFields, isSelfAction := selfActions[action]
+	if !isSelfAction || len(targets) == 0 {
+		return false
+	}
+	for _, t := range targets {
+		if t != actor.UserID {
+			return false
+		}
+	}
+	for _, field := range fields {
+		if !containsAny(selfFields, field) {
+			return false
+		}
+	}
+	return true
+}
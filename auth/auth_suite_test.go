@@ -0,0 +1,284 @@
+package auth
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TerrexTech/agg-userauth-cmd/rbac"
+	"github.com/TerrexTech/agg-userauth-cmd/secrets"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// TestAuth tests authenticating and authorizing Commands.
+func TestAuth(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Auth Suite")
+}
+
+var _ = Describe("Auth", func() {
+	key := []byte("test-key")
+
+	Describe("Token", func() {
+		It("should parse signed tokens", func() {
+			token, err := SignToken(key, &Claims{
+				Subject:   "test-user",
+				ExpiresAt: time.Now().Add(time.Minute).Unix(),
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			claims, err := ParseToken(key, token, time.Now())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(claims.Subject).To(Equal("test-user"))
+		})
+
+		It("should reject tokens with invalid signatures", func() {
+			token, err := SignToken([]byte("other-key"), &Claims{Subject: "test-user"})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = ParseToken(key, token, time.Now())
+			Expect(err).To(HaveOccurred())
+
+			_, err = ParseToken(key, "not-a-token", time.Now())
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should reject expired tokens", func() {
+			token, err := SignToken(key, &Claims{
+				Subject:   "test-user",
+				ExpiresAt: time.Now().Unix(),
+			})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = ParseToken(key, token, time.Now().Add(time.Second))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should carry bearer-tokens in context", func() {
+			token := BearerToken("Bearer test-token")
+			Expect(token).To(Equal("test-token"))
+			Expect(BearerToken("Basic dXNlcjpwYXNz")).To(BeEmpty())
+
+			ctx := WithToken(context.Background(), token)
+			Expect(TokenFromContext(ctx)).To(Equal("test-token"))
+			Expect(TokenFromContext(context.Background())).To(BeEmpty())
+		})
+	})
+
+	Describe("Authenticate", func() {
+		authorizer := &Authorizer{&Config{
+			SigningKey: secrets.NewValue(string(key)),
+		}}
+
+		It("should authenticate actors by their userUUID if tokens are optional", func() {
+			ctx := WithUserUUID(context.Background(), "test-user")
+			actor, err := authorizer.Authenticate(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(actor.UserID).To(Equal("test-user"))
+
+			_, err = authorizer.Authenticate(context.Background())
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should reject userUUIDs not matching the token-subject", func() {
+			token, err := SignToken(key, &Claims{
+				Subject:   "test-user",
+				ExpiresAt: time.Now().Add(time.Minute).Unix(),
+			})
+			Expect(err).ToNot(HaveOccurred())
+			ctx := WithToken(context.Background(), token)
+
+			actor, err := authorizer.Authenticate(WithUserUUID(ctx, "test-user"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(actor.UserID).To(Equal("test-user"))
+
+			_, err = authorizer.Authenticate(WithUserUUID(ctx, "other-user"))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Policy", func() {
+		policy := &Policy{
+			Rules: []*Rule{
+				{
+					Name:    "support-updates-tenant",
+					Effect:  EffectAllow,
+					Actions: []string{"UpdateUser"},
+					Actor:   map[string]string{"roles": "support"},
+					Target:  map[string]string{"tenant": "$actor.tenant"},
+				},
+				{
+					Name:    "support-keeps-role",
+					Effect:  EffectDeny,
+					Actions: []string{"*"},
+					Actor:   map[string]string{"roles": "support"},
+					Fields:  []string{"role"},
+				},
+			},
+		}
+		support := Attributes{
+			"userID": "support-user",
+			"roles":  []string{"support"},
+			"tenant": "tenant-1",
+		}
+
+		It("should match rules by actor and target attributes", func() {
+			results, rule := policy.evaluate(&policyInput{
+				action:  "UpdateUser",
+				actor:   support,
+				targets: []Attributes{{"tenant": "tenant-1"}},
+				fields:  []string{"email"},
+			})
+			Expect(rule).ToNot(BeNil())
+			Expect(rule.Rule).To(Equal("support-updates-tenant"))
+			Expect(results).To(HaveLen(2))
+			Expect(results[1].Matched).To(BeFalse())
+
+			_, rule = policy.evaluate(&policyInput{
+				action:  "UpdateUser",
+				actor:   support,
+				targets: []Attributes{{"tenant": "tenant-1"}, {"tenant": "tenant-2"}},
+			})
+			Expect(rule).To(BeNil())
+		})
+
+		It("should give deny-rules precedence", func() {
+			results, rule := policy.evaluate(&policyInput{
+				action:  "UpdateUser",
+				actor:   support,
+				targets: []Attributes{{"tenant": "tenant-1"}},
+				fields:  []string{"email", "role"},
+			})
+			Expect(rule.Rule).To(Equal("support-keeps-role"))
+			Expect(results[0].Matched).To(BeTrue())
+		})
+
+		It("should explain mismatches", func() {
+			results, rule := policy.evaluate(&policyInput{
+				action: "UpdateUser",
+				actor:  Attributes{"roles": []string{"customer"}},
+			})
+			Expect(rule).To(BeNil())
+			Expect(results[0].Reason).To(Equal("actor.roles does not match support"))
+
+			// The target's attribute is not disclosed
+			results, _ = policy.evaluate(&policyInput{
+				action:  "UpdateUser",
+				actor:   support,
+				targets: []Attributes{{"tenant": "secret-tenant"}},
+			})
+			Expect(results[0].Reason).To(Equal("target.tenant does not match $actor.tenant"))
+			Expect(results[0].Reason).ToNot(ContainSubstring("secret-tenant"))
+		})
+
+		It("should apply deny-rules referencing attributes the actor lacks", func() {
+			tenantPolicy := &Policy{
+				Rules: []*Rule{
+					{
+						Name:    "deny-other-tenants",
+						Effect:  EffectDeny,
+						Actions: []string{"DeleteUser"},
+						Target:  map[string]string{"tenant": "$actor.tenant"},
+					},
+					{
+						Name:    "allow-same-tenant",
+						Effect:  EffectAllow,
+						Actions: []string{"UpdateUser"},
+						Target:  map[string]string{"tenant": "$actor.tenant"},
+					},
+				},
+			}
+			noTenant := Attributes{
+				"userID": "test-user",
+			}
+
+			results, rule := tenantPolicy.evaluate(&policyInput{
+				action:  "DeleteUser",
+				actor:   noTenant,
+				targets: []Attributes{{"tenant": "tenant-1"}},
+			})
+			Expect(rule).ToNot(BeNil())
+			Expect(rule.Rule).To(Equal("deny-other-tenants"))
+			Expect(rule.Effect).To(Equal(EffectDeny))
+			Expect(results[1].Matched).To(BeFalse())
+
+			_, rule = tenantPolicy.evaluate(&policyInput{
+				action:  "UpdateUser",
+				actor:   noTenant,
+				targets: []Attributes{{"tenant": "tenant-1"}},
+			})
+			Expect(rule).To(BeNil())
+		})
+
+		It("should validate policy-files", func() {
+			dir, err := ioutil.TempDir("", "policy-test")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+
+			path := filepath.Join(dir, "policy.yaml")
+			err = ioutil.WriteFile(path, []byte(`
+rules:
+  - name: admins
+    effect: allow
+    actions: ["*"]
+    actor: {roles: admin}
+`), 0644)
+			Expect(err).ToNot(HaveOccurred())
+			loaded, err := LoadPolicy(path)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(loaded.Rules).To(HaveLen(1))
+
+			err = ioutil.WriteFile(path, []byte(`
+rules:
+  - name: admins
+    effect: permit
+    actions: ["*"]
+`), 0644)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = LoadPolicy(path)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Allow", func() {
+		actor := &Actor{
+			UserID: "test-user",
+		}
+
+		It("should allow actors to update only themselves", func() {
+			Expect(Allow(actor, nil, "UpdateUser", []string{"test-user"}, nil)).To(BeTrue())
+			Expect(Allow(actor, nil, "UpdateUser", []string{"other-user"}, nil)).To(BeFalse())
+			Expect(Allow(actor, nil, "UpdateUser", []string{"test-user", "other-user"}, nil)).To(BeFalse())
+			Expect(Allow(actor, nil, "UpdateUser", nil, nil)).To(BeFalse())
+			Expect(Allow(actor, nil, "DeleteUser", []string{"test-user"}, nil)).To(BeFalse())
+		})
+
+		It("should not allow actors to change privileged fields of themselves", func() {
+			self := []string{"test-user"}
+			Expect(Allow(actor, nil, "UpdateUser", self, []string{"firstName", "password"})).
+				To(BeTrue())
+			Expect(Allow(actor, nil, "UpdateUser", self, []string{"role"})).To(BeFalse())
+			Expect(Allow(actor, nil, "UpdateUser", self, []string{"email", "role"})).To(BeFalse())
+			Expect(Allow(actor, nil, "UpdateUser", self, []string{"status"})).To(BeFalse())
+
+			admin := []string{rbac.PermissionAll}
+			Expect(Allow(actor, admin, "UpdateUser", self, []string{"role"})).To(BeTrue())
+		})
+
+		It("should allow actors to export only their own data", func() {
+			Expect(Allow(actor, nil, "ExportUserData", []string{"test-user"}, nil)).To(BeTrue())
+			Expect(Allow(actor, nil, "ExportUserData", []string{"other-user"}, nil)).To(BeFalse())
+		})
+
+		It("should allow actions permitted by roles", func() {
+			permissions := []string{"DeleteUser"}
+			Expect(Allow(actor, permissions, "DeleteUser", []string{"other-user"}, nil)).To(BeTrue())
+			Expect(Allow(actor, permissions, "UpdateUser", []string{"other-user"}, nil)).To(BeFalse())
+
+			admin := []string{rbac.PermissionAll}
+			Expect(Allow(actor, admin, "UpdateUser", []string{"other-user"}, nil)).To(BeTrue())
+		})
+	})
+})
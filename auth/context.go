@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"context"
+	"strings"
+)
+
+// HeaderAuthorization is the message-header, HTTP-header and gRPC-metadata
+// carrying the signed token of a Command, as "Bearer <token>".
+const HeaderAuthorization = "authorization"
+
+// HeaderUserUUID is the message-header, HTTP-header and gRPC-metadata
+// carrying the userUUID of the actor issuing a Command without a token.
+const HeaderUserUUID = "user-uuid"
+
+type tokenKey struct{}
+
+type userUUIDKey struct{}
+
+// WithToken returns a context carrying the token.
+func WithToken(ctx context.Context, token string) context.Context {
+	if token == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, tokenKey{}, token)
+}
+
+// TokenFromContext returns the token carried by the context,
+// or an empty string if it carries none.
+func TokenFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	token, _ := ctx.Value(tokenKey{}).(string)
+	return token
+}
+
+// WithUserUUID returns a context carrying the userUUID of the actor.
+func WithUserUUID(ctx context.Context, userUUID string) context.Context {
+	userUUID = strings.TrimSpace(userUUID)
+	if userUUID == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, userUUIDKey{}, userUUID)
+}
+
+// UserUUIDFromContext returns the userUUID carried by the context,
+// or an empty string if it carries none.
+func UserUUIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	userUUID, _ := ctx.Value(userUUIDKey{}).(string)
+	return userUUID
+}
+
+// BearerToken returns the token from the value of an authorization-header.
+func BearerToken(header string) string {
+	header = strings.TrimSpace(header)
+	if len(header) > 7 && strings.EqualFold(header[:7], "bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
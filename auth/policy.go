@@ -0,0 +1,248 @@
+package auth
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Rule-effects.
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+)
+
+// actorRef prefixes condition-values referencing an attribute of the actor.
+const actorRef = "$actor."
+
+// Attributes are the attributes of a user, such as its fields and roles.
+type Attributes map[string]interface{}
+
+// Policy is a set of attribute-based rules, which decide Commands before
+// the actor's role-permissions are checked. Deny-rules take precedence
+// over allow-rules.
+type Policy struct {
+	Rules []*Rule `yaml:"rules"`
+}
+
+// Rule decides the Commands matching all of its conditions.
+type Rule struct {
+	Name   string `yaml:"name"`
+	Effect string `yaml:"effect"`
+	// Actions are the Command-Actions the rule applies to, or "*" for all.
+	Actions []string `yaml:"actions"`
+	// Actor and Target are conditions on the attributes of the actor and of
+	// each user the Command applies to. Values prefixed with "$actor." are
+	// compared to the actor's attribute, such as "$actor.tenant".
+	Actor  map[string]string `yaml:"actor"`
+	Target map[string]string `yaml:"target"`
+	// Fields limits the rule to Commands changing any of the fields,
+	// such as the fields updated by UpdateUser.
+	Fields []string `yaml:"fields"`
+}
+
+// RuleResult explains whether a rule matched the Command.
+type RuleResult struct {
+	Rule    string `json:"rule"`
+	Effect  string `json:"effect"`
+	Matched bool   `json:"matched"`
+	Reason  string `json:"reason"`
+}
+
+// LoadPolicy reads the Policy from the YAML-file.
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		err = errors.Wrapf(err, "Error reading policy-file %s", path)
+		return nil, err
+	}
+	policy := &Policy{}
+	err = yaml.UnmarshalStrict(data, policy)
+	if err != nil {
+		err = errors.Wrapf(err, "Error parsing policy-file %s", path)
+		return nil, err
+	}
+	err = policy.Validate()
+	if err != nil {
+		err = errors.Wrapf(err, "Error in policy-file %s", path)
+		return nil, err
+	}
+	return policy, nil
+}
+
+// Validate checks that the rules are named, and have an effect and actions.
+func (p *Policy) Validate() error {
+	names := map[string]bool{}
+	for i, rule := range p.Rules {
+		if rule.Name == "" {
+			return errors.Errorf("rules[%d]: missing name", i)
+		}
+		if names[rule.Name] {
+			return errors.Errorf("rules[%d]: duplicate rule %s", i, rule.Name)
+		}
+		names[rule.Name] = true
+		if rule.Effect != EffectAllow && rule.Effect != EffectDeny {
+			return errors.Errorf(
+				"rule %s: effect must be %s or %s", rule.Name, EffectAllow, EffectDeny,
+			)
+		}
+		if len(rule.Actions) == 0 {
+			return errors.Errorf("rule %s: missing actions", rule.Name)
+		}
+	}
+	return nil
+}
+
+// policyInput is the Command evaluated by the Policy.
+type policyInput struct {
+	action  string
+	actor   Attributes
+	targets []Attributes
+	fields  []string
+}
+
+// evaluate returns the results of all rules, and the rule deciding the
+// Command, which is nil if no rule matched.
+func (p *Policy) evaluate(in *policyInput) ([]*RuleResult, *RuleResult) {
+	var allow, deny *RuleResult
+	results := make([]*RuleResult, 0, len(p.Rules))
+	for _, rule := range p.Rules {
+		result := &RuleResult{
+			Rule:   rule.Name,
+			Effect: rule.Effect,
+		}
+		var unknownActorAttr bool
+		result.Reason, unknownActorAttr = rule.mismatch(in)
+		// Deny-rules fail closed, so they apply if the actor lacks an
+		// attribute referenced by their conditions.
+		if unknownActorAttr && rule.Effect == EffectDeny {
+			result.Matched = true
+			result.Reason += ", so the deny-rule applies"
+			if deny == nil {
+				deny = result
+			}
+		} else if result.Reason == "" {
+			result.Matched = true
+			result.Reason = "all conditions matched"
+			if rule.Effect == EffectDeny && deny == nil {
+				deny = result
+			}
+			if rule.Effect == EffectAllow && allow == nil {
+				allow = result
+			}
+		}
+		results = append(results, result)
+	}
+	if deny != nil {
+		return results, deny
+	}
+	return results, allow
+}
+
+// mismatch returns the reason the rule does not match the Command, or an
+// empty string if it matches. unknownActorAttr is true if the rule cannot be
+// decided, as the actor lacks an attribute referenced by its conditions while
+// the other conditions match.
+func (r *Rule) mismatch(in *policyInput) (reason string, unknownActorAttr bool) {
+	if !containsAny(r.Actions, "*", in.action) {
+		return fmt.Sprintf("action %s is not in rule-actions", in.action), false
+	}
+	unknownReason := ""
+	check := func(kind string, attr string, cond string, attrs Attributes) string {
+		reason, unknown := mismatchAttr(kind, attr, cond, attrs, in.actor)
+		if unknown {
+			if unknownReason == "" {
+				unknownReason = reason
+			}
+			return ""
+		}
+		return reason
+	}
+
+	for _, attr := range sortedKeys(r.Actor) {
+		if reason := check("actor", attr, r.Actor[attr], in.actor); reason != "" {
+			return reason, false
+		}
+	}
+	if len(r.Target) > 0 {
+		if len(in.targets) == 0 {
+			return "command has no target-users", false
+		}
+		for _, target := range in.targets {
+			for _, attr := range sortedKeys(r.Target) {
+				if reason := check("target", attr, r.Target[attr], target); reason != "" {
+					return reason, false
+				}
+			}
+		}
+	}
+	if len(r.Fields) > 0 && !containsAny(r.Fields, in.fields...) {
+		return fmt.Sprintf("command does not change fields %v", r.Fields), false
+	}
+	if unknownReason != "" {
+		return unknownReason, true
+	}
+	return "", false
+}
+
+// mismatchAttr returns the reason the attribute does not match the
+// condition, or an empty string if it matches. List-attributes, such as
+// roles, match if they contain the value. Reasons only name the condition,
+// and not the values of the attributes. unknownActorAttr is true if the
+// condition references an attribute the actor lacks.
+func mismatchAttr(
+	kind string,
+	attr string,
+	cond string,
+	attrs Attributes,
+	actor Attributes,
+) (reason string, unknownActorAttr bool) {
+	want := cond
+	if strings.HasPrefix(cond, actorRef) {
+		ref, ok := actor[strings.TrimPrefix(cond, actorRef)]
+		if !ok {
+			return fmt.Sprintf("actor has no attribute for %s", cond), true
+		}
+		want = fmt.Sprint(ref)
+	}
+
+	got, ok := attrs[attr]
+	if !ok {
+		return fmt.Sprintf("%s has no attribute %s", kind, attr), false
+	}
+	if list, isList := got.([]string); isList {
+		if containsAny(list, want) {
+			return "", false
+		}
+	} else if fmt.Sprint(got) == want {
+		return "", false
+	}
+	return fmt.Sprintf("%s.%s does not match %s", kind, attr, cond), false
+}
+
+// sortedKeys returns the attributes of the conditions in order, so the
+// reasons for mismatches are reported consistently.
+func sortedKeys(conds map[string]string) []string {
+	keys := make([]string, 0, len(conds))
+	for k := range conds {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// containsAny returns true if list contains any of the values.
+func containsAny(list []string, values ...string) bool {
+	for _, l := range list {
+		for _, v := range values {
+			if l == v {
+				return true
+			}
+		}
+	}
+	return false
+}
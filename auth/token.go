@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// tokenHeader is the header of signed tokens. Tokens are JWTs signed
+// using HMAC-SHA256 with the signing-key.
+var tokenHeader = base64.RawURLEncoding.EncodeToString(
+	[]byte(`{"alg":"HS256","typ":"JWT"}`),
+)
+
+// Claims are the claims of a signed token.
+type Claims struct {
+	// Subject is the UserID of the actor.
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+}
+
+// SignToken creates a token carrying the claims, signed with the key.
+func SignToken(key []byte, claims *Claims) (string, error) {
+	if len(key) == 0 {
+		return "", errors.New("key cannot be blank")
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		err = errors.Wrap(err, "Error marshalling Claims")
+		return "", err
+	}
+	payload := tokenHeader + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+	return payload + "." + sign(key, payload), nil
+}
+
+// ParseToken verifies the token's signature and expiry, and returns its claims.
+func ParseToken(key []byte, token string, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	if parts[0] != tokenHeader {
+		return nil, errors.New("unsupported token-header, only HS256 is supported")
+	}
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(sign(key, payload))) {
+		return nil, errors.New("invalid token-signature")
+	}
+
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		err = errors.Wrap(err, "Error decoding token-claims")
+		return nil, err
+	}
+	claims := &Claims{}
+	err = json.Unmarshal(claimsJSON, claims)
+	if err != nil {
+		err = errors.Wrap(err, "Error unmarshalling token-claims")
+		return nil, err
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("token is missing subject")
+	}
+	if claims.ExpiresAt != 0 && now.Unix() >= claims.ExpiresAt {
+		return nil, errors.New("token has expired")
+	}
+	return claims, nil
+}
+
+func sign(key []byte, payload string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,164 @@
+// Package broker is an in-memory message-broker, used in place of Kafka when
+// the service runs in standalone-mode for local development.
+//
+// Each topic has a single partition, and messages are retained for the
+// lifetime of the Broker. Producers and consumer-groups implement the sarama
+// interfaces used with Kafka, so the rest of the service is unchanged.
+package broker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+)
+
+// partition is the only partition of each topic.
+const partition int32 = 0
+
+// Broker holds the topics and the offsets committed by consumer-groups.
+type Broker struct {
+	mu     sync.Mutex
+	topics map[string]*topicLog
+	// offsets are the next offsets to consume, by group and topic
+	offsets map[string]map[string]int64
+}
+
+type topicLog struct {
+	messages []*sarama.ConsumerMessage
+	// appended is closed and replaced whenever messages are appended
+	appended chan struct{}
+}
+
+// New creates a Broker without topics. Topics are created
+// when they are first used.
+func New() *Broker {
+	return &Broker{
+		topics:  map[string]*topicLog{},
+		offsets: map[string]map[string]int64{},
+	}
+}
+
+// topic returns the topic, creating it if required.
+// The caller must hold the lock.
+func (b *Broker) topic(name string) *topicLog {
+	t, ok := b.topics[name]
+	if !ok {
+		t = &topicLog{
+			appended: make(chan struct{}),
+		}
+		b.topics[name] = t
+	}
+	return t
+}
+
+// Publish appends the message to its topic, and returns the message
+// as it is consumed.
+func (b *Broker) Publish(msg *sarama.ProducerMessage) (*sarama.ConsumerMessage, error) {
+	if msg == nil {
+		return nil, errors.New("msg cannot be nil")
+	}
+	if msg.Topic == "" {
+		return nil, errors.New("topic cannot be empty")
+	}
+
+	cMsg := &sarama.ConsumerMessage{
+		Topic:     msg.Topic,
+		Partition: partition,
+		Timestamp: msg.Timestamp,
+	}
+	if cMsg.Timestamp.IsZero() {
+		cMsg.Timestamp = time.Now()
+	}
+	var err error
+	if msg.Key != nil {
+		cMsg.Key, err = msg.Key.Encode()
+		if err != nil {
+			err = errors.Wrap(err, "Error encoding message-key")
+			return nil, err
+		}
+	}
+	if msg.Value != nil {
+		cMsg.Value, err = msg.Value.Encode()
+		if err != nil {
+			err = errors.Wrap(err, "Error encoding message-value")
+			return nil, err
+		}
+	}
+	for i := range msg.Headers {
+		header := msg.Headers[i]
+		cMsg.Headers = append(cMsg.Headers, &header)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	t := b.topic(msg.Topic)
+	cMsg.Offset = int64(len(t.messages))
+	t.messages = append(t.messages, cMsg)
+	close(t.appended)
+	t.appended = make(chan struct{})
+
+	msg.Partition = partition
+	msg.Offset = cMsg.Offset
+	return cMsg, nil
+}
+
+// Messages returns the messages in the topic starting at the offset,
+// and a channel that is closed once more messages are appended.
+func (b *Broker) Messages(
+	topic string,
+	offset int64,
+) ([]*sarama.ConsumerMessage, <-chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	t := b.topic(topic)
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= int64(len(t.messages)) {
+		return nil, t.appended
+	}
+	msgs := make([]*sarama.ConsumerMessage, len(t.messages)-int(offset))
+	copy(msgs, t.messages[offset:])
+	return msgs, t.appended
+}
+
+// Topics returns the names of the topics.
+func (b *Broker) Topics() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	names := make([]string, 0, len(b.topics))
+	for name := range b.topics {
+		names = append(names, name)
+	}
+	return names
+}
+
+// highWaterMark returns the offset of the next message in the topic.
+func (b *Broker) highWaterMark(topic string) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return int64(len(b.topic(topic).messages))
+}
+
+// offset returns the next offset to consume from the topic for the group.
+func (b *Broker) offset(group string, topic string) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.offsets[group][topic]
+}
+
+// markOffset sets the next offset to consume from the topic for the group.
+func (b *Broker) markOffset(group string, topic string, offset int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.offsets[group] == nil {
+		b.offsets[group] = map[string]int64{}
+	}
+	b.offsets[group][topic] = offset
+}
@@ -0,0 +1,199 @@
+package broker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/TerrexTech/go-common-models/model"
+	"github.com/TerrexTech/uuuid"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// TestBroker tests the in-memory broker.
+func TestBroker(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Broker Suite")
+}
+
+// testHandler collects the consumed messages, marking each as consumed.
+type testHandler struct {
+	messages chan *sarama.ConsumerMessage
+}
+
+func (h *testHandler) Setup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (h *testHandler) Cleanup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (h *testHandler) ConsumeClaim(
+	sess sarama.ConsumerGroupSession,
+	claim sarama.ConsumerGroupClaim,
+) error {
+	for msg := range claim.Messages() {
+		sess.MarkMessage(msg, "")
+		h.messages <- msg
+	}
+	return nil
+}
+
+func publish(b *Broker, topic string, value string) *sarama.ConsumerMessage {
+	msg, err := b.Publish(&sarama.ProducerMessage{
+		Topic: topic,
+		Key:   sarama.StringEncoder("key"),
+		Value: sarama.StringEncoder(value),
+		Headers: []sarama.RecordHeader{
+			{Key: []byte("header"), Value: []byte("value")},
+		},
+	})
+	Expect(err).ToNot(HaveOccurred())
+	return msg
+}
+
+var _ = Describe("Broker", func() {
+	var b *Broker
+
+	BeforeEach(func() {
+		b = New()
+	})
+
+	It("should return published messages from offset", func() {
+		publish(b, "topic", "one")
+		msg := publish(b, "topic", "two")
+		Expect(msg.Offset).To(Equal(int64(1)))
+		Expect(msg.Key).To(Equal([]byte("key")))
+		Expect(msg.Headers).To(HaveLen(1))
+
+		msgs, _ := b.Messages("topic", 1)
+		Expect(msgs).To(HaveLen(1))
+		Expect(string(msgs[0].Value)).To(Equal("two"))
+
+		msgs, appended := b.Messages("topic", 2)
+		Expect(msgs).To(BeEmpty())
+		publish(b, "topic", "three")
+		Expect(appended).To(BeClosed())
+	})
+
+	It("should report producer-successes", func() {
+		config := sarama.NewConfig()
+		config.Producer.Return.Successes = true
+		prod := b.NewAsyncProducer(config)
+		defer prod.Close()
+
+		prod.Input() <- &sarama.ProducerMessage{
+			Topic: "topic",
+			Value: sarama.StringEncoder("value"),
+		}
+		Eventually(prod.Successes()).Should(Receive())
+
+		prod.Input() <- &sarama.ProducerMessage{}
+		Eventually(prod.Errors()).Should(Receive())
+
+		Expect(prod.BeginTxn()).To(Equal(ErrTransactionsUnsupported))
+	})
+
+	It("should resume consumer-groups from marked offsets", func() {
+		publish(b, "topic", "one")
+
+		cons, err := b.NewConsumer("group", []string{"topic"})
+		Expect(err).ToNot(HaveOccurred())
+		handler := &testHandler{
+			messages: make(chan *sarama.ConsumerMessage, 10),
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() {
+			done <- cons.Consume(ctx, handler)
+		}()
+		publish(b, "topic", "two")
+
+		var msg *sarama.ConsumerMessage
+		Eventually(handler.messages).Should(Receive(&msg))
+		Expect(string(msg.Value)).To(Equal("one"))
+		Eventually(handler.messages).Should(Receive(&msg))
+		Expect(string(msg.Value)).To(Equal("two"))
+
+		cancel()
+		Eventually(done).Should(Receive(BeNil()))
+
+		// Consumed messages are not consumed again by the group
+		publish(b, "topic", "three")
+		ctx, cancel = context.WithCancel(context.Background())
+		defer cancel()
+		go cons.Consume(ctx, handler)
+		Eventually(handler.messages).Should(Receive(&msg))
+		Expect(string(msg.Value)).To(Equal("three"))
+	})
+
+	It("should return each Event of the Aggregate once", func() {
+		store, err := b.NewEventStore("events", 1)
+		Expect(err).ToNot(HaveOccurred())
+
+		for _, event := range []*model.Event{
+			{Action: "UserRegistered", AggregateID: 1},
+			{Action: "OtherRegistered", AggregateID: 2},
+			{Action: "UserUpdated", AggregateID: 1},
+		} {
+			value, err := json.Marshal(event)
+			Expect(err).ToNot(HaveOccurred())
+			publish(b, "events", string(value))
+		}
+
+		eventRespChan, err := store.BuildState(uuuid.UUID{}, 1)
+		Expect(err).ToNot(HaveOccurred())
+		actions := []string{}
+		for eventResp := range eventRespChan {
+			Expect(eventResp.Error).ToNot(HaveOccurred())
+			actions = append(actions, eventResp.Event.Action)
+			if eventResp.Event.Action == "UserUpdated" {
+				Expect(eventResp.Event.Version).To(Equal(int64(3)))
+			}
+		}
+		Expect(actions).To(Equal([]string{"UserRegistered", "UserUpdated"}))
+
+		eventRespChan, err = store.BuildState(uuuid.UUID{}, 1)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(eventRespChan).To(BeClosed())
+	})
+
+	It("should publish and read messages over HTTP", func() {
+		mux := http.NewServeMux()
+		b.RegisterHTTP(mux)
+
+		req := httptest.NewRequest(
+			http.MethodPost,
+			HTTPPath+"requests?key=user",
+			bytes.NewBufferString(`{"action":"RegisterUser"}`),
+		)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Body.String()).To(MatchJSON(`{"offset":0}`))
+
+		publish(b, "requests", "not-json")
+		req = httptest.NewRequest(http.MethodGet, HTTPPath+"requests?offset=0", nil)
+		rec = httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+
+		msgs := []*Message{}
+		err := json.Unmarshal(rec.Body.Bytes(), &msgs)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(msgs).To(HaveLen(2))
+		Expect(msgs[0].Key).To(Equal("user"))
+		Expect(msgs[0].Value).To(MatchJSON(`{"action":"RegisterUser"}`))
+		Expect(msgs[1].Value).To(MatchJSON(`"not-json"`))
+		Expect(msgs[1].Headers).To(HaveKeyWithValue("header", "value"))
+		Expect(msgs[1].Timestamp).To(BeTemporally("~", time.Now(), time.Minute))
+	})
+})
@@ -0,0 +1,187 @@
+package broker
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+)
+
+// Consumer consumes topics of the Broker as a consumer-group, and is used
+// like kafka.Consumer. Groups without committed offsets start consuming
+// from the oldest message.
+type Consumer struct {
+	broker *Broker
+	group  string
+	topics []string
+}
+
+// NewConsumer creates a Consumer for the group.
+func (b *Broker) NewConsumer(group string, topics []string) (*Consumer, error) {
+	if group == "" {
+		return nil, errors.New("group cannot be empty")
+	}
+	if len(topics) == 0 {
+		return nil, errors.New("topics cannot be empty")
+	}
+	return &Consumer{
+		broker: b,
+		group:  group,
+		topics: topics,
+	}, nil
+}
+
+// Consume runs a session for the handler until the context is done,
+// or until a ConsumeClaim of the handler returns.
+func (c *Consumer) Consume(ctx context.Context, handler sarama.ConsumerGroupHandler) error {
+	sessCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sess := &session{
+		ctx:      sessCtx,
+		consumer: c,
+	}
+	err := handler.Setup(sess)
+	if err != nil {
+		err = errors.Wrap(err, "Error in consumer-setup")
+		return err
+	}
+
+	var (
+		wg       sync.WaitGroup
+		claimErr error
+		errOnce  sync.Once
+	)
+	for _, topic := range c.topics {
+		cl := newClaim(sessCtx, c, topic)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// As with Kafka, the session ends when any claim is released
+			defer cancel()
+
+			err := handler.ConsumeClaim(sess, cl)
+			if err != nil && sessCtx.Err() == nil {
+				errOnce.Do(func() {
+					claimErr = errors.Wrapf(err, "Error consuming topic %s", cl.topic)
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	err = handler.Cleanup(sess)
+	if err != nil {
+		err = errors.Wrap(err, "Error in consumer-cleanup")
+		return err
+	}
+	return claimErr
+}
+
+// session implements sarama.ConsumerGroupSession.
+type session struct {
+	ctx      context.Context
+	consumer *Consumer
+}
+
+func (s *session) Claims() map[string][]int32 {
+	claims := map[string][]int32{}
+	for _, topic := range s.consumer.topics {
+		claims[topic] = []int32{partition}
+	}
+	return claims
+}
+
+func (s *session) MemberID() string {
+	return s.consumer.group
+}
+
+func (s *session) GenerationID() int32 {
+	return 1
+}
+
+func (s *session) MarkOffset(topic string, _ int32, offset int64, _ string) {
+	s.consumer.broker.markOffset(s.consumer.group, topic, offset)
+}
+
+// Commit is a no-op, since marked offsets are committed immediately.
+func (s *session) Commit() {}
+
+func (s *session) ResetOffset(topic string, _ int32, offset int64, _ string) {
+	s.consumer.broker.markOffset(s.consumer.group, topic, offset)
+}
+
+func (s *session) MarkMessage(msg *sarama.ConsumerMessage, _ string) {
+	s.consumer.broker.markOffset(s.consumer.group, msg.Topic, msg.Offset+1)
+}
+
+func (s *session) Context() context.Context {
+	return s.ctx
+}
+
+// claim implements sarama.ConsumerGroupClaim. Its messages are fed
+// from the topic until the session's context is done.
+type claim struct {
+	topic         string
+	initialOffset int64
+	broker        *Broker
+	messages      chan *sarama.ConsumerMessage
+}
+
+func newClaim(ctx context.Context, c *Consumer, topic string) *claim {
+	cl := &claim{
+		topic:         topic,
+		initialOffset: c.broker.offset(c.group, topic),
+		broker:        c.broker,
+		messages:      make(chan *sarama.ConsumerMessage),
+	}
+	go cl.feed(ctx)
+	return cl
+}
+
+func (c *claim) feed(ctx context.Context) {
+	defer close(c.messages)
+
+	offset := c.initialOffset
+	for {
+		msgs, appended := c.broker.Messages(c.topic, offset)
+		for _, msg := range msgs {
+			select {
+			case <-ctx.Done():
+				return
+			case c.messages <- msg:
+				offset = msg.Offset + 1
+			}
+		}
+		if len(msgs) > 0 {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-appended:
+		}
+	}
+}
+
+func (c *claim) Topic() string {
+	return c.topic
+}
+
+func (c *claim) Partition() int32 {
+	return partition
+}
+
+func (c *claim) InitialOffset() int64 {
+	return c.initialOffset
+}
+
+func (c *claim) HighWaterMarkOffset() int64 {
+	return c.broker.highWaterMark(c.topic)
+}
+
+func (c *claim) Messages() <-chan *sarama.ConsumerMessage {
+	return c.messages
+}
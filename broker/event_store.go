@@ -0,0 +1,73 @@
+package broker
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/TerrexTech/go-agg-builder/builder"
+	"github.com/TerrexTech/go-common-models/model"
+	"github.com/TerrexTech/uuuid"
+	"github.com/pkg/errors"
+)
+
+// EventStore is an embedded event-store, used in place of go-eventpersistence
+// and go-eventstore-query. It serves the Events produced to the events-topic
+// of the Broker for building the Aggregate-state.
+type EventStore struct {
+	broker      *Broker
+	topic       string
+	aggregateID int8
+
+	mu sync.Mutex
+	// offset of the next Event to return
+	offset int64
+}
+
+// NewEventStore creates an EventStore for the Aggregate's Events
+// in the topic.
+func (b *Broker) NewEventStore(topic string, aggregateID int8) (*EventStore, error) {
+	if topic == "" {
+		return nil, errors.New("topic cannot be empty")
+	}
+	return &EventStore{
+		broker:      b,
+		topic:       topic,
+		aggregateID: aggregateID,
+	}, nil
+}
+
+// BuildState returns the Events which were not yet returned, in the order
+// they were produced. It is used as domain.BuilderFunc. Each Event's Version
+// is set from its offset in the topic.
+func (s *EventStore) BuildState(
+	correlationID uuuid.UUID,
+	timeoutSec int,
+) (<-chan *builder.EventResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msgs, _ := s.broker.Messages(s.topic, s.offset)
+	eventRespChan := make(chan *builder.EventResponse, len(msgs))
+	for _, msg := range msgs {
+		s.offset = msg.Offset + 1
+
+		event := model.Event{}
+		err := json.Unmarshal(msg.Value, &event)
+		if err != nil {
+			err = errors.Wrapf(err, "Error unmarshalling Event at offset %d", msg.Offset)
+			eventRespChan <- &builder.EventResponse{
+				Error: err,
+			}
+			continue
+		}
+		if event.AggregateID != s.aggregateID {
+			continue
+		}
+		event.Version = msg.Offset + 1
+		eventRespChan <- &builder.EventResponse{
+			Event: event,
+		}
+	}
+	close(eventRespChan)
+	return eventRespChan, nil
+}
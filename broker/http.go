@@ -0,0 +1,125 @@
+package broker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+)
+
+// HTTPPath is the path for publishing and reading the messages of topics:
+//
+//	GET  /standalone/topics/                    lists the topics
+//	GET  /standalone/topics/<topic>?offset=<n>  returns the messages from offset
+//	POST /standalone/topics/<topic>?key=<key>   publishes the request-body
+const HTTPPath = "/standalone/topics/"
+
+// maxBodyBytes limits the size of published messages.
+const maxBodyBytes = 1 << 20
+
+// Message is a message in a topic, as returned by the HTTP-endpoint.
+// Values which are not valid JSON are returned as JSON-strings.
+type Message struct {
+	Offset    int64             `json:"offset"`
+	Key       string            `json:"key,omitempty"`
+	Value     json.RawMessage   `json:"value"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// RegisterHTTP adds the endpoint for the Broker's topics to the mux.
+func (b *Broker) RegisterHTTP(mux *http.ServeMux) {
+	mux.HandleFunc(HTTPPath, b.serveHTTP)
+}
+
+func (b *Broker) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	topic := strings.TrimPrefix(r.URL.Path, HTTPPath)
+
+	switch {
+	case topic == "" && r.Method == http.MethodGet:
+		topics := b.Topics()
+		sort.Strings(topics)
+		writeJSON(w, http.StatusOK, topics)
+
+	case topic != "" && r.Method == http.MethodGet:
+		offset := int64(0)
+		if o := r.URL.Query().Get("offset"); o != "" {
+			var err error
+			offset, err = strconv.ParseInt(o, 10, 64)
+			if err != nil {
+				http.Error(w, "invalid offset", http.StatusBadRequest)
+				return
+			}
+		}
+		msgs, _ := b.Messages(topic, offset)
+		writeJSON(w, http.StatusOK, httpMessages(msgs))
+
+	case topic != "" && r.Method == http.MethodPost:
+		value, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+		if err != nil {
+			err = errors.Wrap(err, "Error reading request-body")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		msg := &sarama.ProducerMessage{
+			Topic: topic,
+			Value: sarama.ByteEncoder(value),
+		}
+		if key := r.URL.Query().Get("key"); key != "" {
+			msg.Key = sarama.StringEncoder(key)
+		}
+		cMsg, err := b.Publish(msg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]int64{
+			"offset": cMsg.Offset,
+		})
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func httpMessages(msgs []*sarama.ConsumerMessage) []*Message {
+	result := make([]*Message, 0, len(msgs))
+	for _, msg := range msgs {
+		value := json.RawMessage(msg.Value)
+		if !json.Valid(value) {
+			value, _ = json.Marshal(string(msg.Value))
+		}
+		m := &Message{
+			Offset:    msg.Offset,
+			Key:       string(msg.Key),
+			Value:     value,
+			Timestamp: msg.Timestamp,
+		}
+		if len(msg.Headers) > 0 {
+			m.Headers = map[string]string{}
+			for _, h := range msg.Headers {
+				m.Headers[string(h.Key)] = string(h.Value)
+			}
+		}
+		result = append(result, m)
+	}
+	return result
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		err = errors.Wrap(err, "Error writing HTTP-response")
+		log.Println(err)
+	}
+}
@@ -0,0 +1,137 @@
+package broker
+
+import (
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+)
+
+// ErrTransactionsUnsupported is returned by the transactional
+// methods of the Broker's producers.
+var ErrTransactionsUnsupported = errors.New("transactions are not supported by in-memory broker")
+
+// asyncProducer publishes the messages from its input to the Broker.
+type asyncProducer struct {
+	broker    *Broker
+	input     chan *sarama.ProducerMessage
+	successes chan *sarama.ProducerMessage
+	errors    chan *sarama.ProducerError
+
+	returnSuccesses bool
+	returnErrors    bool
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// NewAsyncProducer creates a producer for the Broker. As with Kafka,
+// Successes and Errors must be read if enabled in the config.
+func (b *Broker) NewAsyncProducer(config *sarama.Config) sarama.AsyncProducer {
+	if config == nil {
+		config = sarama.NewConfig()
+	}
+	bufferSize := config.ChannelBufferSize
+
+	p := &asyncProducer{
+		broker:    b,
+		input:     make(chan *sarama.ProducerMessage, bufferSize),
+		successes: make(chan *sarama.ProducerMessage, bufferSize),
+		errors:    make(chan *sarama.ProducerError, bufferSize),
+
+		returnSuccesses: config.Producer.Return.Successes,
+		returnErrors:    config.Producer.Return.Errors,
+
+		done: make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+func (p *asyncProducer) run() {
+	defer func() {
+		close(p.successes)
+		close(p.errors)
+		close(p.done)
+	}()
+
+	for msg := range p.input {
+		_, err := p.broker.Publish(msg)
+		if err != nil {
+			if p.returnErrors {
+				p.errors <- &sarama.ProducerError{
+					Msg: msg,
+					Err: err,
+				}
+			}
+			continue
+		}
+		if p.returnSuccesses {
+			p.successes <- msg
+		}
+	}
+}
+
+func (p *asyncProducer) AsyncClose() {
+	p.closeOnce.Do(func() {
+		close(p.input)
+	})
+}
+
+func (p *asyncProducer) Close() error {
+	p.AsyncClose()
+	// Results are drained so the producer can finish
+	go func() {
+		for range p.successes {
+		}
+	}()
+	go func() {
+		for range p.errors {
+		}
+	}()
+	<-p.done
+	return nil
+}
+
+func (p *asyncProducer) Input() chan<- *sarama.ProducerMessage {
+	return p.input
+}
+
+func (p *asyncProducer) Successes() <-chan *sarama.ProducerMessage {
+	return p.successes
+}
+
+func (p *asyncProducer) Errors() <-chan *sarama.ProducerError {
+	return p.errors
+}
+
+func (p *asyncProducer) IsTransactional() bool {
+	return false
+}
+
+func (p *asyncProducer) TxnStatus() sarama.ProducerTxnStatusFlag {
+	return sarama.ProducerTxnFlagReady
+}
+
+func (p *asyncProducer) BeginTxn() error {
+	return ErrTransactionsUnsupported
+}
+
+func (p *asyncProducer) CommitTxn() error {
+	return ErrTransactionsUnsupported
+}
+
+func (p *asyncProducer) AbortTxn() error {
+	return ErrTransactionsUnsupported
+}
+
+func (p *asyncProducer) AddOffsetsToTxn(
+	map[string][]*sarama.PartitionOffsetMetadata,
+	string,
+) error {
+	return ErrTransactionsUnsupported
+}
+
+func (p *asyncProducer) AddMessageToTxn(*sarama.ConsumerMessage, string, *string) error {
+	return ErrTransactionsUnsupported
+}
@@ -0,0 +1,189 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	"github.com/TerrexTech/agg-userauth-cmd/auth"
+	"github.com/TerrexTech/agg-userauth-cmd/tracing"
+	"github.com/TerrexTech/agg-userauth-model/user"
+	"github.com/TerrexTech/go-common-models/model"
+	"github.com/pkg/errors"
+)
+
+// resourceFuncs return the users a Command applies to and the fields it
+// changes, for authorizing the Command by their attributes. Errors are
+// returned if the users could not be found, so the Command is not authorized
+// as having fewer targets.
+var resourceFuncs = map[string]func(c *cmdConfig) (*auth.Resource, error){
+	"DeleteUser":      deleteResource,
+	"BulkDeleteUsers": bulkDeleteResource,
+	"UpdateUser":      updateResource,
+	"AssignRole":      roleResource,
+	"RevokeRole":      roleResource,
+
+	"DeactivateUser": userIDResource,
+	"ReactivateUser": userIDResource,
+	"PurgeUser":      userIDResource,
+	"ExportUserData": userIDResource,
+	"EraseUser":      userIDResource,
+}
+
+// authorize returns an error if the actor of the Command is not permitted
+// to issue it.
+func authorize(c *cmdConfig) *model.Error {
+	ctx, span := tracing.Start(c.ctx, "Authorize")
+	defer span.End()
+	res, err := commandResource(c)
+	if err != nil {
+		err = errors.Wrap(err, "Error finding Command-resource")
+		return model.NewError(model.DatabaseError, err.Error())
+	}
+	return c.auth.Authorize(ctx, c.cmd, res)
+}
+
+// Explain decides whether the actor of the Command is permitted to issue it,
+// without handling the Command, and explains why.
+func (h *Handler) Explain(ctx context.Context, cmd *model.Command) (*auth.Decision, error) {
+	if h.Auth == nil {
+		return &auth.Decision{
+			Allowed: true,
+			Action:  cmd.Action,
+			Reason:  "authorization is disabled",
+		}, nil
+	}
+	// The Command-resource is found as when handling the Command, such as
+	// with encrypted filters if field-encryption is enabled.
+	res, err := commandResource(h.cmdConfig(ctx, cmd))
+	if err != nil {
+		err = errors.Wrap(err, "Error finding Command-resource")
+		return nil, err
+	}
+	decision, err := h.Auth.Decide(ctx, cmd, res)
+	if err != nil {
+		err = errors.Wrap(err, "Error deciding Command")
+		return nil, err
+	}
+	return decision, nil
+}
+
+func commandResource(c *cmdConfig) (*auth.Resource, error) {
+	if resourceFunc, ok := resourceFuncs[c.cmd.Action]; ok {
+		return resourceFunc(c)
+	}
+	return &auth.Resource{}, nil
+}
+
+func bulkDeleteResource(c *cmdConfig) (*auth.Resource, error) {
+	params := &bulkDeleteParams{}
+	err := json.Unmarshal(c.cmd.Data, params)
+	if err != nil || params.Filter == nil {
+		return &auth.Resource{}, nil
+	}
+	targets, err := findUsers(c, params.Filter)
+	if err != nil {
+		return nil, err
+	}
+	return &auth.Resource{
+		Targets: targets,
+	}, nil
+}
+
+func deleteResource(c *cmdConfig) (*auth.Resource, error) {
+	filter := &user.User{}
+	err := json.Unmarshal(c.cmd.Data, filter)
+	if err != nil {
+		return &auth.Resource{}, nil
+	}
+	targets, err := findUsers(c, filter)
+	if err != nil {
+		return nil, err
+	}
+	return &auth.Resource{
+		Targets: targets,
+	}, nil
+}
+
+// updateResource returns the users matched by the filter of an
+// UpdateUser-Command, and the fields in its update.
+func updateResource(c *cmdConfig) (*auth.Resource, error) {
+	params := &struct {
+		Filter *user.User             `json:"filter"`
+		Update map[string]interface{} `json:"update"`
+	}{}
+	err := json.Unmarshal(c.cmd.Data, params)
+	if err != nil || params.Filter == nil {
+		return &auth.Resource{}, nil
+	}
+	fields := make([]string, 0, len(params.Update))
+	for field := range params.Update {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	targets, err := findUsers(c, params.Filter)
+	if err != nil {
+		return nil, err
+	}
+	return &auth.Resource{
+		Targets: targets,
+		Fields:  fields,
+	}, nil
+}
+
+func roleResource(c *cmdConfig) (*auth.Resource, error) {
+	res, err := userIDResource(c)
+	if err != nil {
+		return nil, err
+	}
+	res.Fields = []string{"role"}
+	return res, nil
+}
+
+// userIDResource returns the user with the UserID in the command-data.
+func userIDResource(c *cmdConfig) (*auth.Resource, error) {
+	params := &struct {
+		UserID string `json:"userID"`
+	}{}
+	err := json.Unmarshal(c.cmd.Data, params)
+	if err != nil || params.UserID == "" {
+		return &auth.Resource{}, nil
+	}
+	targets, err := findUsers(c, &user.User{
+		UserID: params.UserID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &auth.Resource{
+		Targets: targets,
+	}, nil
+}
+
+// findUsers returns the users matched by the filter.
+func findUsers(c *cmdConfig, filter *user.User) ([]*user.User, error) {
+	fieldFilter, err := c.fields.Filter(filter)
+	if err != nil {
+		err = errors.Wrap(err, "Error encrypting filter")
+		return nil, err
+	}
+	_, span := tracing.Start(c.ctx, "mongo.Find")
+	matches, err := c.coll.Find(fieldFilter)
+	tracing.End(span, err)
+	if err != nil {
+		err = errors.Wrap(err, "Error finding users")
+		return nil, err
+	}
+	err = c.fields.DecryptResults(matches)
+	if err != nil {
+		err = errors.Wrap(err, "Error decrypting users")
+		return nil, err
+	}
+	users := make([]*user.User, 0, len(matches))
+	for _, m := range matches {
+		if u, ok := m.(*user.User); ok {
+			users = append(users, u)
+		}
+	}
+	return users, nil
+}
@@ -1,8 +1,11 @@
 package command
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"log"
+	"os"
 	"testing"
 	"time"
 
@@ -12,8 +15,14 @@ import (
 	"github.com/joho/godotenv"
 	"github.com/pkg/errors"
 
+	"github.com/TerrexTech/agg-userauth-cmd/auth"
+	"github.com/TerrexTech/agg-userauth-cmd/lifecycle"
+	"github.com/TerrexTech/agg-userauth-cmd/rbac"
+	"github.com/TerrexTech/agg-userauth-cmd/secrets"
+	"github.com/TerrexTech/agg-userauth-cmd/shred"
 	"github.com/TerrexTech/agg-userauth-cmd/util"
 	"github.com/TerrexTech/agg-userauth-model/user"
+	"github.com/TerrexTech/go-agg-builder/builder"
 	"github.com/TerrexTech/go-common-models/model"
 	"github.com/TerrexTech/go-mongoutils/mongo"
 	"github.com/TerrexTech/uuuid"
@@ -100,6 +109,17 @@ func testError(coll *mongo.Collection, action string, data []byte) {
 }
 
 func testValid(coll *mongo.Collection, action string, data []byte) ([]byte, *model.Event) {
+	result, event, _ := testValidConfig(coll, action, data)
+	return result, event
+}
+
+// testValidConfig is testValid, but also returns the cmdConfig,
+// such as for checking its followUps.
+func testValidConfig(
+	coll *mongo.Collection,
+	action string,
+	data []byte,
+) ([]byte, *model.Event, *cmdConfig) {
 	uuid, err := uuuid.NewV4()
 	Expect(err).ToNot(HaveOccurred())
 	cid, err := uuuid.NewV4()
@@ -133,6 +153,8 @@ func testValid(coll *mongo.Collection, action string, data []byte) ([]byte, *mod
 		result, event, cmdErr = registerUser(c)
 	case "DeleteUser":
 		result, event, cmdErr = deleteUser(c)
+	case "BulkDeleteUsers":
+		result, event, cmdErr = bulkDeleteUsers(c)
 	case "UpdateUser":
 		result, event, cmdErr = updateUser(c)
 	}
@@ -140,20 +162,410 @@ func testValid(coll *mongo.Collection, action string, data []byte) ([]byte, *mod
 	Expect(cmdErr).To(BeNil())
 	Expect(event.CorrelationID).To(Equal(mockCmd.UUID))
 
-	return result, event
+	return result, event, c
 }
 
 var _ = Describe("CommanHandler", func() {
 	var (
 		coll *mongo.Collection
+		mc   *builder.MongoConfig
 	)
 
 	BeforeSuite(func() {
-		mc, err := util.LoadMongoConfig()
+		var err error
+		mc, err = util.LoadMongoConfig()
 		Expect(err).ToNot(HaveOccurred())
 		coll = mc.AggCollection
 	})
 
+	Describe("Outbox", func() {
+		var outbox *Outbox
+
+		BeforeEach(func() {
+			var err error
+			outbox, err = NewOutbox(mc.Connection, os.Getenv("MONGO_DATABASE"), "test_outbox")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		findEntry := func(entries []*OutboxEntry, docID uuuid.UUID) *OutboxEntry {
+			for _, entry := range entries {
+				if len(entry.Response) == 0 {
+					continue
+				}
+				doc := &model.Document{}
+				err := json.Unmarshal(entry.Response, doc)
+				Expect(err).ToNot(HaveOccurred())
+				if doc.UUID == docID {
+					return entry
+				}
+			}
+			return nil
+		}
+
+		It("should return added entries as pending until marked sent", func() {
+			eventID, err := uuuid.NewV4()
+			Expect(err).ToNot(HaveOccurred())
+			docID, err := uuuid.NewV4()
+			Expect(err).ToNot(HaveOccurred())
+
+			event := &model.Event{
+				Action: "UserRegistered",
+				UUID:   eventID,
+			}
+			doc := &model.Document{
+				Topic: "test-topic",
+				UUID:  docID,
+			}
+			err = outbox.Add(
+				[]*EventMsg{
+					&EventMsg{
+						Event: event,
+						Key:   "test-key",
+					},
+				},
+				&ResponseMsg{
+					Document: doc,
+				},
+			)
+			Expect(err).ToNot(HaveOccurred())
+
+			entries, err := outbox.Pending()
+			Expect(err).ToNot(HaveOccurred())
+			entry := findEntry(entries, docID)
+			Expect(entry).ToNot(BeNil())
+			Expect(entry.EventSent).To(BeFalse())
+			Expect(entry.Events).To(HaveLen(1))
+			Expect(entry.Events[0].Key).To(Equal("test-key"))
+			Expect(entry.ResponseTopic).To(Equal("test-topic"))
+
+			outEvent := &model.Event{}
+			err = json.Unmarshal(entry.Events[0].Event, outEvent)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(outEvent.UUID).To(Equal(eventID))
+
+			err = outbox.MarkSent(entry.EntryID)
+			Expect(err).ToNot(HaveOccurred())
+			entries, err = outbox.Pending()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(findEntry(entries, docID)).To(BeNil())
+		})
+
+		It("should mark the Event as sent if there is no Event", func() {
+			docID, err := uuuid.NewV4()
+			Expect(err).ToNot(HaveOccurred())
+			err = outbox.Add(nil, &ResponseMsg{
+				Document: &model.Document{
+					Topic: "test-topic",
+					UUID:  docID,
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			entries, err := outbox.Pending()
+			Expect(err).ToNot(HaveOccurred())
+			entry := findEntry(entries, docID)
+			Expect(entry).ToNot(BeNil())
+			Expect(entry.EventSent).To(BeTrue())
+			Expect(entry.Events).To(BeEmpty())
+		})
+
+		It("should add all Events of a Command with the Response in one entry", func() {
+			docID, err := uuuid.NewV4()
+			Expect(err).ToNot(HaveOccurred())
+			eventMsgs := []*EventMsg{}
+			for _, action := range []string{"UserRegistered", "CredentialSet"} {
+				eventID, err := uuuid.NewV4()
+				Expect(err).ToNot(HaveOccurred())
+				eventMsgs = append(eventMsgs, &EventMsg{
+					Event: &model.Event{
+						Action: action,
+						UUID:   eventID,
+					},
+					Key: docID.String(),
+				})
+			}
+			err = outbox.Add(eventMsgs, &ResponseMsg{
+				Document: &model.Document{
+					Topic: "test-topic",
+					UUID:  docID,
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			entries, err := outbox.Pending()
+			Expect(err).ToNot(HaveOccurred())
+			entry := findEntry(entries, docID)
+			Expect(entry).ToNot(BeNil())
+			Expect(entry.EventSent).To(BeFalse())
+			Expect(entry.Events).To(HaveLen(2))
+			for i, outbox := range entry.Events {
+				Expect(outbox.Key).To(Equal(docID.String()))
+				outEvent := &model.Event{}
+				err = json.Unmarshal(outbox.Event, outEvent)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(outEvent.UUID).To(Equal(eventMsgs[i].Event.UUID))
+			}
+		})
+	})
+
+	Describe("Outbox claims", func() {
+		var outbox *Outbox
+
+		BeforeEach(func() {
+			var err error
+			outbox, err = NewOutbox(mc.Connection, os.Getenv("MONGO_DATABASE"), "test_outbox")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		// addEntry adds an entry without Event, and returns it.
+		addEntry := func() *OutboxEntry {
+			docID, err := uuuid.NewV4()
+			Expect(err).ToNot(HaveOccurred())
+			err = outbox.Add(nil, &ResponseMsg{
+				Document: &model.Document{
+					Topic: "test-topic",
+					UUID:  docID,
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			entries, err := outbox.Pending()
+			Expect(err).ToNot(HaveOccurred())
+			for _, entry := range entries {
+				doc := &model.Document{}
+				if json.Unmarshal(entry.Response, doc) == nil && doc.UUID == docID {
+					return entry
+				}
+			}
+			Fail("added entry is not pending")
+			return nil
+		}
+
+		It("should only let one relay claim an entry until its claim expires", func() {
+			entry := addEntry()
+
+			claimed, err := outbox.Claim(entry.EntryID, time.Minute)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(claimed).To(BeTrue())
+			claimed, err = outbox.Claim(entry.EntryID, time.Minute)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(claimed).To(BeFalse())
+
+			// Failed attempts release the claim
+			err = outbox.MarkFailed(entry, errors.New("publish failed"))
+			Expect(err).ToNot(HaveOccurred())
+			claimed, err = outbox.Claim(entry.EntryID, time.Minute)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(claimed).To(BeTrue())
+		})
+
+		It("should not claim sent entries", func() {
+			entry := addEntry()
+			err := outbox.MarkSent(entry.EntryID)
+			Expect(err).ToNot(HaveOccurred())
+
+			claimed, err := outbox.Claim(entry.EntryID, time.Minute)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(claimed).To(BeFalse())
+		})
+
+		It("should exclude dead-lettered entries from pending entries", func() {
+			entry := addEntry()
+			err := outbox.DeadLetter(entry, errors.New("publish failed"))
+			Expect(err).ToNot(HaveOccurred())
+
+			entries, err := outbox.Pending()
+			Expect(err).ToNot(HaveOccurred())
+			for _, pending := range entries {
+				Expect(pending.EntryID).ToNot(Equal(entry.EntryID))
+			}
+			claimed, err := outbox.Claim(entry.EntryID, time.Minute)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(claimed).To(BeFalse())
+		})
+
+		It("should prune entries sent before the time", func() {
+			entry := addEntry()
+			err := outbox.MarkSent(entry.EntryID)
+			Expect(err).ToNot(HaveOccurred())
+
+			pruned, err := outbox.Prune(time.Now().Add(time.Second))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pruned).To(BeNumerically(">=", 1))
+		})
+	})
+
+	Describe("Handler", func() {
+		var (
+			eventProd  chan *EventMsg
+			resultProd chan *ResponseMsg
+			handler    *Handler
+		)
+
+		BeforeEach(func() {
+			eventProd = make(chan *EventMsg, 1)
+			resultProd = make(chan *ResponseMsg, 1)
+			var err error
+			handler, err = NewHandler(&HandlerConfig{
+				Coll:        coll,
+				ServiceName: "test-svc",
+				EventProd:   eventProd,
+				ResultProd:  resultProd,
+				SendTimeout: 5 * time.Second,
+			})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		// registerCmd returns a RegisterUser-command for a new user.
+		registerCmd := func() *model.Command {
+			uid, err := uuuid.NewV4()
+			Expect(err).ToNot(HaveOccurred())
+			cmdID, err := uuuid.NewV4()
+			Expect(err).ToNot(HaveOccurred())
+			data, err := json.Marshal(user.User{
+				UserName:  uid.String(),
+				FirstName: "test-fname",
+				Email:     "test-email",
+				Password:  "test-pass",
+				Role:      "test-role",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			return &model.Command{
+				Action:        "RegisterUser",
+				Data:          data,
+				ResponseTopic: "test-topic",
+				UUID:          cmdID,
+			}
+		}
+
+		// produce acknowledges the Events with the delivery-result, and records
+		// whether a Response was queued before any Event was acknowledged.
+		produce := func(result error, respondedEarly *bool) {
+			for msg := range eventProd {
+				if len(resultProd) > 0 {
+					*respondedEarly = true
+				}
+				msg.Result <- result
+			}
+		}
+
+		It("should only respond once the Event is delivered", func() {
+			respondedEarly := false
+			go produce(nil, &respondedEarly)
+			defer close(eventProd)
+
+			handler.Handle(context.Background(), registerCmd())
+
+			var resp *ResponseMsg
+			Eventually(resultProd).Should(Receive(&resp))
+			Expect(respondedEarly).To(BeFalse())
+			Expect(resp.Document.Error).To(BeEmpty())
+			Expect(resp.Document.Data).ToNot(BeEmpty())
+		})
+
+		It("should respond with an error if the Event is not delivered", func() {
+			respondedEarly := false
+			go produce(errors.New("broker unavailable"), &respondedEarly)
+			defer close(eventProd)
+
+			handler.Handle(context.Background(), registerCmd())
+
+			var resp *ResponseMsg
+			Eventually(resultProd).Should(Receive(&resp))
+			Expect(respondedEarly).To(BeFalse())
+			Expect(resp.Document.ErrorCode).To(Equal(model.InternalError))
+			Expect(resp.Document.Error).To(ContainSubstring("broker unavailable"))
+			// The result of the command is not sent
+			Expect(resp.Document.Data).To(BeNil())
+			Consistently(resultProd).ShouldNot(Receive())
+		})
+
+		It("should publish the Events of a Command together using PublishEvents", func() {
+			published := [][]*EventMsg{}
+			handler.PublishEvents = func(eventMsgs []*EventMsg) error {
+				published = append(published, eventMsgs)
+				return nil
+			}
+
+			handler.Handle(context.Background(), registerCmd())
+
+			var resp *ResponseMsg
+			Eventually(resultProd).Should(Receive(&resp))
+			Expect(resp.Document.Error).To(BeEmpty())
+			Expect(published).To(HaveLen(1))
+			Expect(published[0]).To(HaveLen(2))
+			Expect(published[0][0].Event.Action).To(Equal("UserRegistered"))
+			Expect(published[0][1].Event.Action).To(Equal("CredentialSet"))
+			Expect(eventProd).ToNot(Receive())
+		})
+
+		It("should emit no Event if publishing the second Event fails", func() {
+			// The transaction is aborted when its second Event fails, so the
+			// first Event is not emitted on EventProd either.
+			handler.PublishEvents = func(eventMsgs []*EventMsg) error {
+				Expect(eventMsgs).To(HaveLen(2))
+				return errors.New("second Event rejected")
+			}
+
+			handler.Handle(context.Background(), registerCmd())
+
+			var resp *ResponseMsg
+			Eventually(resultProd).Should(Receive(&resp))
+			Expect(resp.Document.ErrorCode).To(Equal(model.InternalError))
+			Expect(resp.Document.Error).To(ContainSubstring("second Event rejected"))
+			Expect(resp.Document.Data).To(BeNil())
+			Expect(eventProd).ToNot(Receive())
+		})
+
+		It("should respond with an error to unregistered Actions", func() {
+			cmdID, err := uuuid.NewV4()
+			Expect(err).ToNot(HaveOccurred())
+			eventMsgs, resp := handler.Process(context.Background(), &model.Command{
+				Action:        "NoSuchAction",
+				ResponseTopic: "test-topic",
+				UUID:          cmdID,
+			})
+			Expect(eventMsgs).To(BeEmpty())
+			Expect(resp.Document.ErrorCode).To(Equal(model.UserError))
+			Expect(resp.Document.Error).To(ContainSubstring("NoSuchAction"))
+		})
+
+		It("should respond with an error if the producer stops before the delivery-result", func() {
+			done := make(chan struct{})
+			handler.Done = done
+			go func() {
+				// The Event is queued, but the producer stops without a result
+				<-eventProd
+				close(done)
+			}()
+
+			handler.Handle(context.Background(), registerCmd())
+
+			var resp *ResponseMsg
+			Eventually(resultProd).Should(Receive(&resp))
+			Expect(resp.Document.ErrorCode).To(Equal(model.InternalError))
+			Expect(resp.Document.Error).To(ContainSubstring("producer stopped"))
+		})
+
+		It("should wait for delivery-results arriving after SendTimeout", func() {
+			handler.SendTimeout = 100 * time.Millisecond
+			go func() {
+				for msg := range eventProd {
+					time.Sleep(3 * handler.SendTimeout)
+					msg.Result <- nil
+				}
+			}()
+			defer close(eventProd)
+
+			handler.Handle(context.Background(), registerCmd())
+
+			var resp *ResponseMsg
+			Eventually(resultProd).Should(Receive(&resp))
+			Expect(resp.Document.Error).To(BeEmpty())
+			Expect(resp.Document.Data).ToNot(BeEmpty())
+		})
+	})
+
 	Describe("DeleteUser", func() {
 		It("should return error if user is not found", func() {
 			uid, err := uuuid.NewV4()
@@ -187,10 +599,76 @@ var _ = Describe("CommanHandler", func() {
 
 			err = json.Unmarshal(result, delResult)
 			Expect(err).ToNot(HaveOccurred())
-			Expect(delResult.MatchedCount).To(BeNumerically(">", 0))
-			err = json.Unmarshal(event.Data, delResult)
+			Expect(delResult.MatchedCount).To(Equal(1))
+			deletion := &userDeletion{}
+			err = json.Unmarshal(event.Data, deletion)
 			Expect(err).ToNot(HaveOccurred())
-			Expect(delResult.MatchedCount).To(BeNumerically(">", 0))
+			Expect(deletion.UserIDs).To(Equal([]string{uid.String()}))
+		})
+
+		It("should return error if UserID is missing", func() {
+			c := &cmdConfig{
+				coll: coll,
+				cmd: &model.Command{
+					Action: "DeleteUser",
+					Data:   []byte(`{"role":"test-role"}`),
+				},
+			}
+			_, event, cmdErr := deleteUser(c)
+			Expect(event).To(BeNil())
+			Expect(cmdErr.Code).To(Equal(model.UserError))
+		})
+	})
+
+	Describe("BulkDeleteUsers", func() {
+		var role string
+
+		BeforeEach(func() {
+			uid, err := uuuid.NewV4()
+			Expect(err).ToNot(HaveOccurred())
+			role = "bulk-" + uid.String()
+			for i := 0; i < 2; i++ {
+				uid, err := uuuid.NewV4()
+				Expect(err).ToNot(HaveOccurred())
+				_, err = coll.InsertOne(user.User{
+					UserID:   uid.String(),
+					UserName: uid.String(),
+					Role:     role,
+				})
+				Expect(err).ToNot(HaveOccurred())
+			}
+		})
+
+		It("should return UserDeleted event with the matched UserIDs", func() {
+			data, err := json.Marshal(map[string]interface{}{
+				"filter":       user.User{Role: role},
+				"confirmCount": 2,
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			_, event := testValid(coll, "BulkDeleteUsers", data)
+			deletion := &userDeletion{}
+			err = json.Unmarshal(event.Data, deletion)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(deletion.UserIDs).To(HaveLen(2))
+		})
+
+		It("should return error if confirmCount does not match", func() {
+			data, err := json.Marshal(map[string]interface{}{
+				"filter":       user.User{Role: role},
+				"confirmCount": 1,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			c := &cmdConfig{
+				coll: coll,
+				cmd: &model.Command{
+					Action: "BulkDeleteUsers",
+					Data:   data,
+				},
+			}
+			_, event, cmdErr := bulkDeleteUsers(c)
+			Expect(event).To(BeNil())
+			Expect(cmdErr.Code).To(Equal(model.UserError))
 		})
 	})
 
@@ -296,13 +774,12 @@ var _ = Describe("CommanHandler", func() {
 			marshalUser, err := json.Marshal(mockUser)
 			Expect(err).ToNot(HaveOccurred())
 
-			result, event := testValid(coll, "RegisterUser", marshalUser)
+			result, event, c := testValidConfig(coll, "RegisterUser", marshalUser)
 
 			regUser := &user.User{}
 			err = json.Unmarshal(result, regUser)
 			Expect(err).ToNot(HaveOccurred())
-			err = bcrypt.CompareHashAndPassword([]byte(regUser.Password), []byte(mockUser.Password))
-			Expect(err).ToNot(HaveOccurred())
+			Expect(regUser.Password).To(BeEmpty())
 			Expect(regUser.UserID).ToNot(BeEmpty())
 			Expect(mockUser.UserName).To(Equal(regUser.UserName))
 			Expect(mockUser.FirstName).To(Equal(regUser.FirstName))
@@ -313,14 +790,24 @@ var _ = Describe("CommanHandler", func() {
 			regUser = &user.User{}
 			err = json.Unmarshal(event.Data, regUser)
 			Expect(err).ToNot(HaveOccurred())
-			err = bcrypt.CompareHashAndPassword([]byte(regUser.Password), []byte(mockUser.Password))
-			Expect(err).ToNot(HaveOccurred())
+			Expect(regUser.Password).To(BeEmpty())
 			Expect(regUser.UserID).ToNot(BeEmpty())
 			Expect(mockUser.UserName).To(Equal(regUser.UserName))
 			Expect(mockUser.FirstName).To(Equal(regUser.FirstName))
 			Expect(mockUser.LastName).To(Equal(regUser.LastName))
 			Expect(mockUser.Email).To(Equal(regUser.Email))
 			Expect(mockUser.Role).To(Equal(regUser.Role))
+
+			Expect(c.followUps).To(HaveLen(1))
+			credEvent := c.followUps[0]
+			Expect(credEvent.Action).To(Equal("CredentialSet"))
+			Expect(credEvent.NanoTime).To(BeNumerically(">=", event.NanoTime))
+			cred := &credential{}
+			err = json.Unmarshal(credEvent.Data, cred)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cred.UserID).To(Equal(regUser.UserID))
+			err = bcrypt.CompareHashAndPassword([]byte(cred.PasswordHash), []byte(mockUser.Password))
+			Expect(err).ToNot(HaveOccurred())
 		})
 	})
 
@@ -393,7 +880,7 @@ var _ = Describe("CommanHandler", func() {
 			testError(coll, "UpdateUser", params)
 		})
 
-		It("should return error if Password is attempted to be set to blank", func() {
+		It("should return error if Update is empty", func() {
 			uid, err := uuuid.NewV4()
 			Expect(err).ToNot(HaveOccurred())
 			mockUser := user.User{
@@ -408,7 +895,6 @@ var _ = Describe("CommanHandler", func() {
 					UserName: uid.String(),
 				},
 				Update: &user.User{
-					UserName: "test-name",
 					Password: "",
 				},
 			})
@@ -473,5 +959,454 @@ var _ = Describe("CommanHandler", func() {
 			Expect(assertOK).To(BeTrue())
 			Expect(filter).To(HaveKeyWithValue("userName", uid.String()))
 		})
+
+		It("should return CredentialChanged event if Password is updated", func() {
+			uid, err := uuuid.NewV4()
+			Expect(err).ToNot(HaveOccurred())
+			mockUser := user.User{
+				UserID:   uid.String(),
+				UserName: uid.String(),
+				Password: "old-hash",
+			}
+			_, err = coll.InsertOne(mockUser)
+			Expect(err).ToNot(HaveOccurred())
+
+			params, err := json.Marshal(updateParams{
+				Filter: &user.User{
+					UserName: uid.String(),
+				},
+				Update: &user.User{
+					FirstName: "test-name",
+					Password:  "new-password",
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			result, event, c := testValidConfig(coll, "UpdateUser", params)
+			Expect(event.Action).To(Equal("UserUpdated"))
+			Expect(string(result)).ToNot(ContainSubstring("password"))
+			Expect(string(event.Data)).ToNot(ContainSubstring("password"))
+
+			Expect(c.followUps).To(HaveLen(1))
+			credEvent := c.followUps[0]
+			Expect(credEvent.Action).To(Equal("CredentialChanged"))
+			cred := &credential{}
+			err = json.Unmarshal(credEvent.Data, cred)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cred.UserID).To(Equal(mockUser.UserID))
+			err = bcrypt.CompareHashAndPassword([]byte(cred.PasswordHash), []byte("new-password"))
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	Describe("Authorize", func() {
+		It("should not let users make themselves admin", func() {
+			authorizer, err := auth.NewAuthorizer(&auth.Config{
+				Users:      coll,
+				SigningKey: secrets.NewValue(""),
+				AdminRole:  "admin",
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			uid, err := uuuid.NewV4()
+			Expect(err).ToNot(HaveOccurred())
+			_, err = coll.InsertOne(user.User{
+				UserID:   uid.String(),
+				UserName: uid.String(),
+				Role:     "customer",
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			selfUpdate := func(update string) *model.Error {
+				data := fmt.Sprintf(
+					`{"filter": {"userID": "%s"}, "update": %s}`, uid.String(), update,
+				)
+				return authorize(&cmdConfig{
+					ctx:  auth.WithUserUUID(context.Background(), uid.String()),
+					coll: coll,
+					auth: authorizer,
+					cmd: &model.Command{
+						Action: "UpdateUser",
+						Data:   []byte(data),
+					},
+				})
+			}
+
+			cmdErr := selfUpdate(`{"role": "admin"}`)
+			Expect(cmdErr).ToNot(BeNil())
+			Expect(cmdErr.Code).To(Equal(auth.UnauthorizedError))
+			cmdErr = selfUpdate(`{"firstName": "test-name", "role": "admin"}`)
+			Expect(cmdErr).ToNot(BeNil())
+			Expect(cmdErr.Code).To(Equal(auth.UnauthorizedError))
+
+			Expect(selfUpdate(`{"firstName": "test-name"}`)).To(BeNil())
+		})
+	})
+
+	Describe("Roles", func() {
+		var (
+			roles  *rbac.Store
+			userID string
+		)
+
+		// roleCmd creates the cmdConfig for a role-command with the data.
+		roleCmd := func(action string, data string) *cmdConfig {
+			uuid, err := uuuid.NewV4()
+			Expect(err).ToNot(HaveOccurred())
+			return &cmdConfig{
+				coll:        coll,
+				serviceName: "test-svc",
+				roles:       roles,
+				cmd: &model.Command{
+					Action: action,
+					Data:   []byte(data),
+					UUID:   uuid,
+				},
+			}
+		}
+
+		BeforeEach(func() {
+			var err error
+			roles, err = rbac.NewStore(&rbac.StoreConfig{
+				Conn:                mc.Connection,
+				Database:            mc.MetaDatabaseName,
+				RolesCollection:     "test_roles",
+				UserRolesCollection: "test_user_roles",
+				Roles: []*rbac.Role{
+					&rbac.Role{
+						Name:        "configured-role",
+						Permissions: []string{"UpdateUser"},
+					},
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			uid, err := uuuid.NewV4()
+			Expect(err).ToNot(HaveOccurred())
+			userID = uid.String()
+			_, err = coll.InsertOne(user.User{
+				UserID:   userID,
+				UserName: userID,
+			})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should return RoleDefined event on valid role", func() {
+			c := roleCmd("DefineRole", `{"name": "support", "permissions": ["UpdateUser"]}`)
+			result, event, cmdErr := defineRole(c)
+			Expect(cmdErr).To(BeNil())
+			Expect(event.Action).To(Equal("RoleDefined"))
+			Expect(event.CorrelationID).To(Equal(c.cmd.UUID))
+			Expect(event.Data).To(MatchJSON(result))
+
+			role := &rbac.Role{}
+			err := json.Unmarshal(event.Data, role)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(role.Name).To(Equal("support"))
+			Expect(role.Permissions).To(Equal([]string{"UpdateUser"}))
+		})
+
+		It("should return error if role is invalid or configured", func() {
+			for _, data := range []string{
+				`{"name": "support"}`,
+				`{"name": "configured-role", "permissions": ["*"]}`,
+			} {
+				_, event, cmdErr := defineRole(roleCmd("DefineRole", data))
+				Expect(event).To(BeNil())
+				Expect(cmdErr.Code).To(Equal(model.UserError), data)
+			}
+		})
+
+		It("should return error if RBAC is not enabled", func() {
+			c := roleCmd("DefineRole", `{"name": "support", "permissions": ["UpdateUser"]}`)
+			c.roles = nil
+			_, event, cmdErr := defineRole(c)
+			Expect(event).To(BeNil())
+			Expect(cmdErr.Code).To(Equal(model.UserError))
+		})
+
+		It("should return RoleAssigned event if user does not have the role", func() {
+			data := fmt.Sprintf(`{"userID": "%s", "role": "configured-role"}`, userID)
+			_, event, cmdErr := assignRole(roleCmd("AssignRole", data))
+			Expect(cmdErr).To(BeNil())
+			Expect(event.Action).To(Equal("RoleAssigned"))
+			Expect(event.Data).To(MatchJSON(data))
+		})
+
+		It("should return error if assigned role is undefined or already held", func() {
+			data := fmt.Sprintf(`{"userID": "%s", "role": "undefined-role"}`, userID)
+			_, event, cmdErr := assignRole(roleCmd("AssignRole", data))
+			Expect(event).To(BeNil())
+			Expect(cmdErr.Code).To(Equal(model.UserError))
+
+			err := roles.AssignRole(userID, "configured-role")
+			Expect(err).ToNot(HaveOccurred())
+			data = fmt.Sprintf(`{"userID": "%s", "role": "configured-role"}`, userID)
+			_, event, cmdErr = assignRole(roleCmd("AssignRole", data))
+			Expect(event).To(BeNil())
+			Expect(cmdErr.Code).To(Equal(model.UserError))
+		})
+
+		It("should return error if user is not found", func() {
+			data := `{"userID": "missing-user", "role": "configured-role"}`
+			_, event, cmdErr := assignRole(roleCmd("AssignRole", data))
+			Expect(event).To(BeNil())
+			Expect(cmdErr.Code).To(Equal(model.UserError))
+			Expect(cmdErr.Message).To(Equal("user not found"))
+		})
+
+		It("should return RoleRevoked event if user has the role", func() {
+			err := roles.AssignRole(userID, "configured-role")
+			Expect(err).ToNot(HaveOccurred())
+
+			data := fmt.Sprintf(`{"userID": "%s", "role": "configured-role"}`, userID)
+			_, event, cmdErr := revokeRole(roleCmd("RevokeRole", data))
+			Expect(cmdErr).To(BeNil())
+			Expect(event.Action).To(Equal("RoleRevoked"))
+			Expect(event.Data).To(MatchJSON(data))
+		})
+
+		It("should return error if revoked role is not held", func() {
+			data := fmt.Sprintf(`{"userID": "%s", "role": "configured-role"}`, userID)
+			_, event, cmdErr := revokeRole(roleCmd("RevokeRole", data))
+			Expect(event).To(BeNil())
+			Expect(cmdErr.Code).To(Equal(model.UserError))
+		})
+	})
+
+	Describe("Keys", func() {
+		It("should return the same key to concurrent first writes", func() {
+			keys, err := shred.NewStore(&shred.StoreConfig{
+				Conn:       mc.Connection,
+				Database:   mc.MetaDatabaseName,
+				Collection: "test_keys",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			uid, err := uuuid.NewV4()
+			Expect(err).ToNot(HaveOccurred())
+
+			const writers = 5
+			results := make(chan []byte, writers)
+			for i := 0; i < writers; i++ {
+				go func() {
+					defer GinkgoRecover()
+					key, err := keys.CreateKey(uid.String())
+					Expect(err).ToNot(HaveOccurred())
+					results <- key
+				}()
+			}
+			var first []byte
+			for i := 0; i < writers; i++ {
+				var key []byte
+				Eventually(results).Should(Receive(&key))
+				if first == nil {
+					first = key
+				}
+				Expect(key).To(Equal(first))
+			}
+		})
+	})
+
+	Describe("Lifecycle", func() {
+		var (
+			records  *lifecycle.Store
+			testUser user.User
+		)
+
+		// lifecycleCmd creates the cmdConfig for a lifecycle-command on the user.
+		lifecycleCmd := func(action string, userID string) *cmdConfig {
+			uuid, err := uuuid.NewV4()
+			Expect(err).ToNot(HaveOccurred())
+			return &cmdConfig{
+				coll:        coll,
+				serviceName: "test-svc",
+				lifecycle:   records,
+				gracePeriod: time.Hour,
+				cmd: &model.Command{
+					Action: action,
+					Data:   []byte(fmt.Sprintf(`{"userID": "%s"}`, userID)),
+					Source: "test-source",
+					UUID:   uuid,
+				},
+			}
+		}
+
+		BeforeEach(func() {
+			var err error
+			records, err = lifecycle.NewStore(&lifecycle.StoreConfig{
+				Conn:       mc.Connection,
+				Database:   mc.MetaDatabaseName,
+				Collection: "test_lifecycle",
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			uid, err := uuuid.NewV4()
+			Expect(err).ToNot(HaveOccurred())
+			testUser = user.User{
+				UserID:   uid.String(),
+				UserName: uid.String(),
+			}
+			_, err = coll.InsertOne(testUser)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should return UserDeactivated event for active users", func() {
+			_, event, cmdErr := deactivateUser(lifecycleCmd("DeactivateUser", testUser.UserID))
+			Expect(cmdErr).To(BeNil())
+			Expect(event.Action).To(Equal("UserDeactivated"))
+
+			change := &lifecycleChange{}
+			err := json.Unmarshal(event.Data, change)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(change.UserID).To(Equal(testUser.UserID))
+			Expect(change.ChangedBy).To(Equal("test-source"))
+			Expect(change.ChangedAt).ToNot(BeZero())
+		})
+
+		It("should return error if deactivated user is missing or not active", func() {
+			_, event, cmdErr := deactivateUser(lifecycleCmd("DeactivateUser", "missing-user"))
+			Expect(event).To(BeNil())
+			Expect(cmdErr.Message).To(Equal("user not found"))
+
+			err := records.Put(&lifecycle.Record{
+				UserID: testUser.UserID,
+				Status: lifecycle.StatusDeactivated,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			_, event, cmdErr = deactivateUser(lifecycleCmd("DeactivateUser", testUser.UserID))
+			Expect(event).To(BeNil())
+			Expect(cmdErr.Code).To(Equal(model.UserError))
+		})
+
+		It("should return UserReactivated event only for deactivated users", func() {
+			_, event, cmdErr := reactivateUser(lifecycleCmd("ReactivateUser", testUser.UserID))
+			Expect(event).To(BeNil())
+			Expect(cmdErr.Code).To(Equal(model.UserError))
+
+			err := records.Put(&lifecycle.Record{
+				UserID: testUser.UserID,
+				Status: lifecycle.StatusDeactivated,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			_, event, cmdErr = reactivateUser(lifecycleCmd("ReactivateUser", testUser.UserID))
+			Expect(cmdErr).To(BeNil())
+			Expect(event.Action).To(Equal("UserReactivated"))
+		})
+
+		It("should not update deactivated users", func() {
+			err := records.Put(&lifecycle.Record{
+				UserID: testUser.UserID,
+				Status: lifecycle.StatusDeactivated,
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			c := lifecycleCmd("UpdateUser", testUser.UserID)
+			c.cmd.Data = []byte(fmt.Sprintf(
+				`{"filter": {"userID": "%s"}, "update": {"firstName": "test-name"}}`,
+				testUser.UserID,
+			))
+			_, event, cmdErr := updateUser(c)
+			Expect(event).To(BeNil())
+			Expect(cmdErr.Code).To(Equal(model.UserError))
+			Expect(cmdErr.Message).To(Equal("user is deactivated"))
+		})
+
+		It("should not authenticate deactivated actors", func() {
+			authorizer, err := auth.NewAuthorizer(&auth.Config{
+				Users:      coll,
+				SigningKey: secrets.NewValue(""),
+				Lifecycle:  records,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			ctx := auth.WithUserUUID(context.Background(), testUser.UserID)
+
+			_, err = authorizer.Authenticate(ctx)
+			Expect(err).ToNot(HaveOccurred())
+
+			err = records.Put(&lifecycle.Record{
+				UserID: testUser.UserID,
+				Status: lifecycle.StatusDeactivated,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = authorizer.Authenticate(ctx)
+			Expect(err).To(HaveOccurred())
+
+			c := lifecycleCmd("ExportUserData", testUser.UserID)
+			c.ctx = ctx
+			c.auth = authorizer
+			cmdErr := authorize(c)
+			Expect(cmdErr).ToNot(BeNil())
+			Expect(cmdErr.Code).To(Equal(auth.UnauthorizedError))
+		})
+
+		Describe("Tombstones", func() {
+			var deleted *user.User
+
+			BeforeEach(func() {
+				uid, err := uuuid.NewV4()
+				Expect(err).ToNot(HaveOccurred())
+				deleted = &user.User{
+					UserID:   uid.String(),
+					UserName: uid.String(),
+				}
+				err = records.Put(&lifecycle.Record{
+					UserID:    deleted.UserID,
+					Status:    lifecycle.StatusDeleted,
+					DeletedAt: time.Now().Unix(),
+					User:      deleted,
+				})
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("should return UserRestored event within the grace-period", func() {
+				_, event, cmdErr := restoreUser(lifecycleCmd("RestoreUser", deleted.UserID))
+				Expect(cmdErr).To(BeNil())
+				Expect(event.Action).To(Equal("UserRestored"))
+			})
+
+			It("should return error if the grace-period has passed", func() {
+				c := lifecycleCmd("RestoreUser", deleted.UserID)
+				c.gracePeriod = 0
+				err := records.Put(&lifecycle.Record{
+					UserID:    deleted.UserID,
+					Status:    lifecycle.StatusDeleted,
+					DeletedAt: time.Now().Add(-time.Minute).Unix(),
+					User:      deleted,
+				})
+				Expect(err).ToNot(HaveOccurred())
+
+				_, event, cmdErr := restoreUser(c)
+				Expect(event).To(BeNil())
+				Expect(cmdErr.Code).To(Equal(model.UserError))
+			})
+
+			It("should return error if the UserName was taken since", func() {
+				uid, err := uuuid.NewV4()
+				Expect(err).ToNot(HaveOccurred())
+				_, err = coll.InsertOne(user.User{
+					UserID:   uid.String(),
+					UserName: deleted.UserName,
+				})
+				Expect(err).ToNot(HaveOccurred())
+
+				_, event, cmdErr := restoreUser(lifecycleCmd("RestoreUser", deleted.UserID))
+				Expect(event).To(BeNil())
+				Expect(cmdErr.Code).To(Equal(model.UserError))
+			})
+
+			It("should return UserPurged event for deleted and active users", func() {
+				_, event, cmdErr := purgeUser(lifecycleCmd("PurgeUser", deleted.UserID))
+				Expect(cmdErr).To(BeNil())
+				Expect(event.Action).To(Equal("UserPurged"))
+
+				_, event, cmdErr = purgeUser(lifecycleCmd("PurgeUser", testUser.UserID))
+				Expect(cmdErr).To(BeNil())
+				Expect(event.Action).To(Equal("UserPurged"))
+
+				_, event, cmdErr = purgeUser(lifecycleCmd("PurgeUser", "missing-user"))
+				Expect(event).To(BeNil())
+				Expect(cmdErr.Code).To(Equal(model.UserError))
+			})
+		})
 	})
 })
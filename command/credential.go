@@ -0,0 +1,62 @@
+package command
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/TerrexTech/agg-userauth-cmd/shred"
+	"github.com/TerrexTech/agg-userauth-model/user"
+	"github.com/TerrexTech/go-common-models/model"
+	"github.com/pkg/errors"
+)
+
+// credential is the data of CredentialSet and CredentialChanged Events.
+// Password-hashes are only carried by these Events, and never by
+// profile-Events such as UserRegistered, or by results.
+type credential struct {
+	UserID       string `json:"userID"`
+	PasswordHash string `json:"passwordHash"`
+	ChangedAt    int64  `json:"changedAt"`
+	ChangedBy    string `json:"changedBy,omitempty"`
+}
+
+// credentialEvent returns the Event setting the password-hash of the user.
+// The hash is encrypted with the user's key if erasure is enabled.
+func credentialEvent(
+	c *cmdConfig,
+	action string,
+	userID string,
+	passwordHash string,
+) (*model.Event, *model.Error) {
+	if c.keys != nil {
+		key, cmdErr := userKey(c, userID)
+		if cmdErr != nil {
+			return nil, cmdErr
+		}
+		encrypted, err := shred.Encrypt(key, passwordHash)
+		if err != nil {
+			err = errors.Wrap(err, "Error encrypting password-hash")
+			return nil, model.NewError(model.InternalError, err.Error())
+		}
+		passwordHash = encrypted
+	}
+
+	eventData, err := json.Marshal(&credential{
+		UserID:       userID,
+		PasswordHash: passwordHash,
+		ChangedAt:    time.Now().Unix(),
+		ChangedBy:    actorID(c),
+	})
+	if err != nil {
+		err = errors.Wrap(err, "Error marshalling Event-data")
+		return nil, model.NewError(model.InternalError, err.Error())
+	}
+	return newEvent(c, action, eventData)
+}
+
+// profile returns a copy of the user without its password.
+func profile(u *user.User) *user.User {
+	p := *u
+	p.Password = ""
+	return &p
+}
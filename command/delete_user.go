@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"time"
 
+	"github.com/TerrexTech/agg-userauth-cmd/tracing"
 	"github.com/TerrexTech/agg-userauth-model/user"
 	"github.com/TerrexTech/go-common-models/model"
 	"github.com/TerrexTech/uuuid"
@@ -11,9 +12,27 @@ import (
 )
 
 type deleteResult struct {
-	MatchedCount int `json:"matchedCount,omitempty"`
+	MatchedCount int      `json:"matchedCount,omitempty"`
+	UserIDs      []string `json:"userIDs,omitempty"`
 }
 
+// bulkDeleteParams is the data of BulkDeleteUsers-commands. ConfirmCount
+// must equal the number of users matched by Filter.
+type bulkDeleteParams struct {
+	Filter       *user.User `json:"filter,omitempty"`
+	ConfirmCount int        `json:"confirmCount,omitempty"`
+}
+
+// userDeletion is the data of UserDeleted Events. Only the users with the
+// UserIDs are deleted.
+type userDeletion struct {
+	UserIDs   []string `json:"userIDs"`
+	DeletedAt int64    `json:"deletedAt"`
+	DeletedBy string   `json:"deletedBy"`
+}
+
+// deleteUser deletes the user with the UserID. Other fields of the
+// command-data must also match the user.
 func deleteUser(c *cmdConfig) ([]byte, *model.Event, *model.Error) {
 	userModel := &user.User{}
 	err := json.Unmarshal(c.cmd.Data, userModel)
@@ -21,14 +40,83 @@ func deleteUser(c *cmdConfig) ([]byte, *model.Event, *model.Error) {
 		err = errors.Wrap(err, "Error unmarshalling cmd-data into User")
 		return nil, nil, model.NewError(model.InternalError, err.Error())
 	}
+	if userModel.UserID == "" {
+		err = errors.New("missing UserID, use BulkDeleteUsers to delete users by filter")
+		return nil, nil, model.NewError(model.UserError, err.Error())
+	}
+
+	userIDs, cmdErr := findUserIDs(c, userModel)
+	if cmdErr != nil {
+		return nil, nil, cmdErr
+	}
+	return deletionEvent(c, userIDs)
+}
+
+// bulkDeleteUsers deletes the users matched by the filter, if their count
+// is confirmed by the command.
+func bulkDeleteUsers(c *cmdConfig) ([]byte, *model.Event, *model.Error) {
+	params := &bulkDeleteParams{}
+	err := json.Unmarshal(c.cmd.Data, params)
+	if err != nil {
+		err = errors.Wrap(err, "Error unmarshalling cmd-data")
+		return nil, nil, model.NewError(model.InternalError, err.Error())
+	}
+	if params.Filter == nil || *params.Filter == (user.User{}) {
+		err = errors.New("missing filter")
+		return nil, nil, model.NewError(model.UserError, err.Error())
+	}
+	if params.ConfirmCount <= 0 {
+		err = errors.New("missing confirmCount")
+		return nil, nil, model.NewError(model.UserError, err.Error())
+	}
 
-	matches, err := c.coll.Find(userModel)
+	userIDs, cmdErr := findUserIDs(c, params.Filter)
+	if cmdErr != nil {
+		return nil, nil, cmdErr
+	}
+	if len(userIDs) != params.ConfirmCount {
+		err = errors.Errorf(
+			"filter matches %d users, but confirmCount is %d",
+			len(userIDs), params.ConfirmCount,
+		)
+		return nil, nil, model.NewError(model.UserError, err.Error())
+	}
+	return deletionEvent(c, userIDs)
+}
+
+// findUserIDs returns the UserIDs of the users matched by the filter,
+// or an error if none match.
+func findUserIDs(c *cmdConfig, filter *user.User) ([]string, *model.Error) {
+	fieldFilter, err := c.fields.Filter(filter)
+	if err != nil {
+		return nil, model.NewError(model.UserError, err.Error())
+	}
+	_, span := tracing.Start(c.ctx, "mongo.Find")
+	matches, err := c.coll.Find(fieldFilter)
+	tracing.End(span, err)
 	if err != nil || len(matches) == 0 {
 		err = errors.New("user not found")
-		return nil, nil, model.NewError(model.UserError, err.Error())
+		return nil, model.NewError(model.UserError, err.Error())
 	}
+
+	userIDs := make([]string, 0, len(matches))
+	for _, match := range matches {
+		u, assertOK := match.(*user.User)
+		if !assertOK {
+			err = errors.New("error asserting find-result to User")
+			return nil, model.NewError(model.InternalError, err.Error())
+		}
+		userIDs = append(userIDs, u.UserID)
+	}
+	return userIDs, nil
+}
+
+// deletionEvent creates the UserDeleted Event for the users, which records
+// who deleted the users and when on their tombstones.
+func deletionEvent(c *cmdConfig, userIDs []string) ([]byte, *model.Event, *model.Error) {
 	result := deleteResult{
-		MatchedCount: len(matches),
+		MatchedCount: len(userIDs),
+		UserIDs:      userIDs,
 	}
 	marshalResult, err := json.Marshal(result)
 	if err != nil {
@@ -36,6 +124,16 @@ func deleteUser(c *cmdConfig) ([]byte, *model.Event, *model.Error) {
 		return nil, nil, model.NewError(model.InternalError, err.Error())
 	}
 
+	eventData, err := json.Marshal(&userDeletion{
+		UserIDs:   userIDs,
+		DeletedAt: time.Now().Unix(),
+		DeletedBy: actorID(c),
+	})
+	if err != nil {
+		err = errors.Wrap(err, "Error marshalling Event-data")
+		return nil, nil, model.NewError(model.InternalError, err.Error())
+	}
+
 	uuid, err := uuuid.NewV4()
 	if err != nil {
 		err = errors.Wrap(err, "Error generating Event-UUID")
@@ -45,7 +143,7 @@ func deleteUser(c *cmdConfig) ([]byte, *model.Event, *model.Error) {
 		Action:        "UserDeleted",
 		AggregateID:   user.AggregateID,
 		CorrelationID: c.cmd.UUID,
-		Data:          c.cmd.Data,
+		Data:          eventData,
 		NanoTime:      time.Now().UnixNano(),
 		Source:        "agg-userauth-cmd",
 		UUID:          uuid,
@@ -0,0 +1,101 @@
+package command
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/TerrexTech/agg-userauth-cmd/shred"
+	"github.com/TerrexTech/agg-userauth-cmd/tracing"
+	"github.com/TerrexTech/agg-userauth-model/user"
+	"github.com/TerrexTech/go-common-models/model"
+	"github.com/pkg/errors"
+)
+
+// userErasure is the data of UserErased Events.
+type userErasure struct {
+	UserID   string `json:"userID"`
+	ErasedAt int64  `json:"erasedAt"`
+	ErasedBy string `json:"erasedBy"`
+}
+
+// eraseUser erases the personal data of the user by destroying its key when
+// the UserErased Event is applied. Events of the user can then no longer be
+// decrypted, and the user is anonymized in the projections.
+func eraseUser(c *cmdConfig) ([]byte, *model.Event, *model.Error) {
+	if c.keys == nil {
+		err := errors.New("erasure is not enabled")
+		return nil, nil, model.NewError(model.UserError, err.Error())
+	}
+	params := &struct {
+		UserID string `json:"userID"`
+	}{}
+	err := json.Unmarshal(c.cmd.Data, params)
+	if err != nil {
+		err = errors.Wrap(err, "Error unmarshalling command-data")
+		return nil, nil, model.NewError(model.InternalError, err.Error())
+	}
+	if params.UserID == "" {
+		err = errors.New("missing UserID")
+		return nil, nil, model.NewError(model.UserError, err.Error())
+	}
+
+	_, span := tracing.Start(c.ctx, "mongo.FindKey")
+	key, err := c.keys.Key(params.UserID)
+	tracing.End(span, err)
+	if err != nil {
+		err = errors.Wrap(err, "Error finding key")
+		return nil, nil, model.NewError(model.DatabaseError, err.Error())
+	}
+	if key == nil {
+		err = errors.New("user not found or already erased")
+		return nil, nil, model.NewError(model.UserError, err.Error())
+	}
+
+	erasure := &userErasure{
+		UserID:   params.UserID,
+		ErasedAt: time.Now().Unix(),
+		ErasedBy: actorID(c),
+	}
+	eventData, err := json.Marshal(erasure)
+	if err != nil {
+		err = errors.Wrap(err, "Error marshalling Event-data")
+		return nil, nil, model.NewError(model.InternalError, err.Error())
+	}
+	event, cmdErr := newEvent(c, "UserErased", eventData)
+	if cmdErr != nil {
+		return nil, nil, cmdErr
+	}
+	return eventData, event, nil
+}
+
+// encryptUser returns a copy of the user with its personal data encrypted
+// with its key, for the Event-data. The key is created if the user has none.
+// The user is returned as it is if erasure is disabled.
+func encryptUser(c *cmdConfig, u *user.User) (*user.User, *model.Error) {
+	if c.keys == nil {
+		return u, nil
+	}
+	key, cmdErr := userKey(c, u.UserID)
+	if cmdErr != nil {
+		return nil, cmdErr
+	}
+	encrypted := *u
+	err := shred.EncryptUser(key, &encrypted)
+	if err != nil {
+		err = errors.Wrap(err, "Error encrypting User")
+		return nil, model.NewError(model.InternalError, err.Error())
+	}
+	return &encrypted, nil
+}
+
+// userKey returns the key of the user, creating it if the user has none.
+func userKey(c *cmdConfig, userID string) ([]byte, *model.Error) {
+	_, span := tracing.Start(c.ctx, "mongo.CreateKey")
+	key, err := c.keys.CreateKey(userID)
+	tracing.End(span, err)
+	if err != nil {
+		err = errors.Wrap(err, "Error creating key")
+		return nil, model.NewError(model.DatabaseError, err.Error())
+	}
+	return key, nil
+}
@@ -0,0 +1,173 @@
+package command
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/TerrexTech/agg-userauth-cmd/history"
+	"github.com/TerrexTech/agg-userauth-cmd/lifecycle"
+	"github.com/TerrexTech/agg-userauth-cmd/shred"
+	"github.com/TerrexTech/agg-userauth-cmd/tracing"
+	"github.com/TerrexTech/agg-userauth-model/user"
+	"github.com/TerrexTech/go-common-models/model"
+	"github.com/pkg/errors"
+)
+
+// statusActive is the Status of users without a lifecycle.Record.
+const statusActive = "active"
+
+// UserExport is the result of ExportUserData-commands, which holds all data
+// kept on a user, with secrets redacted.
+type UserExport struct {
+	UserID     string `json:"userID"`
+	ExportedAt int64  `json:"exportedAt"`
+	// Status is active, or the user's lifecycle.Status.
+	Status string `json:"status"`
+	// User is the user's current state, which is its tombstone if it
+	// was deleted.
+	User   json.RawMessage `json:"user,omitempty"`
+	Roles  []string        `json:"roles,omitempty"`
+	Events []*ExportEvent  `json:"events"`
+}
+
+// ExportEvent is an Event applied to the user.
+type ExportEvent struct {
+	*history.Entry
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// exportUserData returns the UserExport of the user in the command-data.
+// It produces no Event.
+func exportUserData(c *cmdConfig) ([]byte, *model.Event, *model.Error) {
+	if c.history == nil {
+		err := errors.New("user-export is not enabled")
+		return nil, nil, model.NewError(model.UserError, err.Error())
+	}
+	params := &struct {
+		UserID string `json:"userID"`
+	}{}
+	err := json.Unmarshal(c.cmd.Data, params)
+	if err != nil {
+		err = errors.Wrap(err, "Error unmarshalling command-data")
+		return nil, nil, model.NewError(model.InternalError, err.Error())
+	}
+	if params.UserID == "" {
+		err = errors.New("missing UserID")
+		return nil, nil, model.NewError(model.UserError, err.Error())
+	}
+
+	export := &UserExport{
+		UserID:     params.UserID,
+		ExportedAt: time.Now().Unix(),
+		Events:     []*ExportEvent{},
+	}
+	userModel, cmdErr := exportedUser(c, export)
+	if cmdErr != nil {
+		return nil, nil, cmdErr
+	}
+
+	_, span := tracing.Start(c.ctx, "mongo.FindHistory")
+	entries, err := c.history.Entries(params.UserID)
+	tracing.End(span, err)
+	if err != nil {
+		err = errors.Wrap(err, "Error finding user-history")
+		return nil, nil, model.NewError(model.DatabaseError, err.Error())
+	}
+	if userModel == nil && len(entries) == 0 {
+		err = errors.New("user not found")
+		return nil, nil, model.NewError(model.UserError, err.Error())
+	}
+	// Personal data in Events is decrypted with the user's key
+	var key []byte
+	if c.keys != nil {
+		key, err = c.keys.Key(params.UserID)
+		if err != nil {
+			err = errors.Wrap(err, "Error finding key")
+			return nil, nil, model.NewError(model.DatabaseError, err.Error())
+		}
+	}
+	for _, entry := range entries {
+		event := &ExportEvent{
+			Entry: entry,
+		}
+		if entry.Data != "" {
+			data, err := shred.DecryptJSON(key, []byte(entry.Data))
+			if err != nil {
+				err = errors.Wrap(err, "Error decrypting Event-data")
+				return nil, nil, model.NewError(model.InternalError, err.Error())
+			}
+			event.Data = json.RawMessage(data)
+		}
+		export.Events = append(export.Events, event)
+	}
+
+	if userModel != nil {
+		// Passwords are cleared rather than redacted, so User only holds
+		// the fields of user.User.
+		exportModel := *userModel
+		exportModel.Password = ""
+		export.User, err = json.Marshal(&exportModel)
+		if err != nil {
+			err = errors.Wrap(err, "Error marshalling User")
+			return nil, nil, model.NewError(model.InternalError, err.Error())
+		}
+	}
+	if c.roles != nil {
+		_, span := tracing.Start(c.ctx, "mongo.FindUserRoles")
+		export.Roles, err = c.roles.UserRoles(params.UserID)
+		tracing.End(span, err)
+		if err != nil {
+			err = errors.Wrap(err, "Error finding user-roles")
+			return nil, nil, model.NewError(model.DatabaseError, err.Error())
+		}
+	}
+
+	result, err := json.Marshal(export)
+	if err != nil {
+		err = errors.Wrap(err, "Error marshalling UserExport")
+		return nil, nil, model.NewError(model.InternalError, err.Error())
+	}
+	return result, nil, nil
+}
+
+// exportedUser returns the current state of the user and sets the export's
+// Status, or returns nil if the user is neither active nor a tombstone.
+func exportedUser(c *cmdConfig, export *UserExport) (*user.User, *model.Error) {
+	var record *lifecycle.Record
+	if c.lifecycle != nil {
+		var err error
+		_, span := tracing.Start(c.ctx, "mongo.FindLifecycle")
+		record, err = c.lifecycle.Record(export.UserID)
+		tracing.End(span, err)
+		if err != nil {
+			err = errors.Wrap(err, "Error finding lifecycle-record")
+			return nil, model.NewError(model.DatabaseError, err.Error())
+		}
+	}
+	if record != nil && record.Status == lifecycle.StatusDeleted {
+		export.Status = record.Status
+		// Tombstones are encrypted as the projection is
+		err := c.fields.DecryptUser(record.User)
+		if err != nil {
+			err = errors.Wrap(err, "Error decrypting tombstone")
+			return nil, model.NewError(model.InternalError, err.Error())
+		}
+		return record.User, nil
+	}
+
+	users, err := findUsers(c, &user.User{
+		UserID: export.UserID,
+	})
+	if err != nil {
+		err = errors.Wrap(err, "Error finding User")
+		return nil, model.NewError(model.DatabaseError, err.Error())
+	}
+	if len(users) == 0 {
+		return nil, nil
+	}
+	export.Status = statusActive
+	if record != nil {
+		export.Status = record.Status
+	}
+	return users[0], nil
+}
@@ -1,18 +1,108 @@
 package command
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"time"
 
+	"github.com/TerrexTech/agg-userauth-cmd/auth"
+	"github.com/TerrexTech/agg-userauth-cmd/fieldcrypt"
+	"github.com/TerrexTech/agg-userauth-cmd/history"
+	"github.com/TerrexTech/agg-userauth-cmd/importer"
+	"github.com/TerrexTech/agg-userauth-cmd/lifecycle"
+	"github.com/TerrexTech/agg-userauth-cmd/logger"
+	"github.com/TerrexTech/agg-userauth-cmd/metrics"
+	"github.com/TerrexTech/agg-userauth-cmd/rbac"
+	"github.com/TerrexTech/agg-userauth-cmd/shred"
+	"github.com/TerrexTech/agg-userauth-cmd/tracing"
 	"github.com/TerrexTech/go-common-models/model"
 	"github.com/TerrexTech/go-mongoutils/mongo"
 	"github.com/TerrexTech/uuuid"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
 type cmdConfig struct {
+	// ctx carries the trace-context of the command, and can be nil
+	ctx         context.Context
 	coll        *mongo.Collection
 	serviceName string
 	cmd         *model.Command
+	// roles is nil if role-based access control is disabled
+	roles *rbac.Store
+	// auth is nil if Commands are not authorized
+	auth *auth.Authorizer
+	// lifecycle is nil if soft-delete is disabled
+	lifecycle   *lifecycle.Store
+	gracePeriod time.Duration
+	// imports is nil if bulk import is disabled
+	imports      *importer.Store
+	maxBatchSize int
+	// history is nil if user-export is disabled
+	history *history.Store
+	// keys is nil if erasure is disabled
+	keys *shred.Store
+	// fields is nil if field-level encryption is disabled
+	fields *fieldcrypt.Codec
+
+	// followUps are Events produced after the action's Event, such as the
+	// CredentialSet Event of a registered user.
+	followUps []*model.Event
+}
+
+// actionFunc handles a Command, and returns its result
+// and the resulting Event (nil if the command produced none).
+// Any further Events are added to the config's followUps.
+type actionFunc func(config *cmdConfig) ([]byte, *model.Event, *model.Error)
+
+// actions are the handlers for each Command-Action.
+var actions = map[string]actionFunc{
+	"RegisterUser":    registerUser,
+	"DeleteUser":      deleteUser,
+	"BulkDeleteUsers": bulkDeleteUsers,
+	"UpdateUser":      updateUser,
+	"DefineRole":      defineRole,
+	"AssignRole":      assignRole,
+	"RevokeRole":      revokeRole,
+
+	"DeactivateUser": deactivateUser,
+	"ReactivateUser": reactivateUser,
+	"RestoreUser":    restoreUser,
+	"PurgeUser":      purgeUser,
+
+	"ImportUsers":    importUsers,
+	"ImportProgress": getImportProgress,
+
+	"ExportUserData": exportUserData,
+	"EraseUser":      eraseUser,
+
+	"RotateFieldKeys": rotateFieldKeys,
+}
+
+// IsAction returns true if the Command-Action has a handler.
+func IsAction(action string) bool {
+	_, ok := actions[action]
+	return ok
+}
+
+// ActionLabel returns the metrics-label for the Command-Action, which is
+// metrics.UnknownAction for Actions without a handler.
+func ActionLabel(action string) string {
+	if !IsAction(action) {
+		return metrics.UnknownAction
+	}
+	return action
+}
+
+// EventMsg is an Event queued for producing, along with its message-key and
+// headers. The producer reports the delivery-result of Event on Result once
+// the broker acknowledges it.
+type EventMsg struct {
+	Event   *model.Event
+	Key     string
+	Headers map[string]string
+	Result  chan<- error
 }
 
 // HandlerConfig is the config for Command-Handler.
@@ -20,8 +110,62 @@ type HandlerConfig struct {
 	Coll        *mongo.Collection
 	ServiceName string
 
-	EventProd  chan<- *model.Event
-	ResultProd chan<- *model.Document
+	EventProd  chan<- *EventMsg
+	ResultProd chan<- *ResponseMsg
+
+	// PublishEvents is optional. If set, the Events of Commands with several
+	// Events are produced using it instead of EventProd, which must produce
+	// them in a single transaction, so either all of them are emitted or
+	// none are.
+	PublishEvents func(eventMsgs []*EventMsg) error
+
+	// Outbox is optional. If set, Events and Responses are written to the
+	// Outbox and published by the outbox-relay instead of EventProd and
+	// ResultProd. ResultProd is then only used if writing to Outbox fails.
+	Outbox *Outbox
+
+	// SendTimeout is the maximum time to wait for a message to be queued for
+	// the producers. A command whose Event cannot be queued gets an
+	// error-response. Zero means no timeout.
+	SendTimeout time.Duration
+
+	// Done is optional, and is closed when the producers stop, such as on
+	// shutdown. Events still awaiting their delivery-result then fail, since
+	// the producers no longer report it.
+	Done <-chan struct{}
+
+	// Logger is optional, and defaults to logger.DefaultLogger().
+	Logger *logger.Logger
+
+	// Roles is optional, and enables role-based access control if set.
+	// Roles of registered users must then be defined in its catalogue.
+	Roles *rbac.Store
+
+	// Auth is optional. If set, Commands are only handled if their actor is
+	// permitted to issue them, else they get an auth.UnauthorizedError.
+	Auth *auth.Authorizer
+
+	// Lifecycle is optional, and enables deactivating users, and restoring
+	// deleted users within the RestoreGracePeriod.
+	Lifecycle          *lifecycle.Store
+	RestoreGracePeriod time.Duration
+
+	// Imports is optional, and enables ImportUsers-commands with at most
+	// MaxImportBatchSize users (unlimited if zero).
+	Imports            *importer.Store
+	MaxImportBatchSize int
+
+	// History is optional, and enables ExportUserData-commands.
+	History *history.Store
+
+	// Keys is optional. If set, the personal data of users in Events is
+	// encrypted with their keys, and EraseUser-commands erase users by
+	// destroying their keys.
+	Keys *shred.Store
+
+	// Fields is optional. If set, the fields of users in Coll are encrypted,
+	// and RotateFieldKeys-commands re-encrypt them with the active key.
+	Fields *fieldcrypt.Codec
 }
 
 // Handler for commands.
@@ -37,6 +181,9 @@ func NewHandler(config *HandlerConfig) (*Handler, error) {
 	if config.EventProd == nil {
 		return nil, errors.New("EventProd cannot be nil")
 	}
+	if config.ResultProd == nil {
+		return nil, errors.New("ResultProd cannot be nil")
+	}
 	if config.ServiceName == "" {
 		return nil, errors.New("ServiceName cannot be blank")
 	}
@@ -46,54 +193,130 @@ func NewHandler(config *HandlerConfig) (*Handler, error) {
 	}, nil
 }
 
-// Handle handles the provided command.
-func (h *Handler) Handle(cmd *model.Command) {
+// Handle handles the provided command and emits the resulting Event
+// and Response.
+func (h *Handler) Handle(ctx context.Context, cmd *model.Command) {
+	ctx = h.withLogger(ctx, cmd)
+	eventMsgs, respMsg := h.process(ctx, cmd)
+	h.emit(ctx, eventMsgs, respMsg)
+}
+
+// Execute handles the provided command, emits the resulting Event, and
+// returns the Response-Document, such as for synchronous APIs.
+// The Response is only produced if the command has a ResponseTopic.
+func (h *Handler) Execute(ctx context.Context, cmd *model.Command) *model.Document {
+	ctx = h.withLogger(ctx, cmd)
+	eventMsgs, respMsg := h.process(ctx, cmd)
+	h.emit(ctx, eventMsgs, respMsg)
+	return respMsg.Document
+}
+
+// Process handles the provided command and returns the resulting Events
+// (in the order they must be produced, and none if the command produced none)
+// and Response without emitting them.
+// The trace-context from ctx is injected into the headers of all of them.
+func (h *Handler) Process(ctx context.Context, cmd *model.Command) ([]*EventMsg, *ResponseMsg) {
+	return h.process(h.withLogger(ctx, cmd), cmd)
+}
+
+// withLogger returns a context carrying a Logger with the command's fields.
+func (h *Handler) withLogger(ctx context.Context, cmd *model.Command) context.Context {
+	cmdLog := h.Logger.WithCommand(cmd)
+	if userID := userIDFromData(cmd.Data); userID != "" {
+		cmdLog = cmdLog.With(logger.Fields{
+			logger.FieldUserID: userID,
+		})
+	}
+	return logger.NewContext(ctx, cmdLog)
+}
+
+// cmdConfig returns the config for handling the Command with the Handler's
+// stores.
+func (h *Handler) cmdConfig(ctx context.Context, cmd *model.Command) *cmdConfig {
+	return &cmdConfig{
+		ctx:         ctx,
+		coll:        h.Coll,
+		serviceName: h.ServiceName,
+		cmd:         cmd,
+		roles:       h.Roles,
+		auth:        h.Auth,
+		lifecycle:   h.Lifecycle,
+		gracePeriod: h.RestoreGracePeriod,
+
+		imports:      h.Imports,
+		maxBatchSize: h.MaxImportBatchSize,
+		history:      h.History,
+		keys:         h.Keys,
+		fields:       h.Fields,
+	}
+}
+
+func (h *Handler) process(ctx context.Context, cmd *model.Command) ([]*EventMsg, *ResponseMsg) {
+	cmdLog := logger.FromContext(ctx)
+
 	var (
 		result []byte
 		event  *model.Event
 		cmdErr *model.Error
 	)
 
-	config := &cmdConfig{
-		coll:        h.Coll,
-		serviceName: h.ServiceName,
-		cmd:         cmd,
-	}
+	ctx, span := tracing.Start(
+		ctx,
+		cmd.Action,
+		attribute.String("command.uuid", cmd.UUID.String()),
+		attribute.String("command.correlationID", cmd.CorrelationID.String()),
+	)
+	defer span.End()
 
-	switch cmd.Action {
-	case "RegisterUser":
-		result, event, cmdErr = registerUser(config)
-		if cmdErr == nil {
-			h.EventProd <- event
-		} else {
-			log.Println(cmdErr.Message)
-		}
+	config := h.cmdConfig(ctx, cmd)
 
-	case "DeleteUser":
-		result, event, cmdErr = deleteUser(config)
-		if cmdErr == nil {
-			h.EventProd <- event
-		} else {
-			log.Println(cmdErr.Message)
+	if handleAction, ok := actions[cmd.Action]; ok {
+		if h.Auth != nil {
+			cmdErr = authorize(config)
 		}
-
-	case "UpdateUser":
-		result, event, cmdErr = updateUser(config)
 		if cmdErr == nil {
-			h.EventProd <- event
-		} else {
-			log.Println(cmdErr.Message)
+			result, event, cmdErr = handleAction(config)
 		}
+	} else {
+		// Unregistered Actions are errors, so they are not counted as handled
+		// successfully under an Action-label chosen by the client.
+		cmdLog.Warnf("Command contains unregistered Action: %s", cmd.Action)
+		cmdErr = model.NewError(
+			model.UserError, fmt.Sprintf("unregistered Action: %s", cmd.Action),
+		)
+	}
 
-	default:
-		log.Printf("Command contains unregistered Action: %s", cmd.Action)
+	var eventMsgs []*EventMsg
+	action := ActionLabel(cmd.Action)
+	if cmdErr != nil {
+		cmdLog.Errorf("Error handling Command: %s", cmdErr.Message)
+		span.SetStatus(codes.Error, cmdErr.Message)
+		metrics.CommandErrors.WithLabelValues(action, metrics.ErrorCode(cmdErr.Code)).Inc()
+		metrics.CommandsHandled.WithLabelValues(action, metrics.OutcomeError).Inc()
+	} else {
+		metrics.CommandsHandled.WithLabelValues(action, metrics.OutcomeSuccess).Inc()
+	}
+	if cmdErr == nil {
+		events := config.followUps
+		if event != nil {
+			events = append([]*model.Event{event}, events...)
+		}
+		for _, e := range events {
+			eventMsg := &EventMsg{
+				Event:   e,
+				Key:     eventKey(e),
+				Headers: msgHeaders(cmd, e.Action, h.ServiceName),
+			}
+			tracing.Inject(ctx, eventMsg.Headers)
+			eventMsgs = append(eventMsgs, eventMsg)
+		}
 	}
 
 	// Producer result
 	docID, err := uuuid.NewV4()
 	if err != nil {
 		err = errors.Wrap(err, "Erro generating CorrelationID")
-		log.Println(err)
+		cmdLog.Error(err)
 	}
 	var (
 		cmdErrMsg  string
@@ -112,5 +335,134 @@ func (h *Handler) Handle(cmd *model.Command) {
 		Topic:         cmd.ResponseTopic,
 		UUID:          docID,
 	}
-	h.ResultProd <- doc
+	respMsg := &ResponseMsg{
+		Document: doc,
+		Headers:  msgHeaders(cmd, cmd.Action, h.ServiceName),
+	}
+	tracing.Inject(ctx, respMsg.Headers)
+
+	return eventMsgs, respMsg
+}
+
+// emit writes the Events and Response to the Outbox if one is configured,
+// else produces them directly.
+func (h *Handler) emit(ctx context.Context, eventMsgs []*EventMsg, respMsg *ResponseMsg) {
+	if h.Outbox != nil {
+		_, span := tracing.Start(ctx, "mongo.Outbox.Add")
+		err := h.Outbox.Add(eventMsgs, respMsg)
+		tracing.End(span, err)
+		if err == nil {
+			return
+		}
+		// Nothing was recorded, so the client is told the command failed
+		err = errors.Wrap(err, "Error writing to Outbox")
+		logger.FromContext(ctx).Error(err)
+		setDocError(respMsg, err)
+		h.sendResponse(ctx, respMsg)
+		return
+	}
+
+	// The response is only produced once the Events are confirmed, so
+	// the client is never told "success" for an Event that was lost.
+	err := h.publishEvents(ctx, eventMsgs)
+	if err != nil {
+		logger.FromContext(ctx).Error(err)
+		setDocError(respMsg, err)
+	}
+
+	_, span := tracing.Start(ctx, "ProduceResponse")
+	h.sendResponse(ctx, respMsg)
+	span.End()
+}
+
+// publishEvents produces the Events in order. Commands with several Events
+// are produced using PublishEvents if it is set, so either all of their
+// Events are emitted or none are. Else the rest of the Events are dropped
+// once one fails.
+func (h *Handler) publishEvents(ctx context.Context, eventMsgs []*EventMsg) error {
+	if len(eventMsgs) > 1 && h.PublishEvents != nil {
+		_, span := tracing.Start(ctx, "ProduceEvents")
+		err := h.PublishEvents(eventMsgs)
+		tracing.End(span, err)
+		if err != nil {
+			err = errors.Wrap(err, "Error publishing Events")
+			return err
+		}
+		return nil
+	}
+
+	for _, eventMsg := range eventMsgs {
+		_, span := tracing.Start(ctx, "ProduceEvent")
+		err := h.publishEvent(eventMsg)
+		tracing.End(span, err)
+		if err != nil {
+			err = errors.Wrapf(err, "Error publishing %s Event", eventMsg.Event.Action)
+			return err
+		}
+	}
+	return nil
+}
+
+// publishEvent produces the Event and blocks until the broker
+// acknowledges or rejects it. Once queued, the Event's delivery-result is
+// always awaited, so a Response never reports a delivered Event as failed.
+// The producer reports the result within its own retry-timeouts, unless it
+// stopped, which is signalled by Done.
+func (h *Handler) publishEvent(msg *EventMsg) error {
+	resultChan := make(chan error, 1)
+	msg.Result = resultChan
+
+	select {
+	case h.EventProd <- msg:
+	case <-h.timeoutChan():
+		return errors.New("timed out queueing Event for producer")
+	case <-h.Done:
+		return errors.New("producer stopped before queueing Event")
+	}
+
+	select {
+	case err := <-resultChan:
+		return err
+	case <-h.Done:
+		return errors.New("producer stopped before reporting delivery-result of Event")
+	}
+}
+
+// sendResponse queues the Response for the producer. The Response is dropped
+// if it cannot be queued within SendTimeout.
+func (h *Handler) sendResponse(ctx context.Context, msg *ResponseMsg) {
+	// Commands from synchronous APIs receive their Response directly
+	if msg.Document.Topic == "" {
+		return
+	}
+	select {
+	case h.ResultProd <- msg:
+	case <-h.timeoutChan():
+		logger.FromContext(ctx).Errorf(
+			"Timed out queueing Response %s for producer", msg.Document.UUID,
+		)
+	}
+}
+
+// timeoutChan returns a channel that fires after SendTimeout,
+// or never fires if SendTimeout is not set.
+func (h *Handler) timeoutChan() <-chan time.Time {
+	if h.SendTimeout <= 0 {
+		return nil
+	}
+	return time.After(h.SendTimeout)
+}
+
+// setDocError replaces the result in the Response with an internal-error.
+// The error is counted unless the Response already carried one, which was
+// counted when the Command was handled.
+func setDocError(msg *ResponseMsg, err error) {
+	doc := msg.Document
+	if doc.Error == "" {
+		action := ActionLabel(msg.Headers[HeaderAction])
+		metrics.CommandErrors.WithLabelValues(action, metrics.ErrorCode(model.InternalError)).Inc()
+	}
+	doc.Data = nil
+	doc.Error = err.Error()
+	doc.ErrorCode = model.InternalError
 }
@@ -0,0 +1,75 @@
+package command
+
+import (
+	"encoding/json"
+
+	"github.com/TerrexTech/go-common-models/model"
+)
+
+// Header-keys set on produced Events and Responses.
+const (
+	HeaderCorrelationID = "correlationID"
+	HeaderCausationID   = "causationID"
+	HeaderAction        = "action"
+	HeaderSource        = "source"
+	HeaderSchemaVersion = "schemaVersion"
+)
+
+// SchemaVersion is the version of the Event and Response payloads
+// produced by this service.
+const SchemaVersion = "1"
+
+// ResponseMsg is a Response queued for producing, along with its headers.
+type ResponseMsg struct {
+	Document *model.Document
+	Headers  map[string]string
+}
+
+// msgHeaders returns the headers for a message produced for the command.
+// The CorrelationID is carried over from the command, and the command
+// itself is the cause of the message.
+func msgHeaders(cmd *model.Command, action string, source string) map[string]string {
+	return map[string]string{
+		HeaderCorrelationID: cmd.CorrelationID.String(),
+		HeaderCausationID:   cmd.UUID.String(),
+		HeaderAction:        action,
+		HeaderSource:        source,
+		HeaderSchemaVersion: SchemaVersion,
+	}
+}
+
+// eventKey returns the UserID of the user the Event applies to. This is used
+// as the message-key, so all Events for a user land on the same partition and
+// are consumed in order downstream.
+func eventKey(event *model.Event) string {
+	return userIDFromData(event.Data)
+}
+
+// userIDFromData returns the UserID from Event or Command data,
+// or an empty string if the data contains none.
+func userIDFromData(data []byte) string {
+	keyData := &struct {
+		UserID  string   `json:"userID"`
+		UserIDs []string `json:"userIDs"`
+		Update  *struct {
+			UserID string `json:"userID"`
+		} `json:"update"`
+	}{}
+	err := json.Unmarshal(data, keyData)
+	if err != nil {
+		return ""
+	}
+
+	if keyData.UserID != "" {
+		return keyData.UserID
+	}
+	// UserDeleted Events contain the UserIDs of the deleted users
+	if len(keyData.UserIDs) == 1 {
+		return keyData.UserIDs[0]
+	}
+	// UserUpdated Events contain the complete updated user
+	if keyData.Update != nil {
+		return keyData.Update.UserID
+	}
+	return ""
+}
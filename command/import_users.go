@@ -0,0 +1,196 @@
+package command
+
+import (
+	"encoding/json"
+
+	"github.com/TerrexTech/agg-userauth-cmd/importer"
+	"github.com/TerrexTech/agg-userauth-cmd/tracing"
+	"github.com/TerrexTech/agg-userauth-model/user"
+	"github.com/TerrexTech/go-common-models/model"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// importUsers validates each user in the Batch as for RegisterUser, and
+// returns the UsersImported Event with the profiles of the valid users,
+// followed by a CredentialSet Event for each of them. Invalid users are
+// reported in the result. The Event is also produced if no user is valid,
+// so the import's progress advances past the Batch.
+func importUsers(c *cmdConfig) ([]byte, *model.Event, *model.Error) {
+	if c.imports == nil {
+		err := errors.New("bulk import is not enabled")
+		return nil, nil, model.NewError(model.UserError, err.Error())
+	}
+
+	batch := &importer.Batch{}
+	err := json.Unmarshal(c.cmd.Data, batch)
+	if err != nil {
+		err = errors.Wrap(err, "Error unmarshalling command-data into Batch")
+		return nil, nil, model.NewError(model.InternalError, err.Error())
+	}
+	if batch.ImportID == "" {
+		err = errors.New("missing ImportID")
+		return nil, nil, model.NewError(model.UserError, err.Error())
+	}
+	if len(batch.Users) == 0 {
+		err = errors.New("batch contains no users")
+		return nil, nil, model.NewError(model.UserError, err.Error())
+	}
+	if c.maxBatchSize > 0 && len(batch.Users) > c.maxBatchSize {
+		err = errors.Errorf("batch exceeds maximum size of %d users", c.maxBatchSize)
+		return nil, nil, model.NewError(model.UserError, err.Error())
+	}
+
+	progress, cmdErr := importProgress(c, batch.ImportID)
+	if cmdErr != nil {
+		return nil, nil, cmdErr
+	}
+	if batch.Offset != progress.NextOffset {
+		err = errors.Errorf(
+			"batch offset %d does not match the import's next offset %d",
+			batch.Offset, progress.NextOffset,
+		)
+		return nil, nil, model.NewError(model.UserError, err.Error())
+	}
+
+	imported := &importer.ImportedBatch{
+		ImportID:   batch.ImportID,
+		Offset:     batch.Offset,
+		NextOffset: batch.Offset + len(batch.Users),
+		Users:      []*user.User{},
+	}
+	result := &importer.BatchResult{
+		ImportID:   batch.ImportID,
+		Offset:     batch.Offset,
+		NextOffset: imported.NextOffset,
+	}
+	// Users must also be unique within the Batch
+	seenIDs := map[string]bool{}
+	seenNames := map[string]bool{}
+	validUsers := []*user.User{}
+
+	for i, userModel := range batch.Users {
+		rowErr := validateImportedUser(c, userModel)
+		if rowErr == nil && (seenIDs[userModel.UserID] || seenNames[userModel.UserName]) {
+			rowErr = model.NewError(model.UserError, "user is duplicated in batch")
+		}
+		if rowErr != nil {
+			result.Errors = append(result.Errors, &importer.RowError{
+				Offset: batch.Offset + i,
+				Error:  rowErr.Message,
+			})
+			continue
+		}
+		seenIDs[userModel.UserID] = true
+		seenNames[userModel.UserName] = true
+		validUsers = append(validUsers, userModel)
+		eventUser, cmdErr := encryptUser(c, profile(userModel))
+		if cmdErr != nil {
+			return nil, nil, cmdErr
+		}
+		imported.Users = append(imported.Users, eventUser)
+	}
+	result.Imported = len(imported.Users)
+
+	eventData, err := json.Marshal(imported)
+	if err != nil {
+		err = errors.Wrap(err, "Error marshalling ImportedBatch")
+		return nil, nil, model.NewError(model.InternalError, err.Error())
+	}
+	resultData, err := json.Marshal(result)
+	if err != nil {
+		err = errors.Wrap(err, "Error marshalling BatchResult")
+		return nil, nil, model.NewError(model.InternalError, err.Error())
+	}
+	event, cmdErr := newEvent(c, "UsersImported", eventData)
+	if cmdErr != nil {
+		return nil, nil, cmdErr
+	}
+	for _, u := range validUsers {
+		credEvent, cmdErr := credentialEvent(c, "CredentialSet", u.UserID, u.Password)
+		if cmdErr != nil {
+			return nil, nil, cmdErr
+		}
+		c.followUps = append(c.followUps, credEvent)
+	}
+	return resultData, event, nil
+}
+
+// getImportProgress returns the Progress of the import in the command-data,
+// for resuming imports. It produces no Event.
+func getImportProgress(c *cmdConfig) ([]byte, *model.Event, *model.Error) {
+	if c.imports == nil {
+		err := errors.New("bulk import is not enabled")
+		return nil, nil, model.NewError(model.UserError, err.Error())
+	}
+	params := &importer.Batch{}
+	err := json.Unmarshal(c.cmd.Data, params)
+	if err != nil {
+		err = errors.Wrap(err, "Error unmarshalling command-data")
+		return nil, nil, model.NewError(model.InternalError, err.Error())
+	}
+	if params.ImportID == "" {
+		err = errors.New("missing ImportID")
+		return nil, nil, model.NewError(model.UserError, err.Error())
+	}
+
+	progress, cmdErr := importProgress(c, params.ImportID)
+	if cmdErr != nil {
+		return nil, nil, cmdErr
+	}
+	result, err := json.Marshal(progress)
+	if err != nil {
+		err = errors.Wrap(err, "Error marshalling Progress")
+		return nil, nil, model.NewError(model.InternalError, err.Error())
+	}
+	return result, nil, nil
+}
+
+func importProgress(c *cmdConfig, importID string) (*importer.Progress, *model.Error) {
+	_, span := tracing.Start(c.ctx, "mongo.FindImportProgress")
+	progress, err := c.imports.Progress(importID)
+	tracing.End(span, err)
+	if err != nil {
+		err = errors.Wrap(err, "Error finding import-progress")
+		return nil, model.NewError(model.DatabaseError, err.Error())
+	}
+	return progress, nil
+}
+
+// validateImportedUser validates the user as for RegisterUser, and hashes
+// its password unless it is already a bcrypt-hash.
+func validateImportedUser(c *cmdConfig, userModel *user.User) *model.Error {
+	if userModel == nil {
+		return model.NewError(model.UserError, "missing user")
+	}
+	_, idErr := updateUserID(userModel)
+	if idErr != nil {
+		return idErr
+	}
+	validateErr := validateUser(c.ctx, c.coll, c.fields, userModel)
+	if validateErr != nil {
+		return validateErr
+	}
+	roleErr := validateRole(c, userModel.Role)
+	if roleErr != nil {
+		return roleErr
+	}
+
+	if isBcryptHash(userModel.Password) {
+		return nil
+	}
+	hashedPass, err := hashPassword(userModel.Password)
+	if err != nil {
+		err = errors.Wrap(err, "Error creating Hash from password")
+		return model.NewError(model.InternalError, err.Error())
+	}
+	userModel.Password = hashedPass
+	return nil
+}
+
+// isBcryptHash returns true if the password is a bcrypt-hash,
+// such as from a legacy system.
+func isBcryptHash(password string) bool {
+	_, err := bcrypt.Cost([]byte(password))
+	return err == nil
+}
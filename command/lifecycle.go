@@ -0,0 +1,216 @@
+package command
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/TerrexTech/agg-userauth-cmd/auth"
+	"github.com/TerrexTech/agg-userauth-cmd/lifecycle"
+	"github.com/TerrexTech/agg-userauth-cmd/tracing"
+	"github.com/TerrexTech/agg-userauth-model/user"
+	"github.com/TerrexTech/go-common-models/model"
+	"github.com/pkg/errors"
+)
+
+// lifecycleChange is the data of DeactivateUser, ReactivateUser, RestoreUser
+// and PurgeUser commands, and of their Events.
+type lifecycleChange struct {
+	UserID    string `json:"userID,omitempty"`
+	ChangedAt int64  `json:"changedAt,omitempty"`
+	ChangedBy string `json:"changedBy,omitempty"`
+}
+
+func deactivateUser(c *cmdConfig) ([]byte, *model.Event, *model.Error) {
+	change, record, cmdErr := parseLifecycleChange(c)
+	if cmdErr != nil {
+		return nil, nil, cmdErr
+	}
+	if record != nil {
+		err := errors.Errorf("user is already %s", record.Status)
+		return nil, nil, model.NewError(model.UserError, err.Error())
+	}
+	if cmdErr = findActiveUser(c, change.UserID); cmdErr != nil {
+		return nil, nil, cmdErr
+	}
+	return lifecycleEvent(c, "UserDeactivated", change)
+}
+
+func reactivateUser(c *cmdConfig) ([]byte, *model.Event, *model.Error) {
+	change, record, cmdErr := parseLifecycleChange(c)
+	if cmdErr != nil {
+		return nil, nil, cmdErr
+	}
+	if record == nil || record.Status != lifecycle.StatusDeactivated {
+		err := errors.New("user is not deactivated")
+		return nil, nil, model.NewError(model.UserError, err.Error())
+	}
+	return lifecycleEvent(c, "UserReactivated", change)
+}
+
+// restoreUser reinstates a deleted user from its tombstone, if the user was
+// deleted within the grace-period and its UserID and UserName are not taken.
+func restoreUser(c *cmdConfig) ([]byte, *model.Event, *model.Error) {
+	change, record, cmdErr := parseLifecycleChange(c)
+	if cmdErr != nil {
+		return nil, nil, cmdErr
+	}
+	if record == nil || record.Status != lifecycle.StatusDeleted {
+		err := errors.New("user is not deleted")
+		return nil, nil, model.NewError(model.UserError, err.Error())
+	}
+	if !record.Restorable(time.Now(), c.gracePeriod) {
+		err := errors.Errorf("grace-period of %s for restoring user has passed", c.gracePeriod)
+		return nil, nil, model.NewError(model.UserError, err.Error())
+	}
+
+	// Tombstones are encrypted as the projection is
+	tombstone := *record.User
+	err := c.fields.DecryptUser(&tombstone)
+	if err != nil {
+		err = errors.Wrap(err, "Error decrypting tombstone")
+		return nil, nil, model.NewError(model.InternalError, err.Error())
+	}
+	filter, err := c.fields.Filter(map[string]interface{}{
+		"$or": []user.User{
+			user.User{
+				UserID: tombstone.UserID,
+			},
+			user.User{
+				UserName: tombstone.UserName,
+			},
+		},
+	})
+	if err != nil {
+		err = errors.Wrap(err, "Error creating filter")
+		return nil, nil, model.NewError(model.InternalError, err.Error())
+	}
+	_, span := tracing.Start(c.ctx, "mongo.FindOne")
+	_, err = c.coll.FindOne(filter)
+	span.End()
+	if err == nil {
+		err = errors.New("user with same UserID or UserName already exists")
+		return nil, nil, model.NewError(model.UserError, err.Error())
+	}
+	if !isNotFound(err) {
+		err = errors.Wrap(err, "Error finding conflicting User")
+		return nil, nil, model.NewError(model.DatabaseError, err.Error())
+	}
+	return lifecycleEvent(c, "UserRestored", change)
+}
+
+// purgeUser permanently removes an active or deleted user.
+func purgeUser(c *cmdConfig) ([]byte, *model.Event, *model.Error) {
+	change, record, cmdErr := parseLifecycleChange(c)
+	if cmdErr != nil {
+		return nil, nil, cmdErr
+	}
+	if record == nil || record.Status != lifecycle.StatusDeleted {
+		if cmdErr = findActiveUser(c, change.UserID); cmdErr != nil {
+			return nil, nil, cmdErr
+		}
+	}
+	return lifecycleEvent(c, "UserPurged", change)
+}
+
+// parseLifecycleChange validates the command-data, and returns the user's
+// lifecycle-record, which is nil if the user is active.
+func parseLifecycleChange(
+	c *cmdConfig,
+) (*lifecycleChange, *lifecycle.Record, *model.Error) {
+	if c.lifecycle == nil {
+		err := errors.New("soft-delete is not enabled")
+		return nil, nil, model.NewError(model.UserError, err.Error())
+	}
+
+	change := &lifecycleChange{}
+	err := json.Unmarshal(c.cmd.Data, change)
+	if err != nil {
+		err = errors.Wrap(err, "Error unmarshalling command-data")
+		return nil, nil, model.NewError(model.InternalError, err.Error())
+	}
+	if change.UserID == "" {
+		err = errors.New("missing UserID")
+		return nil, nil, model.NewError(model.UserError, err.Error())
+	}
+	change.ChangedAt = time.Now().Unix()
+	change.ChangedBy = actorID(c)
+
+	_, span := tracing.Start(c.ctx, "mongo.FindLifecycleRecord")
+	record, err := c.lifecycle.Record(change.UserID)
+	tracing.End(span, err)
+	if err != nil {
+		err = errors.Wrap(err, "Error finding lifecycle-record")
+		return nil, nil, model.NewError(model.DatabaseError, err.Error())
+	}
+	return change, record, nil
+}
+
+func findActiveUser(c *cmdConfig, userID string) *model.Error {
+	_, span := tracing.Start(c.ctx, "mongo.FindOne")
+	_, err := c.coll.FindOne(&user.User{
+		UserID: userID,
+	})
+	tracing.End(span, err)
+	if isNotFound(err) {
+		err = errors.New("user not found")
+		return model.NewError(model.UserError, err.Error())
+	}
+	if err != nil {
+		err = errors.Wrap(err, "Error finding User")
+		return model.NewError(model.DatabaseError, err.Error())
+	}
+	return nil
+}
+
+// rejectDeactivated returns an error if the user is deactivated, so it is
+// not changed until reactivated.
+func rejectDeactivated(c *cmdConfig, userID string) *model.Error {
+	if c.lifecycle == nil {
+		return nil
+	}
+	_, span := tracing.Start(c.ctx, "mongo.FindLifecycleRecord")
+	record, err := c.lifecycle.Record(userID)
+	tracing.End(span, err)
+	if err != nil {
+		err = errors.Wrap(err, "Error finding lifecycle-record")
+		return model.NewError(model.DatabaseError, err.Error())
+	}
+	if record != nil && record.Status == lifecycle.StatusDeactivated {
+		err = errors.New("user is deactivated")
+		return model.NewError(model.UserError, err.Error())
+	}
+	return nil
+}
+
+func lifecycleEvent(
+	c *cmdConfig,
+	action string,
+	change *lifecycleChange,
+) ([]byte, *model.Event, *model.Error) {
+	data, err := json.Marshal(change)
+	if err != nil {
+		err = errors.Wrap(err, "Error marshalling lifecycle-change")
+		return nil, nil, model.NewError(model.InternalError, err.Error())
+	}
+	event, cmdErr := newEvent(c, action, data)
+	if cmdErr != nil {
+		return nil, nil, cmdErr
+	}
+	return data, event, nil
+}
+
+// actorID returns the UserID of the actor issuing the command, which is
+// recorded on tombstones and deactivations. Commands from unidentified
+// actors are attributed to their Source.
+func actorID(c *cmdConfig) string {
+	if c.auth != nil {
+		actor, err := c.auth.Authenticate(c.ctx)
+		if err == nil {
+			return actor.UserID
+		}
+	}
+	if userUUID := auth.UserUUIDFromContext(c.ctx); userUUID != "" {
+		return userUUID
+	}
+	return c.cmd.Source
+}
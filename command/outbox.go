@@ -0,0 +1,314 @@
+package command
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/TerrexTech/agg-userauth-cmd/util"
+	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/TerrexTech/uuuid"
+	"github.com/pkg/errors"
+)
+
+// OutboxEntry is the Events of a Command and its Response, recorded together
+// so they can be emitted by the outbox-relay. Events and Response are stored
+// as marshalled JSON, exactly as they are produced. All Events of a Command
+// are in one entry, so they are recorded atomically, and the Response is only
+// sent once all of them are.
+type OutboxEntry struct {
+	EntryID       string         `bson:"entryID,omitempty" json:"entryID,omitempty"`
+	NanoTime      int64          `bson:"nanoTime,omitempty" json:"nanoTime,omitempty"`
+	Events        []*OutboxEvent `bson:"events,omitempty" json:"events,omitempty"`
+	EventSent     bool           `bson:"eventSent" json:"eventSent"`
+	Response      []byte         `bson:"response,omitempty" json:"response,omitempty"`
+	ResponseTopic string         `bson:"responseTopic,omitempty" json:"responseTopic,omitempty"`
+	Sent          bool           `bson:"sent" json:"sent"`
+	SentAt        int64          `bson:"sentAt,omitempty" json:"sentAt,omitempty"`
+	Attempts      int            `bson:"attempts" json:"attempts"`
+	LastError     string         `bson:"lastError,omitempty" json:"lastError,omitempty"`
+	// DeadLettered entries failed too often, and are kept unsent for
+	// inspection instead of holding up later entries.
+	DeadLettered bool `bson:"deadLettered" json:"deadLettered"`
+	// ClaimedUntil is when the claim of the relay publishing the entry
+	// expires, as Unix-nanoseconds.
+	ClaimedUntil int64 `bson:"claimedUntil" json:"claimedUntil"`
+
+	RespHeaders map[string]string `bson:"respHeaders,omitempty" json:"respHeaders,omitempty"`
+}
+
+// OutboxEvent is an Event of an OutboxEntry, with its message-key and headers.
+type OutboxEvent struct {
+	Event   []byte            `bson:"event,omitempty" json:"event,omitempty"`
+	Key     string            `bson:"key,omitempty" json:"key,omitempty"`
+	Headers map[string]string `bson:"headers,omitempty" json:"headers,omitempty"`
+}
+
+// Outbox records Events and Responses in a Mongo collection, from where
+// they are published in order by the outbox-relay.
+type Outbox struct {
+	coll *mongo.Collection
+}
+
+// NewOutbox creates the outbox-collection (if required) and returns an Outbox
+// backed by it.
+func NewOutbox(
+	conn *mongo.ConnectionConfig,
+	database string,
+	collection string,
+) (*Outbox, error) {
+	if conn == nil {
+		return nil, errors.New("conn cannot be nil")
+	}
+	if database == "" {
+		return nil, errors.New("database cannot be blank")
+	}
+	if collection == "" {
+		return nil, errors.New("collection cannot be blank")
+	}
+
+	indexConfigs := []mongo.IndexConfig{
+		mongo.IndexConfig{
+			ColumnConfig: []mongo.IndexColumnConfig{
+				mongo.IndexColumnConfig{
+					Name: "entryID",
+				},
+			},
+			IsUnique: true,
+			Name:     "entryID_index",
+		},
+		mongo.IndexConfig{
+			ColumnConfig: []mongo.IndexColumnConfig{
+				mongo.IndexColumnConfig{
+					Name: "sent",
+				},
+				mongo.IndexColumnConfig{
+					Name: "nanoTime",
+				},
+			},
+			Name: "sent_nanoTime_index",
+		},
+	}
+	coll, err := util.EnsureCollection(&mongo.Collection{
+		Connection:   conn,
+		Database:     database,
+		Name:         collection,
+		SchemaStruct: &OutboxEntry{},
+		Indexes:      indexConfigs,
+	})
+	if err != nil {
+		err = errors.Wrap(err, "Error creating Outbox-collection")
+		return nil, err
+	}
+
+	return &Outbox{
+		coll: coll,
+	}, nil
+}
+
+// Add records the Events (none for failed commands) and their Response in
+// one outbox-entry, so either all of them are recorded or none are. The
+// Events are published in order, and the Response once all of them are.
+func (o *Outbox) Add(eventMsgs []*EventMsg, respMsg *ResponseMsg) error {
+	if respMsg == nil || respMsg.Document == nil {
+		return errors.New("respMsg cannot be nil")
+	}
+	resp := respMsg.Document
+
+	entry, err := newOutboxEntry(time.Now().UnixNano())
+	if err != nil {
+		return err
+	}
+	for _, eventMsg := range eventMsgs {
+		if eventMsg == nil || eventMsg.Event == nil {
+			continue
+		}
+		marshalEvent, err := json.Marshal(eventMsg.Event)
+		if err != nil {
+			err = errors.Wrap(err, "Error marshalling Event")
+			return err
+		}
+		entry.Events = append(entry.Events, &OutboxEvent{
+			Event:   marshalEvent,
+			Key:     eventMsg.Key,
+			Headers: eventMsg.Headers,
+		})
+	}
+	// Nothing to publish, so the Events count as sent
+	entry.EventSent = len(entry.Events) == 0
+
+	entry.ResponseTopic = resp.Topic
+	entry.RespHeaders = respMsg.Headers
+	entry.Response, err = json.Marshal(resp)
+	if err != nil {
+		err = errors.Wrap(err, "Error marshalling Response")
+		return err
+	}
+
+	_, err = o.coll.InsertOne(entry)
+	if err != nil {
+		err = errors.Wrap(err, "Error inserting Outbox-entry")
+		return err
+	}
+	return nil
+}
+
+func newOutboxEntry(nanoTime int64) (*OutboxEntry, error) {
+	entryID, err := uuuid.NewV4()
+	if err != nil {
+		err = errors.Wrap(err, "Error generating EntryID")
+		return nil, err
+	}
+	return &OutboxEntry{
+		EntryID:  entryID.String(),
+		NanoTime: nanoTime,
+	}, nil
+}
+
+// Pending returns the entries that are yet to be sent, oldest first.
+// Dead-lettered entries are not included.
+func (o *Outbox) Pending() ([]*OutboxEntry, error) {
+	results, err := o.coll.Find(map[string]interface{}{
+		"sent": false,
+		"deadLettered": map[string]interface{}{
+			"$ne": true,
+		},
+	})
+	if err != nil {
+		err = errors.Wrap(err, "Error finding pending Outbox-entries")
+		return nil, err
+	}
+
+	entries := make([]*OutboxEntry, 0, len(results))
+	for _, r := range results {
+		entry, assertOK := r.(*OutboxEntry)
+		if !assertOK {
+			err = errors.New("error asserting find-result to OutboxEntry")
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].NanoTime < entries[j].NanoTime
+	})
+	return entries, nil
+}
+
+// Claim claims the pending entry for the lease-duration, so no other relay
+// publishes it meanwhile. It returns false if the entry was sent, or is
+// claimed by another relay whose claim has not expired.
+func (o *Outbox) Claim(entryID string, lease time.Duration) (bool, error) {
+	if entryID == "" {
+		return false, errors.New("entryID cannot be blank")
+	}
+	now := time.Now()
+	// Updates of single documents are atomic, so only one relay can
+	// claim an entry whose previous claim expired.
+	result, err := o.coll.UpdateMany(
+		map[string]interface{}{
+			"entryID": entryID,
+			"sent":    false,
+			"deadLettered": map[string]interface{}{
+				"$ne": true,
+			},
+			"$or": []map[string]interface{}{
+				{
+					"claimedUntil": map[string]interface{}{
+						"$lt": now.UnixNano(),
+					},
+				},
+				{
+					"claimedUntil": map[string]interface{}{
+						"$exists": false,
+					},
+				},
+			},
+		},
+		map[string]interface{}{
+			"claimedUntil": now.Add(lease).UnixNano(),
+		},
+	)
+	if err != nil {
+		err = errors.Wrap(err, "Error claiming Outbox-entry")
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// MarkEventSent records that the entry's Events were published, so retries
+// only publish the remaining Response.
+func (o *Outbox) MarkEventSent(entryID string) error {
+	return o.update(entryID, map[string]interface{}{
+		"eventSent": true,
+	})
+}
+
+// MarkSent records that the entry was completely published.
+func (o *Outbox) MarkSent(entryID string) error {
+	return o.update(entryID, map[string]interface{}{
+		"eventSent": true,
+		"sent":      true,
+		"sentAt":    time.Now().UnixNano(),
+	})
+}
+
+// MarkFailed records a failed publish-attempt for the entry, and releases
+// its claim. The entry stays pending and will be retried.
+func (o *Outbox) MarkFailed(entry *OutboxEntry, pubErr error) error {
+	return o.update(entry.EntryID, map[string]interface{}{
+		"attempts":     entry.Attempts + 1,
+		"lastError":    errorMessage(pubErr),
+		"claimedUntil": 0,
+	})
+}
+
+// DeadLetter records the last failed publish-attempt for the entry, and
+// sets it aside so later entries are published. Dead-lettered entries are
+// kept until removed manually.
+func (o *Outbox) DeadLetter(entry *OutboxEntry, pubErr error) error {
+	return o.update(entry.EntryID, map[string]interface{}{
+		"attempts":     entry.Attempts + 1,
+		"lastError":    errorMessage(pubErr),
+		"deadLettered": true,
+		"claimedUntil": 0,
+	})
+}
+
+// Prune removes the entries that were sent before the time.
+func (o *Outbox) Prune(before time.Time) (int64, error) {
+	result, err := o.coll.DeleteMany(map[string]interface{}{
+		"sent": true,
+		"sentAt": map[string]interface{}{
+			"$lt": before.UnixNano(),
+		},
+	})
+	if err != nil {
+		err = errors.Wrap(err, "Error pruning sent Outbox-entries")
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+func errorMessage(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func (o *Outbox) update(entryID string, update map[string]interface{}) error {
+	if entryID == "" {
+		return errors.New("entryID cannot be blank")
+	}
+	_, err := o.coll.UpdateMany(
+		map[string]interface{}{
+			"entryID": entryID,
+		},
+		update,
+	)
+	if err != nil {
+		err = errors.Wrap(err, "Error updating Outbox-entry")
+		return err
+	}
+	return nil
+}
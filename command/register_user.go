@@ -1,9 +1,13 @@
 package command
 
 import (
+	"context"
 	"encoding/json"
 	"time"
 
+	"github.com/TerrexTech/agg-userauth-cmd/fieldcrypt"
+	"github.com/TerrexTech/agg-userauth-cmd/metrics"
+	"github.com/TerrexTech/agg-userauth-cmd/tracing"
 	"github.com/TerrexTech/agg-userauth-model/user"
 	"github.com/TerrexTech/go-common-models/model"
 	"github.com/TerrexTech/go-mongoutils/mongo"
@@ -24,19 +28,34 @@ func registerUser(c *cmdConfig) ([]byte, *model.Event, *model.Error) {
 	if idErr != nil {
 		return nil, nil, idErr
 	}
-	validateErr := validateUser(c.coll, userModel)
+	validateErr := validateUser(c.ctx, c.coll, c.fields, userModel)
 	if validateErr != nil {
 		return nil, nil, validateErr
 	}
+	roleErr := validateRole(c, userModel.Role)
+	if roleErr != nil {
+		return nil, nil, roleErr
+	}
 
-	hashedPass, err := bcrypt.GenerateFromPassword([]byte(userModel.Password), 10)
+	hashedPass, err := hashPassword(userModel.Password)
 	if err != nil {
 		err = errors.Wrap(err, "Error creating Hash from password")
 		return nil, nil, model.NewError(model.InternalError, err.Error())
 	}
-	userModel.Password = string(hashedPass)
 
-	cmdData, err := json.Marshal(userModel)
+	// The password-hash is set by the CredentialSet Event, which follows
+	// the UserRegistered Event with the user's profile.
+	userProfile := profile(userModel)
+	cmdData, err := json.Marshal(userProfile)
+	if err != nil {
+		err = errors.Wrap(err, "Error marshalling User")
+		return nil, nil, model.NewError(model.InternalError, err.Error())
+	}
+	eventUser, cmdErr := encryptUser(c, userProfile)
+	if cmdErr != nil {
+		return nil, nil, cmdErr
+	}
+	eventData, err := json.Marshal(eventUser)
 	if err != nil {
 		err = errors.Wrap(err, "Error marshalling User")
 		return nil, nil, model.NewError(model.InternalError, err.Error())
@@ -51,16 +70,52 @@ func registerUser(c *cmdConfig) ([]byte, *model.Event, *model.Error) {
 		Action:        "UserRegistered",
 		AggregateID:   user.AggregateID,
 		CorrelationID: c.cmd.UUID,
-		Data:          cmdData,
+		Data:          eventData,
 		NanoTime:      time.Now().UnixNano(),
 		Source:        c.serviceName,
 		UUID:          eventID,
 		YearBucket:    2018,
 	}
 
+	credEvent, cmdErr := credentialEvent(c, "CredentialSet", userModel.UserID, hashedPass)
+	if cmdErr != nil {
+		return nil, nil, cmdErr
+	}
+	c.followUps = append(c.followUps, credEvent)
+
 	return cmdData, event, nil
 }
 
+// validateRole checks that the role is in the role-catalogue,
+// if role-based access control is enabled.
+func validateRole(c *cmdConfig, roleName string) *model.Error {
+	if c.roles == nil {
+		return nil
+	}
+	_, span := tracing.Start(c.ctx, "mongo.FindRole")
+	role, err := c.roles.Role(roleName)
+	tracing.End(span, err)
+	if err != nil {
+		err = errors.Wrap(err, "Error finding role")
+		return model.NewError(model.DatabaseError, err.Error())
+	}
+	if role == nil {
+		err = errors.Errorf("role %s is not defined", roleName)
+		return model.NewError(model.UserError, err.Error())
+	}
+	return nil
+}
+
+func hashPassword(password string) (string, error) {
+	hashStart := time.Now()
+	hashedPass, err := bcrypt.GenerateFromPassword([]byte(password), 10)
+	metrics.PasswordHashDuration.Observe(time.Since(hashStart).Seconds())
+	if err != nil {
+		return "", err
+	}
+	return string(hashedPass), nil
+}
+
 func updateUserID(userModel *user.User) (*user.User, *model.Error) {
 	var userID uuuid.UUID
 	var err error
@@ -85,7 +140,12 @@ func updateUserID(userModel *user.User) (*user.User, *model.Error) {
 	return userModel, nil
 }
 
-func validateUser(coll *mongo.Collection, userModel *user.User) *model.Error {
+func validateUser(
+	ctx context.Context,
+	coll *mongo.Collection,
+	fields *fieldcrypt.Codec,
+	userModel *user.User,
+) *model.Error {
 	if userModel.FirstName == "" {
 		err := errors.New("missing FirstName for user")
 		return model.NewError(model.UserError, err.Error())
@@ -107,7 +167,7 @@ func validateUser(coll *mongo.Collection, userModel *user.User) *model.Error {
 		return model.NewError(model.UserError, err.Error())
 	}
 
-	_, err := coll.FindOne(map[string]interface{}{
+	filter, err := fields.Filter(map[string]interface{}{
 		"$or": []user.User{
 			user.User{
 				UserID: userModel.UserID,
@@ -117,6 +177,16 @@ func validateUser(coll *mongo.Collection, userModel *user.User) *model.Error {
 			},
 		},
 	})
+	if err != nil {
+		err = errors.Wrap(err, "Error creating filter")
+		return model.NewError(model.InternalError, err.Error())
+	}
+
+	// Not finding a user is the expected result here, so
+	// the error is not recorded on the span.
+	_, span := tracing.Start(ctx, "mongo.FindOne")
+	defer span.End()
+	_, err = coll.FindOne(filter)
 	if err == nil {
 		err = errors.New("user already exists")
 		return model.NewError(model.UserError, err.Error())
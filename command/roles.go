@@ -0,0 +1,180 @@
+package command
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/TerrexTech/agg-userauth-cmd/rbac"
+	"github.com/TerrexTech/agg-userauth-cmd/tracing"
+	"github.com/TerrexTech/agg-userauth-model/user"
+	"github.com/TerrexTech/go-common-models/model"
+	"github.com/TerrexTech/uuuid"
+	mgo "github.com/mongodb/mongo-go-driver/mongo"
+	"github.com/pkg/errors"
+)
+
+// roleAssignment is the data of AssignRole and RevokeRole commands,
+// and of their Events.
+type roleAssignment struct {
+	UserID string `json:"userID,omitempty"`
+	Role   string `json:"role,omitempty"`
+}
+
+func defineRole(c *cmdConfig) ([]byte, *model.Event, *model.Error) {
+	if c.roles == nil {
+		err := errors.New("role-based access control is not enabled")
+		return nil, nil, model.NewError(model.UserError, err.Error())
+	}
+
+	role := &rbac.Role{}
+	err := json.Unmarshal(c.cmd.Data, role)
+	if err != nil {
+		err = errors.Wrap(err, "Error unmarshalling command-data into Role")
+		return nil, nil, model.NewError(model.InternalError, err.Error())
+	}
+	err = role.Validate()
+	if err != nil {
+		return nil, nil, model.NewError(model.UserError, err.Error())
+	}
+	if c.roles.IsConfigured(role.Name) {
+		err = errors.Errorf("role %s is defined in config, and cannot be redefined", role.Name)
+		return nil, nil, model.NewError(model.UserError, err.Error())
+	}
+
+	roleData, err := json.Marshal(role)
+	if err != nil {
+		err = errors.Wrap(err, "Error marshalling Role")
+		return nil, nil, model.NewError(model.InternalError, err.Error())
+	}
+	event, cmdErr := newEvent(c, "RoleDefined", roleData)
+	if cmdErr != nil {
+		return nil, nil, cmdErr
+	}
+	return roleData, event, nil
+}
+
+func assignRole(c *cmdConfig) ([]byte, *model.Event, *model.Error) {
+	assignment, roles, cmdErr := parseRoleAssignment(c)
+	if cmdErr != nil {
+		return nil, nil, cmdErr
+	}
+
+	_, span := tracing.Start(c.ctx, "mongo.FindRole")
+	role, err := c.roles.Role(assignment.Role)
+	tracing.End(span, err)
+	if err != nil {
+		err = errors.Wrap(err, "Error finding role")
+		return nil, nil, model.NewError(model.DatabaseError, err.Error())
+	}
+	if role == nil {
+		err = errors.Errorf("role %s is not defined", assignment.Role)
+		return nil, nil, model.NewError(model.UserError, err.Error())
+	}
+	if rbac.HasRole(roles, assignment.Role) {
+		err = errors.Errorf("user already has role %s", assignment.Role)
+		return nil, nil, model.NewError(model.UserError, err.Error())
+	}
+
+	return roleAssignmentEvent(c, "RoleAssigned", assignment)
+}
+
+func revokeRole(c *cmdConfig) ([]byte, *model.Event, *model.Error) {
+	assignment, roles, cmdErr := parseRoleAssignment(c)
+	if cmdErr != nil {
+		return nil, nil, cmdErr
+	}
+	if !rbac.HasRole(roles, assignment.Role) {
+		err := errors.Errorf("user does not have role %s", assignment.Role)
+		return nil, nil, model.NewError(model.UserError, err.Error())
+	}
+
+	return roleAssignmentEvent(c, "RoleRevoked", assignment)
+}
+
+// parseRoleAssignment validates the command-data of AssignRole and
+// RevokeRole, and returns the user's current roles.
+func parseRoleAssignment(c *cmdConfig) (*roleAssignment, []string, *model.Error) {
+	if c.roles == nil {
+		err := errors.New("role-based access control is not enabled")
+		return nil, nil, model.NewError(model.UserError, err.Error())
+	}
+
+	assignment := &roleAssignment{}
+	err := json.Unmarshal(c.cmd.Data, assignment)
+	if err != nil {
+		err = errors.Wrap(err, "Error unmarshalling command-data")
+		return nil, nil, model.NewError(model.InternalError, err.Error())
+	}
+	if assignment.UserID == "" {
+		err = errors.New("missing UserID")
+		return nil, nil, model.NewError(model.UserError, err.Error())
+	}
+	if assignment.Role == "" {
+		err = errors.New("missing Role")
+		return nil, nil, model.NewError(model.UserError, err.Error())
+	}
+
+	_, span := tracing.Start(c.ctx, "mongo.FindOne")
+	_, err = c.coll.FindOne(&user.User{
+		UserID: assignment.UserID,
+	})
+	tracing.End(span, err)
+	if isNotFound(err) {
+		err = errors.New("user not found")
+		return nil, nil, model.NewError(model.UserError, err.Error())
+	}
+	if err != nil {
+		err = errors.Wrap(err, "Error finding User")
+		return nil, nil, model.NewError(model.DatabaseError, err.Error())
+	}
+
+	_, span = tracing.Start(c.ctx, "mongo.FindUserRoles")
+	roles, err := c.roles.UserRoles(assignment.UserID)
+	tracing.End(span, err)
+	if err != nil {
+		err = errors.Wrap(err, "Error finding user-roles")
+		return nil, nil, model.NewError(model.DatabaseError, err.Error())
+	}
+	return assignment, roles, nil
+}
+
+func roleAssignmentEvent(
+	c *cmdConfig,
+	action string,
+	assignment *roleAssignment,
+) ([]byte, *model.Event, *model.Error) {
+	data, err := json.Marshal(assignment)
+	if err != nil {
+		err = errors.Wrap(err, "Error marshalling role-assignment")
+		return nil, nil, model.NewError(model.InternalError, err.Error())
+	}
+	event, cmdErr := newEvent(c, action, data)
+	if cmdErr != nil {
+		return nil, nil, cmdErr
+	}
+	return data, event, nil
+}
+
+// isNotFound returns whether the error is from FindOne matching no document.
+func isNotFound(err error) bool {
+	return err != nil && errors.Cause(err) == mgo.ErrNoDocuments
+}
+
+// newEvent creates the Event resulting from the command.
+func newEvent(c *cmdConfig, action string, data []byte) (*model.Event, *model.Error) {
+	eventID, err := uuuid.NewV4()
+	if err != nil {
+		err = errors.Wrap(err, "Error generating EventID")
+		return nil, model.NewError(model.InternalError, err.Error())
+	}
+	return &model.Event{
+		Action:        action,
+		AggregateID:   user.AggregateID,
+		CorrelationID: c.cmd.UUID,
+		Data:          data,
+		NanoTime:      time.Now().UnixNano(),
+		Source:        c.serviceName,
+		UUID:          eventID,
+		YearBucket:    2018,
+	}, nil
+}
@@ -0,0 +1,111 @@
+package command
+
+import (
+	"encoding/json"
+
+	"github.com/TerrexTech/agg-userauth-cmd/tracing"
+	"github.com/TerrexTech/agg-userauth-model/user"
+	"github.com/TerrexTech/go-common-models/model"
+	"github.com/pkg/errors"
+)
+
+// rotationResult is the result of RotateFieldKeys-commands.
+type rotationResult struct {
+	Checked   int `json:"checked"`
+	Rotated   int `json:"rotated"`
+	Remaining int `json:"remaining"`
+}
+
+// rotateFieldKeys re-encrypts the fields of users which are not encrypted
+// with the active key, such as after a new key is added, or after
+// field-level encryption is enabled. The command-data can limit the number
+// of users re-encrypted, so large collections are rotated in steps.
+// It produces no Event, since the Event-data is not encrypted by the keys.
+func rotateFieldKeys(c *cmdConfig) ([]byte, *model.Event, *model.Error) {
+	if c.fields == nil {
+		err := errors.New("field-level encryption is not enabled")
+		return nil, nil, model.NewError(model.UserError, err.Error())
+	}
+	params := &struct {
+		Limit int `json:"limit"`
+	}{}
+	if len(c.cmd.Data) > 0 {
+		err := json.Unmarshal(c.cmd.Data, params)
+		if err != nil {
+			err = errors.Wrap(err, "Error unmarshalling command-data")
+			return nil, nil, model.NewError(model.InternalError, err.Error())
+		}
+	}
+
+	_, span := tracing.Start(c.ctx, "mongo.Find")
+	matches, err := c.coll.Find(map[string]interface{}{})
+	tracing.End(span, err)
+	if err != nil {
+		err = errors.Wrap(err, "Error finding users")
+		return nil, nil, model.NewError(model.DatabaseError, err.Error())
+	}
+
+	result := &rotationResult{}
+	for _, match := range matches {
+		stored, assertOK := match.(*user.User)
+		if !assertOK {
+			err = errors.New("error asserting find-result to User")
+			return nil, nil, model.NewError(model.InternalError, err.Error())
+		}
+		result.Checked++
+		if !c.fields.Stale(stored) {
+			continue
+		}
+		if params.Limit > 0 && result.Rotated >= params.Limit {
+			result.Remaining++
+			continue
+		}
+
+		cmdErr := rotateUser(c, stored)
+		if cmdErr != nil {
+			return nil, nil, cmdErr
+		}
+		result.Rotated++
+	}
+
+	resultData, err := json.Marshal(result)
+	if err != nil {
+		err = errors.Wrap(err, "Error marshalling result")
+		return nil, nil, model.NewError(model.InternalError, err.Error())
+	}
+	return resultData, nil, nil
+}
+
+// rotateUser re-encrypts the fields of the stored user with the active key.
+func rotateUser(c *cmdConfig, stored *user.User) *model.Error {
+	err := c.fields.DecryptUser(stored)
+	if err != nil {
+		err = errors.Wrapf(err, "Error decrypting user %s", stored.UserID)
+		return model.NewError(model.InternalError, err.Error())
+	}
+	plainMap, err := userToMap(stored)
+	if err != nil {
+		err = errors.Wrap(err, "Error getting user-map")
+		return model.NewError(model.InternalError, err.Error())
+	}
+	update, err := c.fields.EncryptUpdate(plainMap)
+	if err != nil {
+		err = errors.Wrapf(err, "Error encrypting user %s", stored.UserID)
+		return model.NewError(model.InternalError, err.Error())
+	}
+	delete(update, "userID")
+
+	_, span := tracing.Start(c.ctx, "mongo.UpdateMany")
+	_, err = c.coll.UpdateMany(
+		map[string]interface{}{
+			"userID": stored.UserID,
+		},
+		update,
+	)
+	tracing.End(span, err)
+	if err != nil {
+		err = errors.Wrapf(err, "Error updating user %s", stored.UserID)
+		return model.NewError(model.DatabaseError, err.Error())
+	}
+	return nil
+}
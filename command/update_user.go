@@ -2,11 +2,11 @@ package command
 
 import (
 	"encoding/json"
-	"time"
 
+	"github.com/TerrexTech/agg-userauth-cmd/shred"
+	"github.com/TerrexTech/agg-userauth-cmd/tracing"
 	"github.com/TerrexTech/agg-userauth-model/user"
 	"github.com/TerrexTech/go-common-models/model"
-	"github.com/TerrexTech/uuuid"
 	"github.com/pkg/errors"
 )
 
@@ -24,11 +24,17 @@ func updateUser(c *cmdConfig) ([]byte, *model.Event, *model.Error) {
 	}
 
 	validateErr := validateParams(params)
-	if err != nil {
+	if validateErr != nil {
 		return nil, nil, validateErr
 	}
 
-	match, err := c.coll.FindOne(params.Filter)
+	filter, err := c.fields.Filter(params.Filter)
+	if err != nil {
+		return nil, nil, model.NewError(model.UserError, err.Error())
+	}
+	_, span := tracing.Start(c.ctx, "mongo.FindOne")
+	match, err := c.coll.FindOne(filter)
+	tracing.End(span, err)
 	if err != nil {
 		err = errors.Wrap(err, "Error finding User")
 		return nil, nil, model.NewError(model.UserError, err.Error())
@@ -38,6 +44,15 @@ func updateUser(c *cmdConfig) ([]byte, *model.Event, *model.Error) {
 		err = errors.New("error asserting find-result to user-map")
 		return nil, nil, model.NewError(model.InternalError, err.Error())
 	}
+	cmdErr := rejectDeactivated(c, matchedUser.UserID)
+	if cmdErr != nil {
+		return nil, nil, cmdErr
+	}
+	err = c.fields.DecryptUser(matchedUser)
+	if err != nil {
+		err = errors.Wrap(err, "Error decrypting User")
+		return nil, nil, model.NewError(model.InternalError, err.Error())
+	}
 
 	userMap, err := userToMap(matchedUser)
 	if err != nil {
@@ -50,6 +65,9 @@ func updateUser(c *cmdConfig) ([]byte, *model.Event, *model.Error) {
 		err = errors.Wrap(err, "Error patching user")
 		return nil, nil, model.NewError(model.InternalError, err.Error())
 	}
+	// Passwords are not part of the profile, and are changed by the
+	// CredentialChanged Event instead.
+	delete(updatedUser, "password")
 
 	updateResult := map[string]interface{}{
 		"filter": params.Filter,
@@ -60,26 +78,81 @@ func updateUser(c *cmdConfig) ([]byte, *model.Event, *model.Error) {
 		err = errors.Wrap(err, "Error marshalling result")
 		return nil, nil, model.NewError(model.InternalError, err.Error())
 	}
-
-	uuid, err := uuuid.NewV4()
-	if err != nil {
-		err = errors.Wrap(err, "Error generating Event-UUID")
-		return nil, nil, model.NewError(model.InternalError, err.Error())
-	}
-	event := &model.Event{
-		Action:        "UserUpdated",
-		AggregateID:   user.AggregateID,
-		CorrelationID: c.cmd.UUID,
-		Data:          marshalResult,
-		NanoTime:      time.Now().UnixNano(),
-		Source:        c.serviceName,
-		UUID:          uuid,
-		YearBucket:    2018,
+	// Updates of only the password produce no UserUpdated Event
+	var event *model.Event
+	if *profile(params.Update) != (user.User{}) {
+		eventData, cmdErr := updateEventData(c, matchedUser.UserID, updateResult, updatedUser)
+		if cmdErr != nil {
+			return nil, nil, cmdErr
+		}
+		event, cmdErr = newEvent(c, "UserUpdated", eventData)
+		if cmdErr != nil {
+			return nil, nil, cmdErr
+		}
+	}
+
+	if params.Update.Password != "" {
+		hashedPass, err := hashPassword(params.Update.Password)
+		if err != nil {
+			err = errors.Wrap(err, "Error creating Hash from password")
+			return nil, nil, model.NewError(model.InternalError, err.Error())
+		}
+		credEvent, cmdErr := credentialEvent(
+			c, "CredentialChanged", matchedUser.UserID, hashedPass,
+		)
+		if cmdErr != nil {
+			return nil, nil, cmdErr
+		}
+		if event == nil {
+			event = credEvent
+		} else {
+			c.followUps = append(c.followUps, credEvent)
+		}
 	}
 
 	return marshalResult, event, nil
 }
 
+// updateEventData returns the data of the UserUpdated Event. If erasure is
+// enabled, the personal data in the update is encrypted, and the filter is
+// replaced by the UserID so it holds no personal data.
+func updateEventData(
+	c *cmdConfig,
+	userID string,
+	updateResult map[string]interface{},
+	updatedUser map[string]interface{},
+) ([]byte, *model.Error) {
+	eventResult := updateResult
+	if c.keys != nil {
+		key, cmdErr := userKey(c, userID)
+		if cmdErr != nil {
+			return nil, cmdErr
+		}
+		update := map[string]interface{}{}
+		for k, v := range updatedUser {
+			update[k] = v
+		}
+		err := shred.EncryptMap(key, update)
+		if err != nil {
+			err = errors.Wrap(err, "Error encrypting update")
+			return nil, model.NewError(model.InternalError, err.Error())
+		}
+		eventResult = map[string]interface{}{
+			"filter": map[string]interface{}{
+				"userID": userID,
+			},
+			"update": update,
+		}
+	}
+
+	eventData, err := json.Marshal(eventResult)
+	if err != nil {
+		err = errors.Wrap(err, "Error marshalling Event-data")
+		return nil, model.NewError(model.InternalError, err.Error())
+	}
+	return eventData, nil
+}
+
 func userToMap(u *user.User) (map[string]interface{}, error) {
 	marshalUser, err := json.Marshal(u)
 	if err != nil {
@@ -139,8 +212,12 @@ func validateParams(params *updateParams) *model.Error {
 		err := errors.New("username cannot be changed")
 		return model.NewError(model.UserError, err.Error())
 	}
-	if update.Password == "" {
-		err := errors.New("found blank password")
+	if *update == (user.User{}) {
+		err := errors.New("empty update provided")
+		return model.NewError(model.UserError, err.Error())
+	}
+	if filter.Password != "" {
+		err := errors.New("password cannot be used in filter")
 		return model.NewError(model.UserError, err.Error())
 	}
 
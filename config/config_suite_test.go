@@ -0,0 +1,283 @@
+package config
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// TestConfig tests loading the Config.
+func TestConfig(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Config Suite")
+}
+
+// requiredEnv sets all required settings.
+var requiredEnv = map[string]string{
+	"SERVICE_NAME":                 "agg-userauth-cmd",
+	"KAFKA_BROKERS":                "kafka1:9092, kafka2:9092",
+	"KAFKA_CONSUMER_GROUP_REQUEST": "agg.userauth.request.group.1",
+	"KAFKA_CONSUMER_TOPIC_REQUEST": "agg.userauth.request",
+	"KAFKA_CONSUMER_TOPIC_ESRESP":  "esquery.response",
+	"KAFKA_PRODUCER_TOPIC_ESREQ":   "esquery.request",
+	"KAFKA_PRODUCER_TOPIC_EVENTS":  "event.rns_eventstore.events",
+	"KAFKA_END_OF_STREAM_TOKEN":    "__eos__",
+	"MONGO_HOSTS":                  "mongo:27017",
+	"MONGO_USERNAME":               "root",
+	"MONGO_PASSWORD":               "secret-password",
+	"MONGO_DATABASE":               "rns_projections",
+	"MONGO_AGG_COLLECTION":         "agg_userauth_cmd",
+	"MONGO_META_COLLECTION":        "aggregate_meta",
+}
+
+func writeFile(dir string, name string, content string) string {
+	path := filepath.Join(dir, name)
+	err := ioutil.WriteFile(path, []byte(content), 0644)
+	Expect(err).ToNot(HaveOccurred())
+	return path
+}
+
+var _ = Describe("Config", func() {
+	var dir string
+
+	BeforeEach(func() {
+		for _, s := range settings(&Config{}) {
+			os.Unsetenv(s.env)
+			os.Unsetenv(s.env + fileSuffix)
+		}
+		os.Unsetenv(EnvConfigFile)
+		for k, v := range requiredEnv {
+			os.Setenv(k, v)
+		}
+
+		var err error
+		dir, err = ioutil.TempDir("", "config-test")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("should load env-vars and apply defaults", func() {
+		cfg, err := Load(nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(cfg.ServiceName).To(Equal("agg-userauth-cmd"))
+		Expect(cfg.Kafka.Brokers).To(Equal([]string{"kafka1:9092", "kafka2:9092"}))
+		Expect(cfg.Mongo.ConnectionTimeoutMs).To(Equal(3000))
+		Expect(cfg.Mongo.ResourceTimeoutMs).To(Equal(5000))
+		Expect(cfg.Producer.QueueHighWaterMark).To(Equal(0.8))
+		Expect(cfg.HTTP.ListenAddr).To(Equal(":8080"))
+		Expect(cfg.Kafka.EOSEnabled).To(BeFalse())
+		Expect(cfg.Lifecycle.RestoreGracePeriodHours).To(Equal(720))
+		Expect(cfg.Import.MaxBatchSize).To(Equal(500))
+		Expect(cfg.History.Collection).To(Equal("agg_userauth_history"))
+	})
+
+	It("should apply file < env < flag precedence", func() {
+		path := writeFile(dir, "config.yaml", `
+logLevel: debug
+mongo:
+  database: file_db
+  resourceTimeoutMs: 7000
+producer:
+  queueSize: 64
+`)
+		os.Setenv("MONGO_DATABASE", "env_db")
+		os.Unsetenv("PRODUCER_QUEUE_SIZE")
+
+		cfg, err := Load([]string{
+			"-config", path,
+			"-mongo-database", "flag_db",
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(cfg.LogLevel).To(Equal("debug"))
+		Expect(cfg.Mongo.ResourceTimeoutMs).To(Equal(7000))
+		Expect(cfg.Producer.QueueSize).To(Equal(64))
+		Expect(cfg.Mongo.Database).To(Equal("flag_db"))
+		// Defaults are kept for settings absent from the file
+		Expect(cfg.Mongo.ConnectionTimeoutMs).To(Equal(3000))
+	})
+
+	It("should load TOML config-files set by env-var", func() {
+		path := writeFile(dir, "config.toml", `
+[outbox]
+collection = "outbox"
+pollIntervalMs = 100
+`)
+		os.Setenv(EnvConfigFile, path)
+
+		cfg, err := Load(nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cfg.Outbox.Collection).To(Equal("outbox"))
+		Expect(cfg.Outbox.PollIntervalMs).To(Equal(100))
+		Expect(cfg.Outbox.MaxAttempts).To(Equal(10))
+		Expect(cfg.Outbox.LeaseMs).To(Equal(30000))
+		Expect(cfg.Outbox.RetentionHours).To(Equal(24))
+	})
+
+	It("should report all invalid settings at once", func() {
+		os.Unsetenv("SERVICE_NAME")
+		os.Unsetenv("MONGO_HOSTS")
+		os.Setenv("PRODUCER_QUEUE_SIZE", "many")
+		os.Setenv("PRODUCER_QUEUE_HIGH_WATER_MARK", "1.5")
+
+		cfg, err := Load(nil)
+		Expect(cfg).ToNot(BeNil())
+		Expect(err).To(HaveOccurred())
+
+		verr, ok := err.(*ValidationError)
+		Expect(ok).To(BeTrue())
+		Expect(verr.Problems).To(ConsistOf(
+			ContainSubstring("SERVICE_NAME"),
+			ContainSubstring("MONGO_HOSTS"),
+			ContainSubstring("PRODUCER_QUEUE_SIZE"),
+			ContainSubstring("PRODUCER_QUEUE_HIGH_WATER_MARK"),
+		))
+	})
+
+	It("should validate Kafka TLS and SASL settings", func() {
+		os.Setenv("KAFKA_TLS_ENABLED", "true")
+		os.Setenv("KAFKA_TLS_CERT_FILE", "client.pem")
+		os.Setenv("KAFKA_SASL_MECHANISM", "SCRAM-SHA-1")
+
+		_, err := Load(nil)
+		Expect(err).To(HaveOccurred())
+		verr := err.(*ValidationError)
+		Expect(verr.Problems).To(ConsistOf(
+			ContainSubstring("KAFKA_TLS_KEY_FILE"),
+			ContainSubstring("KAFKA_SASL_MECHANISM"),
+		))
+
+		os.Setenv("KAFKA_TLS_KEY_FILE", "client-key.pem")
+		os.Setenv("KAFKA_SASL_MECHANISM", SASLMechanismSCRAMSHA512)
+		os.Setenv("KAFKA_SASL_USERNAME", "user")
+		os.Setenv("KAFKA_SASL_PASSWORD", "sasl-password")
+		cfg, err := Load(nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cfg.Kafka.TLS.Enabled).To(BeTrue())
+		Expect(cfg.Kafka.SASL.Mechanism).To(Equal(SASLMechanismSCRAMSHA512))
+		Expect(cfg.Redacted().Kafka.SASL.Password).To(Equal(redacted))
+	})
+
+	It("should read secrets from files", func() {
+		os.Unsetenv("MONGO_PASSWORD")
+		os.Setenv("MONGO_PASSWORD_FILE", writeFile(dir, "mongo_password", "file-password\n"))
+		keyPath := writeFile(dir, "signing_key", "signing-key")
+
+		cfg, err := Load([]string{"-auth-signing-key-file", keyPath})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cfg.Mongo.Password).To(Equal("file-password"))
+		Expect(cfg.Auth.SigningKey).To(Equal("signing-key"))
+		Expect(cfg.SecretFiles).To(HaveKeyWithValue("AUTH_SIGNING_KEY", keyPath))
+	})
+
+	It("should return error when a secret and its file are both set", func() {
+		os.Setenv("MONGO_PASSWORD_FILE", writeFile(dir, "mongo_password", "file-password"))
+		os.Setenv("KAFKA_SASL_PASSWORD_FILE", filepath.Join(dir, "missing"))
+
+		_, err := Load(nil)
+		Expect(err).To(HaveOccurred())
+		verr := err.(*ValidationError)
+		Expect(verr.Problems).To(ConsistOf(
+			ContainSubstring("MONGO_PASSWORD_FILE"),
+			ContainSubstring("KAFKA_SASL_PASSWORD_FILE"),
+		))
+	})
+
+	It("should not require Kafka-only settings in standalone-mode", func() {
+		os.Unsetenv("KAFKA_BROKERS")
+		os.Unsetenv("KAFKA_CONSUMER_TOPIC_ESRESP")
+		os.Setenv("STANDALONE", "true")
+		_, err := Load(nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		os.Setenv("KAFKA_EOS_ENABLED", "true")
+		_, err = Load(nil)
+		Expect(err).To(HaveOccurred())
+		verr := err.(*ValidationError)
+		Expect(verr.Problems).To(ConsistOf(ContainSubstring("KAFKA_EOS_ENABLED")))
+	})
+
+	It("should validate the role-catalogue", func() {
+		path := writeFile(dir, "config.yaml", `
+rbac:
+  roles:
+    - name: admin
+      permissions: ["*"]
+    - name: admin
+      permissions: ["*"]
+    - name: customer
+`)
+		_, err := Load([]string{"-config", path})
+		Expect(err).To(HaveOccurred())
+		verr := err.(*ValidationError)
+		Expect(verr.Problems).To(ConsistOf(ContainSubstring("MONGO_ROLES_COLLECTION")))
+
+		os.Setenv("MONGO_ROLES_COLLECTION", "roles")
+		os.Setenv("MONGO_USER_ROLES_COLLECTION", "user_roles")
+		_, err = Load([]string{"-config", path})
+		Expect(err).To(HaveOccurred())
+		verr = err.(*ValidationError)
+		Expect(verr.Problems).To(ConsistOf(
+			ContainSubstring("duplicate role admin"),
+			ContainSubstring("permissions are required for role customer"),
+		))
+	})
+
+	It("should require the active key for field-encryption", func() {
+		os.Setenv("FIELD_ENCRYPTION_KEYS", "k1:key")
+		_, err := Load(nil)
+		Expect(err).To(HaveOccurred())
+		verr := err.(*ValidationError)
+		Expect(verr.Problems).To(ConsistOf(ContainSubstring("FIELD_ENCRYPTION_ACTIVE_KEY_ID")))
+	})
+
+	It("should return error on unknown config-file keys", func() {
+		path := writeFile(dir, "config.yml", "unknownKey: true\n")
+		_, err := Load([]string{"-config", path})
+		Expect(err).To(HaveOccurred())
+
+		path = writeFile(dir, "config.toml", "unknownKey = true\n[outbox]\nunknown = 1\n")
+		_, err = Load([]string{"-config", path})
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("unknownKey"))
+		Expect(err.Error()).To(ContainSubstring("outbox.unknown"))
+	})
+
+	It("should only require Mongo-settings when loading them alone", func() {
+		os.Unsetenv("KAFKA_BROKERS")
+		os.Unsetenv("KAFKA_CONSUMER_TOPIC_REQUEST")
+		_, err := Load(nil)
+		Expect(err).To(HaveOccurred())
+
+		cfg, err := LoadMongo(nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cfg.Mongo.Hosts).ToNot(BeEmpty())
+
+		os.Unsetenv("MONGO_DATABASE")
+		_, err = LoadMongo(nil)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should redact secrets when printing", func() {
+		cfg, err := Load(nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		buf := &bytes.Buffer{}
+		err = cfg.Print(buf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(buf.String()).ToNot(ContainSubstring("secret-password"))
+		Expect(buf.String()).To(ContainSubstring(redacted))
+		Expect(buf.String()).To(ContainSubstring("agg_userauth_cmd"))
+		// The original Config is unchanged
+		Expect(cfg.Mongo.Password).To(Equal("secret-password"))
+	})
+})
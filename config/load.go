@@ -0,0 +1,501 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/TerrexTech/agg-userauth-cmd/secrets"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// EnvConfigFile is the env-var for the path of the config-file.
+// The -config flag takes precedence over it.
+const EnvConfigFile = "CONFIG_FILE"
+
+// redacted replaces the values of secret settings when printing the config.
+const redacted = "[REDACTED]"
+
+// fileSuffix is appended to the env-var of a secret
+// to get the env-var for its file.
+const fileSuffix = "_FILE"
+
+// ValidationError lists all invalid settings of a Config.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf(
+		"invalid config:\n  - %s",
+		strings.Join(e.Problems, "\n  - "),
+	)
+}
+
+func (e *ValidationError) addf(format string, args ...interface{}) {
+	e.Problems = append(e.Problems, fmt.Sprintf(format, args...))
+}
+
+// errOrNil returns the ValidationError if it has any problems, else nil.
+func (e *ValidationError) errOrNil() error {
+	if len(e.Problems) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Load loads the Config from the config-file, env-vars and command-line
+// args (excluding the program-name). The config-file is a YAML (.yaml, .yml)
+// or TOML (.toml) file set by the -config flag or CONFIG_FILE env-var.
+//
+// If any setting is invalid, the Config is returned along with a
+// *ValidationError listing every invalid setting.
+func Load(args []string) (*Config, error) {
+	return load(args, (*Config).validate)
+}
+
+// LoadMongo loads the Config like Load, but only requires and validates
+// its Mongo-settings, for tests and tools which do not use Kafka.
+func LoadMongo(args []string) (*Config, error) {
+	return load(args, func(c *Config, verr *ValidationError) {
+		for _, s := range settings(c) {
+			if strings.HasPrefix(s.env, "MONGO_") && s.required && s.isZero() {
+				verr.addf("%s is required, but is not set", s.env)
+			}
+		}
+		c.validateMongo(verr)
+	})
+}
+
+// load loads the Config, and checks it using validate.
+func load(args []string, validate func(*Config, *ValidationError)) (*Config, error) {
+	cfg := &Config{}
+	list := settings(cfg)
+
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	configFile := fs.String(
+		"config",
+		os.Getenv(EnvConfigFile),
+		"Path of YAML or TOML config-file (env: "+EnvConfigFile+")",
+	)
+	byFlag := map[string]*setting{}
+	for _, s := range list {
+		name := flagName(s.env)
+		byFlag[name] = s
+		fs.String(name, "", "Overrides env: "+s.env)
+		if s.secret {
+			fs.String(
+				flagName(s.env+fileSuffix),
+				"",
+				"Overrides env: "+s.env+fileSuffix,
+			)
+		}
+	}
+	err := fs.Parse(args)
+	if err != nil {
+		err = errors.Wrap(err, "Error parsing flags")
+		return nil, err
+	}
+
+	verr := &ValidationError{}
+	for _, s := range list {
+		if s.def == "" {
+			continue
+		}
+		err := s.set(s.def)
+		if err != nil {
+			verr.addf("default for %s: %s", s.env, err)
+		}
+	}
+
+	if *configFile != "" {
+		err := loadFile(cfg, *configFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.SecretFiles == nil {
+		cfg.SecretFiles = map[string]string{}
+	}
+	for _, s := range list {
+		if s.secret {
+			if path := os.Getenv(s.env + fileSuffix); path != "" {
+				cfg.SecretFiles[s.env] = path
+			}
+		}
+		value := os.Getenv(s.env)
+		if value == "" {
+			continue
+		}
+		err := s.set(value)
+		if err != nil {
+			verr.addf("env %s: %s", s.env, err)
+		}
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		s, ok := byFlag[f.Name]
+		if !ok {
+			return
+		}
+		err := s.set(f.Value.String())
+		if err != nil {
+			verr.addf("flag -%s: %s", f.Name, err)
+		}
+	})
+	for _, s := range list {
+		if !s.secret {
+			continue
+		}
+		if f := fs.Lookup(flagName(s.env + fileSuffix)); f.Value.String() != "" {
+			cfg.SecretFiles[s.env] = f.Value.String()
+		}
+	}
+	loadSecretFiles(cfg, verr)
+
+	validate(cfg, verr)
+	return cfg, verr.errOrNil()
+}
+
+// loadSecretFiles sets the secrets from their files.
+func loadSecretFiles(cfg *Config, verr *ValidationError) {
+	bySecret := map[string]*setting{}
+	for _, s := range settings(cfg) {
+		if s.secret {
+			bySecret[s.env] = s
+		}
+	}
+
+	for env, path := range cfg.SecretFiles {
+		s, ok := bySecret[env]
+		if !ok {
+			verr.addf("secret-file set for unknown secret %s", env)
+			continue
+		}
+		if !s.isZero() {
+			verr.addf("only one of %s and %s%s can be set", env, env, fileSuffix)
+			continue
+		}
+		value, err := secrets.ReadFile(path)
+		if err != nil {
+			verr.addf("%s%s: %s", env, fileSuffix, err)
+			continue
+		}
+		err = s.set(value)
+		if err != nil {
+			verr.addf("%s%s: %s", env, fileSuffix, err)
+		}
+	}
+}
+
+// loadFile decodes the config-file into cfg. Settings absent
+// from the file are left unchanged.
+func loadFile(cfg *Config, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		err = errors.Wrap(err, "Error reading config-file")
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, cfg)
+	case ".toml":
+		var md toml.MetaData
+		md, err = toml.Decode(string(data), cfg)
+		// Unknown keys are rejected, as by the strict YAML-decoding
+		if err == nil && len(md.Undecoded()) > 0 {
+			keys := []string{}
+			for _, key := range md.Undecoded() {
+				keys = append(keys, key.String())
+			}
+			err = errors.Errorf("unknown keys: %s", strings.Join(keys, ", "))
+		}
+	default:
+		return errors.Errorf("unsupported config-file extension: %s", filepath.Ext(path))
+	}
+	if err != nil {
+		err = errors.Wrapf(err, "Error decoding config-file %s", path)
+		return err
+	}
+	return nil
+}
+
+// Validate checks the Config, and returns a *ValidationError
+// listing every invalid setting.
+func (c *Config) Validate() error {
+	verr := &ValidationError{}
+	c.validate(verr)
+	return verr.errOrNil()
+}
+
+func (c *Config) validate(verr *ValidationError) {
+	for _, s := range settings(c) {
+		if s.required && s.isZero() && !(c.Standalone && s.kafkaOnly) {
+			verr.addf("%s is required, but is not set", s.env)
+		}
+	}
+
+	switch strings.ToLower(c.LogLevel) {
+	case "debug", "info", "warn", "warning", "error":
+	default:
+		verr.addf("LOG_LEVEL must be one of debug, info, warn, error: got %q", c.LogLevel)
+	}
+	if c.Lifecycle.RestoreGracePeriodHours < 0 {
+		verr.addf("LIFECYCLE_RESTORE_GRACE_PERIOD_HOURS cannot be negative")
+	}
+	if c.Import.MaxBatchSize <= 0 {
+		verr.addf("IMPORT_MAX_BATCH_SIZE must be positive")
+	}
+	if c.FieldEncryption.Keys != "" && c.FieldEncryption.ActiveKeyID == "" {
+		verr.addf("FIELD_ENCRYPTION_ACTIVE_KEY_ID is required when FIELD_ENCRYPTION_KEYS is set")
+	}
+	if c.AggBuilderTimeoutSec <= 0 {
+		verr.addf("AGG_BUILDER_TIMEOUT_SEC must be positive")
+	}
+
+	if c.Standalone && c.Kafka.EOSEnabled {
+		verr.addf("KAFKA_EOS_ENABLED is not supported in standalone-mode")
+	}
+	validateTLS(verr, "KAFKA_TLS", &c.Kafka.TLS)
+	switch c.Kafka.SASL.Mechanism {
+	case "":
+	case SASLMechanismPlain, SASLMechanismSCRAMSHA256, SASLMechanismSCRAMSHA512:
+		if c.Kafka.SASL.Username == "" || c.Kafka.SASL.Password == "" {
+			verr.addf("KAFKA_SASL_USERNAME and KAFKA_SASL_PASSWORD are required for SASL")
+		}
+	default:
+		verr.addf(
+			"KAFKA_SASL_MECHANISM must be one of %s, %s, %s: got %q",
+			SASLMechanismPlain,
+			SASLMechanismSCRAMSHA256,
+			SASLMechanismSCRAMSHA512,
+			c.Kafka.SASL.Mechanism,
+		)
+	}
+
+	c.validateMongo(verr)
+
+	if c.Producer.QueueSize <= 0 {
+		verr.addf("PRODUCER_QUEUE_SIZE must be positive")
+	}
+	if c.Producer.SendTimeoutMs < 0 {
+		verr.addf("PRODUCER_SEND_TIMEOUT_MS cannot be negative")
+	}
+	if c.Producer.QueueHighWaterMark <= 0 || c.Producer.QueueHighWaterMark > 1 {
+		verr.addf("PRODUCER_QUEUE_HIGH_WATER_MARK must be in range (0, 1]")
+	}
+	if c.Producer.MaxErrorRate < 0 || c.Producer.MaxErrorRate > 1 {
+		verr.addf("PRODUCER_MAX_ERROR_RATE must be in range [0, 1]")
+	}
+
+	if c.Outbox.Collection != "" {
+		if c.Outbox.PollIntervalMs <= 0 {
+			verr.addf("OUTBOX_POLL_INTERVAL_MS must be positive")
+		}
+		if c.Outbox.MaxAttempts <= 0 {
+			verr.addf("OUTBOX_MAX_ATTEMPTS must be positive")
+		}
+		if c.Outbox.LeaseMs <= 0 {
+			verr.addf("OUTBOX_LEASE_MS must be positive")
+		}
+		if c.Outbox.RetentionHours <= 0 {
+			verr.addf("OUTBOX_RETENTION_HOURS must be positive")
+		}
+	}
+
+	c.validateRBAC(verr)
+	if c.Auth.RequireToken && c.Auth.SigningKey == "" {
+		verr.addf("AUTH_SIGNING_KEY is required if AUTH_REQUIRE_TOKEN is set")
+	}
+	if c.Auth.PolicyFile != "" && !c.Auth.Enabled {
+		verr.addf("AUTH_ENABLED is required if AUTH_POLICY_FILE is set")
+	}
+
+	switch c.Tracing.Exporter {
+	case "none", "stdout":
+	case "file":
+		if c.Tracing.FilePath == "" {
+			verr.addf("TRACING_FILE_PATH is required for file tracing-exporter")
+		}
+	default:
+		verr.addf(
+			"TRACING_EXPORTER must be one of none, stdout, file: got %q",
+			c.Tracing.Exporter,
+		)
+	}
+}
+
+func (c *Config) validateRBAC(verr *ValidationError) {
+	r := &c.RBAC
+	if r.RolesCollection == "" {
+		if len(r.Roles) > 0 {
+			verr.addf("MONGO_ROLES_COLLECTION is required if roles are configured")
+		}
+		return
+	}
+	if r.UserRolesCollection == "" {
+		verr.addf("MONGO_USER_ROLES_COLLECTION is required if MONGO_ROLES_COLLECTION is set")
+	}
+
+	names := map[string]bool{}
+	for i, role := range r.Roles {
+		if role.Name == "" {
+			verr.addf("rbac.roles[%d]: name is required", i)
+			continue
+		}
+		if names[role.Name] {
+			verr.addf("rbac.roles[%d]: duplicate role %s", i, role.Name)
+		}
+		names[role.Name] = true
+		if len(role.Permissions) == 0 {
+			verr.addf("rbac.roles[%d]: permissions are required for role %s", i, role.Name)
+		}
+	}
+}
+
+func (c *Config) validateMongo(verr *ValidationError) {
+	m := &c.Mongo
+	if m.URI == "" {
+		if len(m.Hosts) == 0 {
+			verr.addf("MONGO_HOSTS is required if MONGO_URI is not set")
+		}
+		if m.Username == "" || m.Password == "" {
+			verr.addf("MONGO_USERNAME and MONGO_PASSWORD are required if MONGO_URI is not set")
+		}
+	}
+
+	switch m.ReadConcern {
+	case "", "local", "available", "majority", "linearizable", "snapshot":
+	default:
+		verr.addf(
+			"MONGO_READ_CONCERN must be one of "+
+				"local, available, majority, linearizable, snapshot: got %q",
+			m.ReadConcern,
+		)
+	}
+	if m.WriteConcern != "" && m.WriteConcern != "majority" {
+		w, err := strconv.Atoi(m.WriteConcern)
+		if err != nil || w < 0 {
+			verr.addf(
+				"MONGO_WRITE_CONCERN must be majority or a number: got %q",
+				m.WriteConcern,
+			)
+		}
+	}
+	if m.ConnectionTimeoutMs <= 0 {
+		verr.addf("MONGO_CONNECTION_TIMEOUT_MS must be positive")
+	}
+	if m.ResourceTimeoutMs <= 0 {
+		verr.addf("MONGO_RESOURCE_TIMEOUT_MS must be positive")
+	}
+	if m.MaxPoolSize < 0 || m.MinPoolSize < 0 {
+		verr.addf("MONGO_MAX_POOL_SIZE and MONGO_MIN_POOL_SIZE cannot be negative")
+	}
+	if m.MaxPoolSize > 0 && m.MinPoolSize > m.MaxPoolSize {
+		verr.addf("MONGO_MIN_POOL_SIZE cannot be greater than MONGO_MAX_POOL_SIZE")
+	}
+}
+
+// validateTLS checks the TLS-config, whose env-vars have the prefix.
+func validateTLS(verr *ValidationError, prefix string, t *TLS) {
+	if !t.Enabled {
+		return
+	}
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		verr.addf("%s_CERT_FILE and %s_KEY_FILE must be set together", prefix, prefix)
+	}
+}
+
+// Redacted returns a copy of the Config with the values of secrets replaced.
+func (c *Config) Redacted() *Config {
+	redactedCfg := *c
+	for _, s := range settings(&redactedCfg) {
+		if str, ok := s.ptr.(*string); ok && s.secret && *str != "" {
+			*str = redacted
+		}
+	}
+	return &redactedCfg
+}
+
+// Print writes the effective Config as YAML, with secrets redacted.
+func (c *Config) Print(w io.Writer) error {
+	out, err := yaml.Marshal(c.Redacted())
+	if err != nil {
+		err = errors.Wrap(err, "Error marshalling config")
+		return err
+	}
+	_, err = w.Write(out)
+	if err != nil {
+		err = errors.Wrap(err, "Error writing config")
+		return err
+	}
+	return nil
+}
+
+// flagName returns the flag-name for the env-var.
+func flagName(env string) string {
+	return strings.Replace(strings.ToLower(env), "_", "-", -1)
+}
+
+// set parses the value into the setting's field.
+func (s *setting) set(value string) error {
+	switch ptr := s.ptr.(type) {
+	case *string:
+		*ptr = value
+	case *[]string:
+		list := []string{}
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				list = append(list, v)
+			}
+		}
+		*ptr = list
+	case *int:
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return errors.Errorf("%q is not an integer", value)
+		}
+		*ptr = v
+	case *float64:
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return errors.Errorf("%q is not a number", value)
+		}
+		*ptr = v
+	case *bool:
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.Errorf("%q is not a boolean", value)
+		}
+		*ptr = v
+	default:
+		return errors.Errorf("unsupported setting-type %T", s.ptr)
+	}
+	return nil
+}
+
+// isZero returns true if the setting's field has its zero-value.
+func (s *setting) isZero() bool {
+	switch ptr := s.ptr.(type) {
+	case *string:
+		return *ptr == ""
+	case *[]string:
+		return len(*ptr) == 0
+	case *int:
+		return *ptr == 0
+	case *float64:
+		return *ptr == 0
+	case *bool:
+		return !*ptr
+	}
+	return false
+}
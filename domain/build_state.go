@@ -1,9 +1,22 @@
 package domain
 
 import (
-	"log"
+	"context"
+	"time"
+
+	"github.com/TerrexTech/agg-userauth-cmd/fieldcrypt"
+	"github.com/TerrexTech/agg-userauth-cmd/history"
+	"github.com/TerrexTech/agg-userauth-cmd/importer"
+	"github.com/TerrexTech/agg-userauth-cmd/lifecycle"
+	"github.com/TerrexTech/agg-userauth-cmd/logger"
+	"github.com/TerrexTech/agg-userauth-cmd/metrics"
+	"github.com/TerrexTech/agg-userauth-cmd/rbac"
+	"github.com/TerrexTech/agg-userauth-cmd/shred"
+	"github.com/TerrexTech/agg-userauth-cmd/tracing"
+	"go.opentelemetry.io/otel/attribute"
 
 	"github.com/TerrexTech/go-agg-builder/builder"
+	"github.com/TerrexTech/go-common-models/model"
 
 	"github.com/TerrexTech/go-mongoutils/mongo"
 	"github.com/TerrexTech/uuuid"
@@ -16,8 +29,45 @@ type BuilderFunc func(
 	timeoutSec int,
 ) (<-chan *builder.EventResponse, error)
 
+// Projection holds the collections that Events are applied to.
+type Projection struct {
+	// Users is the Aggregate-collection.
+	Users *mongo.Collection
+	// Roles is optional, and holds the role-catalogue and role-assignments.
+	Roles *rbac.Store
+	// Lifecycle is optional. If set, deleted users are kept as tombstones
+	// instead of being removed.
+	Lifecycle *lifecycle.Store
+	// Imports is optional, and holds the progress of bulk imports.
+	Imports *importer.Store
+	// History is optional, and holds the Events applied to each user.
+	History *history.Store
+	// Keys is optional. If set, the personal data of users in Events is
+	// encrypted with their keys, and is decrypted when applying the Events.
+	Keys *shred.Store
+	// Fields is optional. If set, the fields of Users are encrypted.
+	Fields *fieldcrypt.Codec
+}
+
 // BuildState builds Aggregate-State by applying previous Events.
-func BuildState(coll *mongo.Collection, builderFunc BuilderFunc, timeoutSec int) error {
+// The trace-context in ctx (which can be nil) is used as parent for the spans,
+// and the Logger carried by ctx is used for logging.
+func BuildState(
+	ctx context.Context,
+	proj *Projection,
+	builderFunc BuilderFunc,
+	timeoutSec int,
+) (err error) {
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, "BuildState")
+	buildLog := logger.FromContext(ctx)
+	defer func() {
+		metrics.BuildStateDuration.
+			WithLabelValues(metrics.Outcome(err)).
+			Observe(time.Since(start).Seconds())
+		tracing.End(span, err)
+	}()
+
 	cid, err := uuuid.NewV4()
 	if err != nil {
 		err = errors.Wrap(err, "Error generating CorrelationID")
@@ -35,36 +85,96 @@ func BuildState(coll *mongo.Collection, builderFunc BuilderFunc, timeoutSec int)
 		}
 		if eventResp.Error != nil {
 			err = errors.Wrap(err, "BuildState: Error in EventResp")
-			log.Println(err)
+			buildLog.Error(err)
 		}
 
-		event := &eventResp.Event
-		switch event.Action {
-		case "UserRegistered":
-			err := userRegistered(coll, event)
-			if err != nil {
-				err = errors.Wrap(err, "Error registering user")
-				log.Println(err)
-			}
-
-		case "UserUpdated":
-			err := userUpdated(coll, event)
-			if err != nil {
-				err = errors.Wrap(err, "Error updating user")
-				log.Println(err)
-			}
-
-		case "UserDeleted":
-			err := userDeleted(coll, event)
-			if err != nil {
-				err = errors.Wrap(err, "Error deleting user")
-				log.Println(err)
-			}
-
-		default:
-			log.Printf("Event contains unregistered Action: %s", event.Action)
-		}
+		applyEvent(ctx, proj, &eventResp.Event)
 	}
 
 	return nil
 }
+
+// applyEvent applies the Event to the Projection, and records it in the
+// History. Errors are logged using the Logger carried by ctx, since the
+// following Events are still applied.
+func applyEvent(ctx context.Context, proj *Projection, event *model.Event) {
+	buildLog := logger.FromContext(ctx)
+	actionLabel := event.Action
+	_, eventSpan := tracing.Start(
+		ctx,
+		"mongo.ApplyEvent",
+		attribute.String("event.action", event.Action),
+		attribute.String("event.uuid", event.UUID.String()),
+	)
+	switch event.Action {
+	case "UserRegistered":
+		err := userRegistered(proj, event)
+		if err != nil {
+			err = errors.Wrap(err, "Error registering user")
+			buildLog.Error(err)
+		}
+
+	case "UserUpdated":
+		err := userUpdated(proj, event)
+		if err != nil {
+			err = errors.Wrap(err, "Error updating user")
+			buildLog.Error(err)
+		}
+
+	case "CredentialSet", "CredentialChanged":
+		err := applyCredentialEvent(proj, event)
+		if err != nil {
+			err = errors.Wrapf(err, "Error applying %s", event.Action)
+			buildLog.Error(err)
+		}
+
+	case "UserDeleted":
+		err := userDeleted(proj, event)
+		if err != nil {
+			err = errors.Wrap(err, "Error deleting user")
+			buildLog.Error(err)
+		}
+
+	case "RoleDefined", "RoleAssigned", "RoleRevoked":
+		err := applyRoleEvent(proj.Roles, event)
+		if err != nil {
+			err = errors.Wrapf(err, "Error applying %s", event.Action)
+			buildLog.Error(err)
+		}
+
+	case "UserErased":
+		err := userErased(proj, event)
+		if err != nil {
+			err = errors.Wrap(err, "Error erasing user")
+			buildLog.Error(err)
+		}
+
+	case "UsersImported":
+		err := usersImported(proj, event)
+		if err != nil {
+			err = errors.Wrap(err, "Error importing users")
+			buildLog.Error(err)
+		}
+
+	case "UserDeactivated", "UserReactivated", "UserRestored", "UserPurged":
+		err := applyLifecycleEvent(proj, event)
+		if err != nil {
+			err = errors.Wrapf(err, "Error applying %s", event.Action)
+			buildLog.Error(err)
+		}
+
+	default:
+		buildLog.Warnf("Event contains unregistered Action: %s", event.Action)
+		actionLabel = metrics.UnknownAction
+	}
+	metrics.BuildStateEvents.WithLabelValues(actionLabel).Inc()
+
+	if proj.History != nil {
+		err := recordHistory(proj, event)
+		if err != nil {
+			err = errors.Wrapf(err, "Error recording history of %s", event.Action)
+			buildLog.Error(err)
+		}
+	}
+	eventSpan.End()
+}
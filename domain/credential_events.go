@@ -0,0 +1,64 @@
+package domain
+
+import (
+	"encoding/json"
+
+	"github.com/TerrexTech/agg-userauth-cmd/shred"
+	"github.com/TerrexTech/go-common-models/model"
+	"github.com/pkg/errors"
+)
+
+// credential is the data of CredentialSet and CredentialChanged Events.
+type credential struct {
+	UserID       string `json:"userID"`
+	PasswordHash string `json:"passwordHash"`
+	ChangedAt    int64  `json:"changedAt"`
+	ChangedBy    string `json:"changedBy"`
+}
+
+// applyCredentialEvent sets the password-hash of the user, so the projection
+// can be used for authentication. Users registered before these Events were
+// introduced have the hash in their UserRegistered Event instead.
+func applyCredentialEvent(proj *Projection, event *model.Event) error {
+	cred := &credential{}
+	err := json.Unmarshal(event.Data, cred)
+	if err != nil {
+		err = errors.Wrap(err, "Error while unmarshalling Event-data")
+		return err
+	}
+	if cred.UserID == "" {
+		return errors.New("missing UserID in Event-data")
+	}
+	deactivated, err := isDeactivated(proj, cred.UserID)
+	if err != nil {
+		return err
+	}
+	if deactivated {
+		return nil
+	}
+
+	passwordHash := cred.PasswordHash
+	if proj.Keys != nil {
+		key, err := proj.Keys.Key(cred.UserID)
+		if err != nil {
+			err = errors.Wrap(err, "Error finding key")
+			return err
+		}
+		// The hash of erased users is left blank
+		passwordHash = shred.Decrypt(key, passwordHash)
+	}
+
+	_, err = proj.Users.UpdateMany(
+		map[string]interface{}{
+			"userID": cred.UserID,
+		},
+		map[string]interface{}{
+			"password": passwordHash,
+		},
+	)
+	if err != nil {
+		err = errors.Wrap(err, "Error updating password in Mongo")
+		return err
+	}
+	return nil
+}
@@ -1,8 +1,11 @@
 package domain
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"log"
+	"strings"
 	"testing"
 	"time"
 
@@ -11,8 +14,13 @@ import (
 	"github.com/joho/godotenv"
 	"github.com/pkg/errors"
 
+	"github.com/TerrexTech/agg-userauth-cmd/history"
+	"github.com/TerrexTech/agg-userauth-cmd/importer"
+	"github.com/TerrexTech/agg-userauth-cmd/lifecycle"
+	"github.com/TerrexTech/agg-userauth-cmd/rbac"
 	"github.com/TerrexTech/agg-userauth-cmd/util"
 	"github.com/TerrexTech/agg-userauth-model/user"
+	"github.com/TerrexTech/go-agg-builder/builder"
 	"github.com/TerrexTech/go-mongoutils/mongo"
 	"github.com/TerrexTech/uuuid"
 	. "github.com/onsi/ginkgo"
@@ -69,10 +77,12 @@ func TestEvent(t *testing.T) {
 var _ = Describe("EventHandler", func() {
 	var (
 		coll *mongo.Collection
+		mc   *builder.MongoConfig
 	)
 
 	BeforeSuite(func() {
-		mc, err := util.LoadMongoConfig()
+		var err error
+		mc, err = util.LoadMongoConfig()
 		Expect(err).ToNot(HaveOccurred())
 		coll = mc.AggCollection
 	})
@@ -108,12 +118,81 @@ var _ = Describe("EventHandler", func() {
 				YearBucket:    2018,
 			}
 
-			err = userDeleted(coll, mockEvent)
+			err = userDeleted(&Projection{Users: coll}, mockEvent)
 			Expect(err).ToNot(HaveOccurred())
 
 			_, err = coll.FindOne(mockUser)
 			Expect(err).To(HaveOccurred())
 		})
+
+		It("should delete only the users with the UserIDs", func() {
+			users := []user.User{}
+			for i := 0; i < 2; i++ {
+				uid, err := uuuid.NewV4()
+				Expect(err).ToNot(HaveOccurred())
+				mockUser := user.User{
+					UserID:   uid.String(),
+					UserName: uid.String(),
+					Role:     "test-delete-role",
+				}
+				_, err = coll.InsertOne(mockUser)
+				Expect(err).ToNot(HaveOccurred())
+				users = append(users, mockUser)
+			}
+
+			data, err := json.Marshal(map[string]interface{}{
+				"userIDs":   []string{users[0].UserID},
+				"deletedAt": time.Now().Unix(),
+				"deletedBy": "test-actor",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			err = userDeleted(&Projection{Users: coll}, &model.Event{
+				Action:      "UserDeleted",
+				AggregateID: 1,
+				Data:        data,
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = coll.FindOne(users[0])
+			Expect(err).To(HaveOccurred())
+			_, err = coll.FindOne(users[1])
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should only apply userID and userName of legacy filters", func() {
+			uid, err := uuuid.NewV4()
+			Expect(err).ToNot(HaveOccurred())
+			mockUser := user.User{
+				UserID:   uid.String(),
+				UserName: uid.String(),
+				Role:     "test-legacy-role",
+			}
+			_, err = coll.InsertOne(mockUser)
+			Expect(err).ToNot(HaveOccurred())
+
+			for _, data := range []string{
+				`{"role": "test-legacy-role"}`,
+				`{"userID": {"$ne": ""}}`,
+				`{"deletedBy": "test-actor"}`,
+			} {
+				err = userDeleted(&Projection{Users: coll}, &model.Event{
+					Action:      "UserDeleted",
+					AggregateID: 1,
+					Data:        []byte(data),
+				})
+				Expect(err).ToNot(HaveOccurred())
+				_, err = coll.FindOne(mockUser)
+				Expect(err).ToNot(HaveOccurred(), data)
+			}
+
+			Expect(legacyFilter(map[string]interface{}{
+				"userID":   mockUser.UserID,
+				"role":     "test-legacy-role",
+				"userName": map[string]interface{}{"$ne": ""},
+			})).To(Equal(map[string]interface{}{
+				"userID": mockUser.UserID,
+			}))
+		})
 	})
 
 	Describe("UserRegistered", func() {
@@ -150,7 +229,7 @@ var _ = Describe("EventHandler", func() {
 				YearBucket:    2018,
 			}
 
-			err = userRegistered(coll, mockEvent)
+			err = userRegistered(&Projection{Users: coll}, mockEvent)
 			Expect(err).ToNot(HaveOccurred())
 
 			result, err := coll.FindOne(mockUser)
@@ -211,7 +290,7 @@ var _ = Describe("EventHandler", func() {
 				YearBucket:    2018,
 			}
 
-			err = userUpdated(coll, mockEvent)
+			err = userUpdated(&Projection{Users: coll}, mockEvent)
 			Expect(err).ToNot(HaveOccurred())
 
 			result, err := coll.FindOne(mockUser)
@@ -222,4 +301,371 @@ var _ = Describe("EventHandler", func() {
 			Expect(findUser.FirstName).To(Equal(fName.String()))
 		})
 	})
+
+	Describe("RoleEvents", func() {
+		var roles *rbac.Store
+
+		BeforeEach(func() {
+			var err error
+			roles, err = rbac.NewStore(&rbac.StoreConfig{
+				Conn:                mc.Connection,
+				Database:            mc.MetaDatabaseName,
+				RolesCollection:     "test_roles",
+				UserRolesCollection: "test_user_roles",
+				Roles: []*rbac.Role{
+					&rbac.Role{
+						Name:        "configured-role",
+						Permissions: []string{"UpdateUser"},
+					},
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should define roles, but not replace configured roles", func() {
+			uid, err := uuuid.NewV4()
+			Expect(err).ToNot(HaveOccurred())
+			name := "role-" + uid.String()
+
+			err = applyRoleEvent(roles, &model.Event{
+				Action: "RoleDefined",
+				Data:   []byte(fmt.Sprintf(`{"name": "%s", "permissions": ["DeleteUser"]}`, name)),
+			})
+			Expect(err).ToNot(HaveOccurred())
+			role, err := roles.Role(name)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(role.Permissions).To(Equal([]string{"DeleteUser"}))
+
+			err = applyRoleEvent(roles, &model.Event{
+				Action: "RoleDefined",
+				Data:   []byte(`{"name": "configured-role", "permissions": ["*"]}`),
+			})
+			Expect(err).ToNot(HaveOccurred())
+			role, err = roles.Role("configured-role")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(role.Permissions).To(Equal([]string{"UpdateUser"}))
+		})
+
+		It("should assign and revoke roles", func() {
+			uid, err := uuuid.NewV4()
+			Expect(err).ToNot(HaveOccurred())
+			data := []byte(fmt.Sprintf(`{"userID": "%s", "role": "configured-role"}`, uid.String()))
+
+			err = applyRoleEvent(roles, &model.Event{
+				Action: "RoleAssigned",
+				Data:   data,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			userRoles, err := roles.UserRoles(uid.String())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(userRoles).To(Equal([]string{"configured-role"}))
+
+			err = applyRoleEvent(roles, &model.Event{
+				Action: "RoleRevoked",
+				Data:   data,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			userRoles, err = roles.UserRoles(uid.String())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(userRoles).To(BeEmpty())
+		})
+
+		It("should return error if RBAC is not enabled", func() {
+			err := applyRoleEvent(nil, &model.Event{
+				Action: "RoleAssigned",
+				Data:   []byte(`{"userID": "test-user", "role": "configured-role"}`),
+			})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("UsersImported", func() {
+		It("should upsert the users when the Event is applied again", func() {
+			imports, err := importer.NewStore(mc.Connection, mc.MetaDatabaseName, "test_imports")
+			Expect(err).ToNot(HaveOccurred())
+			proj := &Projection{
+				Users:   coll,
+				Imports: imports,
+			}
+
+			importID, err := uuuid.NewV4()
+			Expect(err).ToNot(HaveOccurred())
+			uid, err := uuuid.NewV4()
+			Expect(err).ToNot(HaveOccurred())
+			batch := &importer.ImportedBatch{
+				ImportID:   importID.String(),
+				Offset:     0,
+				NextOffset: 1,
+				Users: []*user.User{
+					&user.User{
+						UserID:   uid.String(),
+						UserName: uid.String(),
+					},
+				},
+			}
+			marshalBatch, err := json.Marshal(batch)
+			Expect(err).ToNot(HaveOccurred())
+			event := &model.Event{
+				Action: "UsersImported",
+				Data:   marshalBatch,
+			}
+
+			err = usersImported(proj, event)
+			Expect(err).ToNot(HaveOccurred())
+			err = usersImported(proj, event)
+			Expect(err).ToNot(HaveOccurred())
+
+			results, err := coll.Find(map[string]interface{}{
+				"userID": uid.String(),
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(results).To(HaveLen(1))
+			progress, err := imports.Progress(importID.String())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(progress.NextOffset).To(Equal(1))
+			Expect(progress.Imported).To(Equal(1))
+		})
+	})
+
+	Describe("LifecycleEvents", func() {
+		var (
+			proj     *Projection
+			testUser user.User
+		)
+
+		// lifecycleEvent creates the lifecycle-Event for the user.
+		lifecycleEvent := func(action string, userID string) *model.Event {
+			return &model.Event{
+				Action: action,
+				Data: []byte(fmt.Sprintf(
+					`{"userID": "%s", "changedAt": %d, "changedBy": "test-actor"}`,
+					userID, time.Now().Unix(),
+				)),
+			}
+		}
+
+		BeforeEach(func() {
+			records, err := lifecycle.NewStore(&lifecycle.StoreConfig{
+				Conn:       mc.Connection,
+				Database:   mc.MetaDatabaseName,
+				Collection: "test_lifecycle",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			proj = &Projection{
+				Users:     coll,
+				Lifecycle: records,
+			}
+
+			uid, err := uuuid.NewV4()
+			Expect(err).ToNot(HaveOccurred())
+			testUser = user.User{
+				UserID:   uid.String(),
+				UserName: uid.String(),
+			}
+			_, err = coll.InsertOne(testUser)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should deactivate and reactivate users", func() {
+			err := applyLifecycleEvent(proj, lifecycleEvent("UserDeactivated", testUser.UserID))
+			Expect(err).ToNot(HaveOccurred())
+			record, err := proj.Lifecycle.Record(testUser.UserID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(record.Status).To(Equal(lifecycle.StatusDeactivated))
+			Expect(record.DeactivatedBy).To(Equal("test-actor"))
+
+			err = applyLifecycleEvent(proj, lifecycleEvent("UserReactivated", testUser.UserID))
+			Expect(err).ToNot(HaveOccurred())
+			record, err = proj.Lifecycle.Record(testUser.UserID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(record).To(BeNil())
+		})
+
+		It("should not update deactivated users", func() {
+			err := applyLifecycleEvent(proj, lifecycleEvent("UserDeactivated", testUser.UserID))
+			Expect(err).ToNot(HaveOccurred())
+
+			data, err := json.Marshal(map[string]interface{}{
+				"filter": map[string]interface{}{
+					"userID": testUser.UserID,
+				},
+				"update": map[string]interface{}{
+					"userID":    testUser.UserID,
+					"userName":  testUser.UserName,
+					"firstName": "test-name",
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			err = userUpdated(proj, &model.Event{
+				Action: "UserUpdated",
+				Data:   data,
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			result, err := coll.FindOne(testUser)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result.(*user.User).FirstName).To(BeEmpty())
+		})
+
+		It("should restore users from their tombstones", func() {
+			_, err := coll.DeleteMany(testUser)
+			Expect(err).ToNot(HaveOccurred())
+			err = proj.Lifecycle.Put(&lifecycle.Record{
+				UserID:    testUser.UserID,
+				Status:    lifecycle.StatusDeleted,
+				DeletedAt: time.Now().Unix(),
+				User:      &testUser,
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			err = applyLifecycleEvent(proj, lifecycleEvent("UserRestored", testUser.UserID))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = coll.FindOne(testUser)
+			Expect(err).ToNot(HaveOccurred())
+			record, err := proj.Lifecycle.Record(testUser.UserID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(record).To(BeNil())
+		})
+
+		It("should purge users and their records", func() {
+			err := applyLifecycleEvent(proj, lifecycleEvent("UserDeactivated", testUser.UserID))
+			Expect(err).ToNot(HaveOccurred())
+
+			err = applyLifecycleEvent(proj, lifecycleEvent("UserPurged", testUser.UserID))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = coll.FindOne(testUser)
+			Expect(err).To(HaveOccurred())
+			record, err := proj.Lifecycle.Record(testUser.UserID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(record).To(BeNil())
+		})
+	})
+
+	Describe("History", func() {
+		It("should find the users each Event applies to", func() {
+			events := map[string]string{
+				`{"userID": "user-1", "password": "hash"}`:                                 "user-1",
+				`{"userIDs": ["user-1", "user-2"], "deletedBy": "a"}`:                      "user-1,user-2",
+				`{"filter": {"userName": "n"}, "update": {"userID": "user-1"}}`:            "user-1",
+				`{"importID": "i", "users": [{"userID": "user-1"}, {"userID": "user-2"}]}`: "user-1,user-2",
+				`{"name": "admin", "permissions": ["*"]}`:                                  "",
+			}
+			for data, expected := range events {
+				userIDs, err := eventUserIDs(&model.Event{
+					Data: []byte(data),
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(strings.Join(userIDs, ",")).To(Equal(expected), data)
+			}
+		})
+
+		It("should only keep the user's own data of imported users", func() {
+			data, err := userEventData(&model.Event{
+				Data: []byte(`{"importID": "i", "users": [{"userID": "u1"}, {"userID": "u2"}]}`),
+			}, "u2")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(MatchJSON(`{"importID": "i", "users": [{"userID": "u2"}]}`))
+		})
+
+		It("should backfill the Events applied before the History was configured", func() {
+			cid, err := uuuid.NewV4()
+			Expect(err).ToNot(HaveOccurred())
+			records, err := history.NewStore(&history.StoreConfig{
+				Conn:       mc.Connection,
+				Database:   mc.MetaDatabaseName,
+				Collection: "test_history_" + cid.String(),
+			})
+			Expect(err).ToNot(HaveOccurred())
+			proj := &Projection{
+				Users:   coll,
+				History: records,
+			}
+
+			// The first user was registered before the History was configured,
+			// so the user was already inserted, and the Aggregate is at its version.
+			events := []*builder.EventResponse{}
+			users := []user.User{}
+			for version := int64(2); version <= 3; version++ {
+				uid, err := uuuid.NewV4()
+				Expect(err).ToNot(HaveOccurred())
+				u := user.User{
+					UserID:   uid.String(),
+					UserName: uid.String(),
+				}
+				users = append(users, u)
+				marshalUser, err := json.Marshal(u)
+				Expect(err).ToNot(HaveOccurred())
+				eventID, err := uuuid.NewV4()
+				Expect(err).ToNot(HaveOccurred())
+				events = append(events, &builder.EventResponse{
+					Event: model.Event{
+						Action:   "UserRegistered",
+						Data:     marshalUser,
+						NanoTime: time.Now().UnixNano(),
+						UUID:     eventID,
+						Version:  version,
+					},
+				})
+			}
+			_, err = coll.InsertOne(users[0])
+			Expect(err).ToNot(HaveOccurred())
+			aggVersion := &testAggVersion{
+				version: 2,
+			}
+
+			// The Events are fetched from the version the Aggregate is reset to,
+			// and the version is updated as each Event is fetched.
+			builderFunc := func(uuuid.UUID, int) (<-chan *builder.EventResponse, error) {
+				Expect(aggVersion.version).To(BeZero())
+				eventChan := make(chan *builder.EventResponse, len(events))
+				for _, eventResp := range events {
+					eventChan <- eventResp
+					aggVersion.version = eventResp.Event.Version
+				}
+				close(eventChan)
+				return eventChan, nil
+			}
+			err = BackfillHistory(context.Background(), proj, aggVersion, builderFunc, 1)
+			Expect(err).ToNot(HaveOccurred())
+
+			for _, u := range users {
+				entries, err := records.Entries(u.UserID)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(entries).To(HaveLen(1))
+				Expect(entries[0].Action).To(Equal("UserRegistered"))
+
+				results, err := coll.Find(map[string]interface{}{
+					"userID": u.UserID,
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(results).To(HaveLen(1))
+			}
+			Expect(aggVersion.version).To(Equal(int64(3)))
+
+			// The backfill only runs once
+			err = BackfillHistory(
+				context.Background(), proj, aggVersion,
+				func(uuuid.UUID, int) (<-chan *builder.EventResponse, error) {
+					return nil, errors.New("backfill ran again")
+				},
+				1,
+			)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(aggVersion.version).To(Equal(int64(3)))
+		})
+	})
 })
+
+// testAggVersion is an in-memory AggregateVersion.
+type testAggVersion struct {
+	version int64
+}
+
+func (v *testAggVersion) Version() (int64, error) {
+	return v.version, nil
+}
+
+func (v *testAggVersion) SetVersion(version int64) error {
+	v.version = version
+	return nil
+}
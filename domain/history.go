@@ -0,0 +1,98 @@
+package domain
+
+import (
+	"encoding/json"
+
+	"github.com/TerrexTech/go-common-models/model"
+	"github.com/pkg/errors"
+)
+
+// eventUsers holds the fields identifying the users Events apply to.
+type eventUsers struct {
+	UserID  string   `json:"userID"`
+	UserIDs []string `json:"userIDs"`
+	// UserUpdated Events contain the complete updated user
+	Update *struct {
+		UserID string `json:"userID"`
+	} `json:"update"`
+	// UsersImported Events contain the imported users
+	Users []*struct {
+		UserID string `json:"userID"`
+	} `json:"users"`
+}
+
+// recordHistory records the Event in the history of each user it applies to.
+func recordHistory(proj *Projection, event *model.Event) error {
+	userIDs, err := eventUserIDs(event)
+	if err != nil {
+		return err
+	}
+	for _, userID := range userIDs {
+		data, err := userEventData(event, userID)
+		if err != nil {
+			return err
+		}
+		err = proj.History.Record(userID, event, data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eventUserIDs returns the UserIDs of the users the Event applies to.
+func eventUserIDs(event *model.Event) ([]string, error) {
+	data := &eventUsers{}
+	err := json.Unmarshal(event.Data, data)
+	if err != nil {
+		err = errors.Wrap(err, "Error while unmarshalling Event-data")
+		return nil, err
+	}
+
+	userIDs := []string{}
+	seen := map[string]bool{}
+	add := func(userID string) {
+		if userID != "" && !seen[userID] {
+			seen[userID] = true
+			userIDs = append(userIDs, userID)
+		}
+	}
+	add(data.UserID)
+	for _, userID := range data.UserIDs {
+		add(userID)
+	}
+	if data.Update != nil {
+		add(data.Update.UserID)
+	}
+	for _, u := range data.Users {
+		if u != nil {
+			add(u.UserID)
+		}
+	}
+	return userIDs, nil
+}
+
+// userEventData returns the Event-data without the data of other users, as
+// the users of UsersImported Events, so histories only hold their own data.
+func userEventData(event *model.Event, userID string) ([]byte, error) {
+	data := map[string]interface{}{}
+	err := json.Unmarshal(event.Data, &data)
+	if err != nil {
+		err = errors.Wrap(err, "Error while unmarshalling Event-data")
+		return nil, err
+	}
+	users, ok := data["users"].([]interface{})
+	if !ok {
+		return event.Data, nil
+	}
+
+	ownUsers := []interface{}{}
+	for _, u := range users {
+		userMap, ok := u.(map[string]interface{})
+		if ok && userMap["userID"] == userID {
+			ownUsers = append(ownUsers, u)
+		}
+	}
+	data["users"] = ownUsers
+	return json.Marshal(data)
+}
@@ -0,0 +1,251 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/TerrexTech/agg-userauth-cmd/logger"
+	"github.com/TerrexTech/agg-userauth-cmd/util"
+	"github.com/TerrexTech/go-agg-builder/builder"
+	"github.com/TerrexTech/go-common-models/model"
+	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/TerrexTech/uuuid"
+	"github.com/pkg/errors"
+)
+
+// AggregateVersion gets and sets the version of the Aggregate-state, after
+// which the Agg-Builder fetches Events.
+type AggregateVersion interface {
+	Version() (int64, error)
+	SetVersion(version int64) error
+}
+
+// MetaVersion is the AggregateVersion kept in the Agg-Builder's
+// meta-collection.
+type MetaVersion struct {
+	aggID int8
+	coll  *mongo.Collection
+}
+
+// NewMetaVersion returns the MetaVersion of the Aggregate in the
+// meta-collection of the config.
+func NewMetaVersion(config *builder.MongoConfig) (*MetaVersion, error) {
+	if config == nil {
+		return nil, errors.New("config cannot be nil")
+	}
+	coll, err := util.EnsureCollection(&mongo.Collection{
+		Connection:   config.Connection,
+		Database:     config.MetaDatabaseName,
+		Name:         config.MetaCollectionName,
+		SchemaStruct: &builder.AggregateMeta{},
+	})
+	if err != nil {
+		err = errors.Wrap(err, "Error creating Meta-collection")
+		return nil, err
+	}
+	return &MetaVersion{
+		aggID: config.AggregateID,
+		coll:  coll,
+	}, nil
+}
+
+// Version returns the version of the Aggregate.
+func (m *MetaVersion) Version() (int64, error) {
+	result, err := m.coll.FindOne(&builder.AggregateMeta{
+		AggregateID: m.aggID,
+	})
+	if err != nil {
+		err = errors.Wrap(err, "Error finding AggregateMeta")
+		return 0, err
+	}
+	meta, assertOK := result.(*builder.AggregateMeta)
+	if !assertOK {
+		err = errors.New("error asserting find-result to AggregateMeta")
+		return 0, err
+	}
+	return meta.Version, nil
+}
+
+// SetVersion sets the version of the Aggregate.
+func (m *MetaVersion) SetVersion(version int64) error {
+	_, err := m.coll.UpdateMany(
+		&builder.AggregateMeta{
+			AggregateID: m.aggID,
+		},
+		map[string]interface{}{
+			"version": version,
+		},
+	)
+	if err != nil {
+		err = errors.Wrap(err, "Error updating AggregateMeta")
+		return err
+	}
+	return nil
+}
+
+// BackfillHistory records the Events applied before the History was
+// configured, by fetching all Events from the start of the Event-stream.
+// Events up to the Aggregate-version when the backfill was started are only
+// recorded, and later Events are applied as by BuildState.
+//
+// The backfill only runs once, and is started again if it did not complete,
+// such as if the service stopped during it. It must complete before other
+// Commands are handled, since it resets the Aggregate-version.
+func BackfillHistory(
+	ctx context.Context,
+	proj *Projection,
+	aggVersion AggregateVersion,
+	builderFunc BuilderFunc,
+	timeoutSec int,
+) error {
+	if proj.History == nil {
+		return nil
+	}
+	backfill, err := proj.History.Backfill()
+	if err != nil {
+		return err
+	}
+	if backfill.Complete {
+		return nil
+	}
+
+	// The Aggregate-version is only read once, since the version is reset
+	// when the backfill starts.
+	appliedVersion := backfill.Version
+	if !backfill.Started {
+		appliedVersion, err = aggVersion.Version()
+		if err != nil {
+			return err
+		}
+		err = proj.History.StartBackfill(appliedVersion)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = aggVersion.SetVersion(0)
+	if err != nil {
+		return err
+	}
+	lastVersion, err := backfillEvents(ctx, proj, appliedVersion, builderFunc, timeoutSec)
+
+	// The Agg-Builder updates the version asynchronously, so the version is
+	// only restored once the Agg-Builder has recorded the last Event.
+	waitErr := waitForVersion(aggVersion, lastVersion, time.Duration(timeoutSec)*time.Second)
+	if waitErr != nil && err == nil {
+		err = waitErr
+	}
+	restoreVersion := appliedVersion
+	if lastVersion > restoreVersion {
+		restoreVersion = lastVersion
+	}
+	restoreErr := aggVersion.SetVersion(restoreVersion)
+	if restoreErr != nil {
+		restoreErr = errors.Wrap(restoreErr, "Error restoring Aggregate-version")
+		return restoreErr
+	}
+	if err != nil {
+		return err
+	}
+
+	// Without all Events up to the applied version, the Events were not
+	// fetched completely, such as if the Agg-Builder timed out. The
+	// Agg-Builder starts Aggregates at version 1, so there are no Events to
+	// fetch at that version.
+	if lastVersion < appliedVersion && appliedVersion > 1 {
+		return errors.Errorf(
+			"backfill only fetched Events up to version %d of %d", lastVersion, appliedVersion,
+		)
+	}
+	return proj.History.CompleteBackfill(restoreVersion)
+}
+
+// backfillEvents fetches the Events from the start of the Event-stream, and
+// returns the version of the last Event.
+func backfillEvents(
+	ctx context.Context,
+	proj *Projection,
+	appliedVersion int64,
+	builderFunc BuilderFunc,
+	timeoutSec int,
+) (int64, error) {
+	buildLog := logger.FromContext(ctx)
+
+	cid, err := uuuid.NewV4()
+	if err != nil {
+		err = errors.Wrap(err, "Error generating CorrelationID")
+		return 0, err
+	}
+	eventRespChan, err := builderFunc(cid, timeoutSec)
+	if err != nil {
+		err = errors.Wrap(err, "Failed to fetch Events for backfilling history")
+		return 0, err
+	}
+
+	lastVersion := int64(0)
+	for eventResp := range eventRespChan {
+		if eventResp == nil {
+			continue
+		}
+		if eventResp.Error != nil {
+			err := errors.Wrap(eventResp.Error, "BackfillHistory: Error in EventResp")
+			buildLog.Error(err)
+		}
+
+		event := &eventResp.Event
+		if event.Version > lastVersion {
+			lastVersion = event.Version
+		}
+		if event.Version > appliedVersion {
+			applyEvent(ctx, proj, event)
+			continue
+		}
+		err := backfillEvent(proj, event)
+		if err != nil {
+			err = errors.Wrapf(err, "Error backfilling history of %s", event.Action)
+			buildLog.Error(err)
+		}
+	}
+	return lastVersion, nil
+}
+
+// backfillEvent records the already applied Event in the History, and
+// removes the history of users erased by it, as userErased does.
+func backfillEvent(proj *Projection, event *model.Event) error {
+	if event.Action == "UserErased" {
+		erasure := &userErasure{}
+		err := json.Unmarshal(event.Data, erasure)
+		if err != nil {
+			err = errors.Wrap(err, "Error while unmarshalling Event-data")
+			return err
+		}
+		err = proj.History.Remove(erasure.UserID)
+		if err != nil {
+			err = errors.Wrap(err, "Error removing user-history")
+			return err
+		}
+	}
+	return recordHistory(proj, event)
+}
+
+// waitForVersion waits until the Aggregate-version reaches the version,
+// or the timeout expires.
+func waitForVersion(aggVersion AggregateVersion, version int64, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		current, err := aggVersion.Version()
+		if err != nil {
+			return err
+		}
+		if current >= version {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.Errorf(
+				"Aggregate-version did not reach %d within %s (at %d)", version, timeout, current,
+			)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,100 @@
+package domain
+
+import (
+	"encoding/json"
+
+	"github.com/TerrexTech/agg-userauth-cmd/lifecycle"
+	"github.com/TerrexTech/go-common-models/model"
+	"github.com/pkg/errors"
+)
+
+// lifecycleChange is the data of UserDeactivated, UserReactivated,
+// UserRestored and UserPurged Events.
+type lifecycleChange struct {
+	UserID    string `json:"userID"`
+	ChangedAt int64  `json:"changedAt"`
+	ChangedBy string `json:"changedBy"`
+}
+
+// applyLifecycleEvent applies the UserDeactivated, UserReactivated,
+// UserRestored and UserPurged Events to the projections.
+func applyLifecycleEvent(proj *Projection, event *model.Event) error {
+	if proj.Lifecycle == nil {
+		return errors.New("soft-delete is not enabled")
+	}
+	change := &lifecycleChange{}
+	err := json.Unmarshal(event.Data, change)
+	if err != nil {
+		err = errors.Wrap(err, "Error while unmarshalling Event-data")
+		return err
+	}
+	if change.UserID == "" {
+		return errors.New("missing UserID in Event-data")
+	}
+
+	switch event.Action {
+	case "UserDeactivated":
+		return proj.Lifecycle.Put(&lifecycle.Record{
+			UserID:        change.UserID,
+			Status:        lifecycle.StatusDeactivated,
+			DeactivatedAt: change.ChangedAt,
+			DeactivatedBy: change.ChangedBy,
+		})
+
+	case "UserReactivated":
+		return proj.Lifecycle.Remove(change.UserID)
+
+	case "UserRestored":
+		record, err := proj.Lifecycle.Record(change.UserID)
+		if err != nil {
+			return err
+		}
+		if record == nil || record.User == nil {
+			return errors.Errorf("no tombstone found for user %s", change.UserID)
+		}
+		// Tombstones are already encrypted, unless they were added before
+		// field-encryption was enabled
+		storedUser, err := proj.Fields.EncryptUser(record.User)
+		if err != nil {
+			err = errors.Wrap(err, "Error encrypting User")
+			return err
+		}
+		_, err = proj.Users.InsertOne(storedUser)
+		if err != nil {
+			err = errors.Wrap(err, "Error Inserting User into Mongo")
+			return err
+		}
+		return proj.Lifecycle.Remove(change.UserID)
+
+	case "UserPurged":
+		_, err = proj.Users.DeleteMany(map[string]interface{}{
+			"userID": change.UserID,
+		})
+		if err != nil {
+			err = errors.Wrap(err, "Error Deleting User from Mongo")
+			return err
+		}
+		err = proj.Lifecycle.Remove(change.UserID)
+		if err != nil {
+			return err
+		}
+		if proj.Roles != nil {
+			return proj.Roles.DeleteUser(change.UserID)
+		}
+		return nil
+	}
+	return errors.Errorf("unknown lifecycle-Event %s", event.Action)
+}
+
+// isDeactivated returns true if the user is deactivated. Events changing the
+// user are not applied to the projection while it is deactivated.
+func isDeactivated(proj *Projection, userID string) (bool, error) {
+	if proj.Lifecycle == nil || userID == "" {
+		return false, nil
+	}
+	record, err := proj.Lifecycle.Record(userID)
+	if err != nil {
+		return false, err
+	}
+	return record != nil && record.Status == lifecycle.StatusDeactivated, nil
+}
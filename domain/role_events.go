@@ -0,0 +1,47 @@
+package domain
+
+import (
+	"encoding/json"
+
+	"github.com/TerrexTech/agg-userauth-cmd/rbac"
+	"github.com/TerrexTech/go-common-models/model"
+	"github.com/pkg/errors"
+)
+
+type roleAssignment struct {
+	UserID string `json:"userID"`
+	Role   string `json:"role"`
+}
+
+// applyRoleEvent applies the RoleDefined, RoleAssigned and RoleRevoked Events
+// to the role-projections.
+func applyRoleEvent(roles *rbac.Store, event *model.Event) error {
+	if roles == nil {
+		return errors.New("role-based access control is not enabled")
+	}
+
+	if event.Action == "RoleDefined" {
+		role := &rbac.Role{}
+		err := json.Unmarshal(event.Data, role)
+		if err != nil {
+			err = errors.Wrap(err, "Error while unmarshalling Event-data")
+			return err
+		}
+		// Roles from the config take precedence over Events
+		if roles.IsConfigured(role.Name) {
+			return nil
+		}
+		return roles.PutRole(role)
+	}
+
+	assignment := &roleAssignment{}
+	err := json.Unmarshal(event.Data, assignment)
+	if err != nil {
+		err = errors.Wrap(err, "Error while unmarshalling Event-data")
+		return err
+	}
+	if event.Action == "RoleAssigned" {
+		return roles.AssignRole(assignment.UserID, assignment.Role)
+	}
+	return roles.RevokeRole(assignment.UserID, assignment.Role)
+}
@@ -3,25 +3,126 @@ package domain
 import (
 	"encoding/json"
 
+	"github.com/TerrexTech/agg-userauth-cmd/lifecycle"
 	"github.com/TerrexTech/go-common-models/model"
 
-	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/TerrexTech/agg-userauth-model/user"
 	"github.com/pkg/errors"
 )
 
-func userDeleted(coll *mongo.Collection, event *model.Event) error {
+// userDeletion is the data of UserDeleted Events.
+type userDeletion struct {
+	UserIDs   []string `json:"userIDs"`
+	DeletedAt int64    `json:"deletedAt"`
+	DeletedBy string   `json:"deletedBy"`
+}
+
+func userDeleted(proj *Projection, event *model.Event) error {
 	params := map[string]interface{}{}
 	err := json.Unmarshal(event.Data, &params)
 	if err != nil {
 		err = errors.Wrap(err, "Error while unmarshalling Event-data")
 		return err
 	}
+	deletion := &userDeletion{}
+	err = json.Unmarshal(event.Data, deletion)
+	if err != nil {
+		err = errors.Wrap(err, "Error while unmarshalling Event-data")
+		return err
+	}
+
+	filters := []map[string]interface{}{}
+	if _, ok := params["userIDs"]; ok {
+		for _, userID := range deletion.UserIDs {
+			if userID == "" {
+				continue
+			}
+			filters = append(filters, map[string]interface{}{
+				"userID": userID,
+			})
+		}
+	} else if filter := legacyFilter(params); filter != nil {
+		filters = append(filters, filter)
+	}
+
+	for _, filter := range filters {
+		err = deleteUsers(proj, filter, deletion)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	_, err = coll.DeleteMany(params)
+func deleteUsers(
+	proj *Projection,
+	userFilter map[string]interface{},
+	deletion *userDeletion,
+) error {
+	filter, err := proj.Fields.Filter(userFilter)
 	if err != nil {
-		err = errors.Wrap(err, "Error Deleting User from Mongo")
+		err = errors.Wrap(err, "Error creating filter")
 		return err
 	}
 
+	// Deleted users are kept as tombstones (along with their role-assignments)
+	// if soft-delete is enabled, else their role-assignments are also removed.
+	// Tombstones keep the users' fields encrypted as in the projection, and
+	// UserIDs are never encrypted.
+	if proj.Lifecycle != nil || proj.Roles != nil {
+		matches, err := proj.Users.Find(filter)
+		if err != nil {
+			err = errors.Wrap(err, "Error finding Users to delete")
+			return err
+		}
+		for _, match := range matches {
+			u, ok := match.(*user.User)
+			if !ok {
+				continue
+			}
+			if proj.Lifecycle != nil {
+				err = proj.Lifecycle.Put(&lifecycle.Record{
+					UserID:    u.UserID,
+					Status:    lifecycle.StatusDeleted,
+					DeletedAt: deletion.DeletedAt,
+					DeletedBy: deletion.DeletedBy,
+					User:      u,
+				})
+				if err != nil {
+					err = errors.Wrap(err, "Error adding tombstone")
+					return err
+				}
+				continue
+			}
+			err = proj.Roles.DeleteUser(u.UserID)
+			if err != nil {
+				err = errors.Wrap(err, "Error deleting user-roles")
+				return err
+			}
+		}
+	}
+
+	_, err = proj.Users.DeleteMany(filter)
+	if err != nil {
+		err = errors.Wrap(err, "Error Deleting User from Mongo")
+		return err
+	}
 	return nil
 }
+
+// legacyFilter returns the filter of Events produced before UserIDs were
+// added, which carry the command's filter. Only the equality of the userID
+// and userName is applied, so the Event cannot match users by other fields
+// or by query-operators. Nil is returned if the filter has neither.
+func legacyFilter(params map[string]interface{}) map[string]interface{} {
+	filter := map[string]interface{}{}
+	for _, key := range []string{"userID", "userName"} {
+		if value, ok := params[key].(string); ok && value != "" {
+			filter[key] = value
+		}
+	}
+	if len(filter) == 0 {
+		return nil
+	}
+	return filter
+}
@@ -0,0 +1,100 @@
+package domain
+
+import (
+	"encoding/json"
+
+	"github.com/TerrexTech/agg-userauth-cmd/shred"
+	"github.com/TerrexTech/agg-userauth-model/user"
+	"github.com/TerrexTech/go-common-models/model"
+	"github.com/pkg/errors"
+)
+
+// userErasure is the data of UserErased Events.
+type userErasure struct {
+	UserID   string `json:"userID"`
+	ErasedAt int64  `json:"erasedAt"`
+	ErasedBy string `json:"erasedBy"`
+}
+
+// userErased destroys the key of the user, which leaves the user's personal
+// data in Events unreadable, and anonymizes the user in the projections.
+func userErased(proj *Projection, event *model.Event) error {
+	if proj.Keys == nil {
+		return errors.New("erasure is not enabled")
+	}
+	erasure := &userErasure{}
+	err := json.Unmarshal(event.Data, erasure)
+	if err != nil {
+		err = errors.Wrap(err, "Error while unmarshalling Event-data")
+		return err
+	}
+	if erasure.UserID == "" {
+		return errors.New("missing UserID in Event-data")
+	}
+
+	err = proj.Keys.Destroy(erasure.UserID)
+	if err != nil {
+		err = errors.Wrap(err, "Error destroying key")
+		return err
+	}
+
+	anonymized := map[string]interface{}{}
+	for _, field := range shred.Fields {
+		anonymized[field] = ""
+	}
+	_, err = proj.Users.UpdateMany(
+		map[string]interface{}{
+			"userID": erasure.UserID,
+		},
+		anonymized,
+	)
+	if err != nil {
+		err = errors.Wrap(err, "Error anonymizing User in Mongo")
+		return err
+	}
+
+	if proj.Lifecycle != nil {
+		record, err := proj.Lifecycle.Record(erasure.UserID)
+		if err != nil {
+			return err
+		}
+		if record != nil && record.User != nil {
+			anonymizeUser(record.User)
+			err = proj.Lifecycle.Put(record)
+			if err != nil {
+				err = errors.Wrap(err, "Error anonymizing tombstone")
+				return err
+			}
+		}
+	}
+	if proj.History != nil {
+		err = proj.History.Remove(erasure.UserID)
+		if err != nil {
+			err = errors.Wrap(err, "Error removing user-history")
+			return err
+		}
+	}
+	return nil
+}
+
+// decryptUser decrypts the personal data of the user, if erasure is enabled.
+// The data of erased users is left blank.
+func decryptUser(proj *Projection, u *user.User) error {
+	if proj.Keys == nil {
+		return nil
+	}
+	key, err := proj.Keys.Key(u.UserID)
+	if err != nil {
+		err = errors.Wrap(err, "Error finding key")
+		return err
+	}
+	shred.DecryptUser(key, u)
+	return nil
+}
+
+func anonymizeUser(u *user.User) {
+	u.Email = ""
+	u.FirstName = ""
+	u.LastName = ""
+	u.Password = ""
+}
@@ -6,11 +6,10 @@ import (
 	"github.com/TerrexTech/go-common-models/model"
 
 	"github.com/TerrexTech/agg-userauth-model/user"
-	"github.com/TerrexTech/go-mongoutils/mongo"
 	"github.com/pkg/errors"
 )
 
-func userRegistered(coll *mongo.Collection, event *model.Event) error {
+func userRegistered(proj *Projection, event *model.Event) error {
 	user := &user.User{}
 	err := json.Unmarshal(event.Data, user)
 	if err != nil {
@@ -18,11 +17,28 @@ func userRegistered(coll *mongo.Collection, event *model.Event) error {
 		return err
 	}
 
-	_, err = coll.InsertOne(user)
+	err = decryptUser(proj, user)
+	if err != nil {
+		return err
+	}
+	storedUser, err := proj.Fields.EncryptUser(user)
+	if err != nil {
+		err = errors.Wrap(err, "Error encrypting User")
+		return err
+	}
+	_, err = proj.Users.InsertOne(storedUser)
 	if err != nil {
 		err = errors.Wrap(err, "Error Inserting User into Mongo")
 		return err
 	}
 
+	// The role the user registered with is the user's first assigned role
+	if proj.Roles != nil && user.Role != "" {
+		err = proj.Roles.AssignRole(user.UserID, user.Role)
+		if err != nil {
+			err = errors.Wrap(err, "Error assigning registered Role")
+			return err
+		}
+	}
 	return nil
 }
@@ -5,7 +5,7 @@ import (
 
 	"github.com/TerrexTech/go-common-models/model"
 
-	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/TerrexTech/agg-userauth-cmd/shred"
 	"github.com/pkg/errors"
 )
 
@@ -14,7 +14,7 @@ type updateParams struct {
 	Update map[string]interface{} `json:"update"`
 }
 
-func userUpdated(coll *mongo.Collection, event *model.Event) error {
+func userUpdated(proj *Projection, event *model.Event) error {
 	params := &updateParams{}
 	err := json.Unmarshal(event.Data, params)
 	if err != nil {
@@ -22,7 +22,36 @@ func userUpdated(coll *mongo.Collection, event *model.Event) error {
 		return err
 	}
 
-	_, err = coll.UpdateMany(params.Filter, params.Update)
+	// The update is the complete updated user, so it has the UserID
+	userID, _ := params.Update["userID"].(string)
+	deactivated, err := isDeactivated(proj, userID)
+	if err != nil {
+		return err
+	}
+	if deactivated {
+		return nil
+	}
+
+	if proj.Keys != nil && params.Update != nil {
+		key, err := proj.Keys.Key(userID)
+		if err != nil {
+			err = errors.Wrap(err, "Error finding key")
+			return err
+		}
+		shred.DecryptMap(key, params.Update)
+	}
+
+	filter, err := proj.Fields.Filter(params.Filter)
+	if err != nil {
+		err = errors.Wrap(err, "Error creating filter")
+		return err
+	}
+	update, err := proj.Fields.EncryptUpdate(params.Update)
+	if err != nil {
+		err = errors.Wrap(err, "Error encrypting update")
+		return err
+	}
+	_, err = proj.Users.UpdateMany(filter, update)
 	if err != nil {
 		err = errors.Wrap(err, "Error Updating User in Mongo")
 		return err
@@ -0,0 +1,104 @@
+package domain
+
+import (
+	"encoding/json"
+
+	"github.com/TerrexTech/agg-userauth-cmd/importer"
+	"github.com/TerrexTech/agg-userauth-model/user"
+	"github.com/TerrexTech/go-common-models/model"
+	"github.com/pkg/errors"
+)
+
+// usersImported upserts the imported users, and advances the import's
+// progress. The progress is advanced even if some users fail to import,
+// since the Event is not applied again, and the import would otherwise
+// not continue past the batch. Applying the Event again is idempotent.
+func usersImported(proj *Projection, event *model.Event) error {
+	if proj.Imports == nil {
+		return errors.New("bulk import is not enabled")
+	}
+	batch := &importer.ImportedBatch{}
+	err := json.Unmarshal(event.Data, batch)
+	if err != nil {
+		err = errors.Wrap(err, "Error while unmarshalling Event-data")
+		return err
+	}
+
+	var importErr error
+	failed := 0
+	for _, u := range batch.Users {
+		err = importUser(proj, u)
+		if err != nil {
+			failed++
+			if importErr == nil {
+				importErr = err
+			}
+		}
+	}
+
+	err = proj.Imports.Advance(batch)
+	if err != nil {
+		err = errors.Wrap(err, "Error advancing import-progress")
+		return err
+	}
+	if importErr != nil {
+		importErr = errors.Wrapf(
+			importErr, "Error importing %d of %d Users", failed, len(batch.Users),
+		)
+		return importErr
+	}
+	return nil
+}
+
+// importUser inserts the user as for UserRegistered, or replaces the user
+// with the same UserID if the user was already imported.
+func importUser(proj *Projection, u *user.User) error {
+	err := decryptUser(proj, u)
+	if err != nil {
+		return err
+	}
+	storedUser, err := proj.Fields.EncryptUser(u)
+	if err != nil {
+		err = errors.Wrap(err, "Error encrypting User")
+		return err
+	}
+
+	// The User's JSON-fields are the same as its BSON-fields
+	marshalUser, err := json.Marshal(storedUser)
+	if err != nil {
+		err = errors.Wrap(err, "Error marshalling imported User")
+		return err
+	}
+	update := map[string]interface{}{}
+	err = json.Unmarshal(marshalUser, &update)
+	if err != nil {
+		err = errors.Wrap(err, "Error unmarshalling imported User")
+		return err
+	}
+	result, err := proj.Users.UpdateMany(
+		map[string]interface{}{
+			"userID": u.UserID,
+		},
+		update,
+	)
+	if err != nil {
+		err = errors.Wrapf(err, "Error Updating imported User %s in Mongo", u.UserID)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		_, err = proj.Users.InsertOne(storedUser)
+		if err != nil {
+			err = errors.Wrapf(err, "Error Inserting imported User %s into Mongo", u.UserID)
+			return err
+		}
+	}
+
+	if proj.Roles != nil && u.Role != "" {
+		err = proj.Roles.AssignRole(u.UserID, u.Role)
+		if err != nil {
+			err = errors.Wrap(err, "Error assigning imported Role")
+			return err
+		}
+	}
+	return nil
+}
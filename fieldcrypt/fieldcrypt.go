@@ -0,0 +1,351 @@
+// Package fieldcrypt encrypts selected fields of users in the
+// users-collection.
+//
+// Fields are encrypted either deterministically, so users can still be found
+// by the field (such as UserName), or randomized, which hides whether users
+// have the same value. Values are tagged with the ID of their key, so keys
+// are rotated by adding a new active key, and re-encrypting the users.
+// Values which are not encrypted, such as those stored before encryption was
+// enabled, are read as they are.
+package fieldcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/TerrexTech/agg-userauth-model/user"
+	"github.com/pkg/errors"
+)
+
+// Prefix prefixes encrypted values, which are formatted as
+// "<Prefix><key-ID>:<mode>:<base64-ciphertext>".
+const Prefix = "fe1:"
+
+// KeySize is the size of keys, for AES-256.
+const KeySize = 32
+
+// Modes of encrypting fields.
+const (
+	Deterministic = "d"
+	Randomized    = "r"
+)
+
+// Fields are the user-fields which can be encrypted. UserID cannot be
+// encrypted since users are referenced by it, and Password is a hash.
+var Fields = []string{"email", "firstName", "lastName", "userName", "role"}
+
+// lookupFields are the Fields which users are looked up by, such as for
+// checking that UserNames are unique, so they can only be deterministic.
+var lookupFields = map[string]bool{
+	"userName": true,
+}
+
+// Config is the config for Codec.
+type Config struct {
+	// Keys are the keys by their ID, formatted as "<ID>:<base64-key>",
+	// separated by commas.
+	Keys string
+	// ActiveKeyID is the ID of the key used for encrypting.
+	ActiveKeyID         string
+	DeterministicFields []string
+	RandomizedFields    []string
+}
+
+type fieldKey struct {
+	id     string
+	gcm    cipher.AEAD
+	sivKey []byte
+}
+
+// Codec encrypts and decrypts the fields of users. Its methods can be called
+// on a nil Codec, which leaves users as they are.
+type Codec struct {
+	keys   map[string]*fieldKey
+	keyIDs []string
+	active *fieldKey
+	// modes are the modes by field
+	modes map[string]string
+}
+
+// NewCodec creates a Codec for the config.
+func NewCodec(config *Config) (*Codec, error) {
+	if config == nil {
+		return nil, errors.New("config cannot be nil")
+	}
+	keys, err := ParseKeys(config.Keys)
+	if err != nil {
+		return nil, err
+	}
+	c := &Codec{
+		keys:  map[string]*fieldKey{},
+		modes: map[string]string{},
+	}
+	for id, key := range keys {
+		c.keys[id], err = newFieldKey(id, key)
+		if err != nil {
+			return nil, err
+		}
+		c.keyIDs = append(c.keyIDs, id)
+	}
+	sort.Strings(c.keyIDs)
+
+	c.active = c.keys[config.ActiveKeyID]
+	if c.active == nil {
+		return nil, errors.Errorf("active key %q not found in keys", config.ActiveKeyID)
+	}
+
+	err = c.addFields(config.DeterministicFields, Deterministic)
+	if err != nil {
+		return nil, err
+	}
+	err = c.addFields(config.RandomizedFields, Randomized)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Codec) addFields(fields []string, mode string) error {
+	for _, field := range fields {
+		if !isField(field) {
+			return errors.Errorf("field %q cannot be encrypted", field)
+		}
+		if _, exists := c.modes[field]; exists {
+			return errors.Errorf("field %q is listed more than once", field)
+		}
+		if mode == Randomized && lookupFields[field] {
+			return errors.Errorf("field %q can only be encrypted deterministically", field)
+		}
+		c.modes[field] = mode
+	}
+	return nil
+}
+
+func isField(field string) bool {
+	for _, f := range Fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseKeys parses the keys formatted as for Config.Keys.
+func ParseKeys(keys string) (map[string][]byte, error) {
+	parsed := map[string][]byte{}
+	for _, entry := range strings.Split(keys, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.New("keys must be formatted as <ID>:<base64-key>")
+		}
+		id := parts[0]
+		if _, exists := parsed[id]; exists {
+			return nil, errors.Errorf("duplicate key-ID %q", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			err = errors.Wrapf(err, "Error decoding key %q", id)
+			return nil, err
+		}
+		if len(key) != KeySize {
+			return nil, errors.Errorf("key %q must be %d bytes", id, KeySize)
+		}
+		parsed[id] = key
+	}
+	if len(parsed) == 0 {
+		return nil, errors.New("no keys found")
+	}
+	return parsed, nil
+}
+
+// newFieldKey derives separate keys for encrypting, and for the synthetic
+// nonces of deterministic encryption.
+func newFieldKey(id string, key []byte) (*fieldKey, error) {
+	block, err := aes.NewCipher(derive(key, "fieldcrypt-enc"))
+	if err != nil {
+		err = errors.Wrapf(err, "Error creating cipher for key %q", id)
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		err = errors.Wrapf(err, "Error creating GCM for key %q", id)
+		return nil, err
+	}
+	return &fieldKey{
+		id:     id,
+		gcm:    gcm,
+		sivKey: derive(key, "fieldcrypt-siv"),
+	}, nil
+}
+
+func derive(key []byte, label string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(label))
+	return mac.Sum(nil)
+}
+
+// encrypt encrypts the value of the field. Deterministic values use a nonce
+// derived from the field and value, so equal values encrypt equally.
+// The field is authenticated, so values cannot be moved between fields.
+func (k *fieldKey) encrypt(field string, mode string, value string) (string, error) {
+	nonce := make([]byte, k.gcm.NonceSize())
+	if mode == Deterministic {
+		mac := hmac.New(sha256.New, k.sivKey)
+		mac.Write([]byte(field + "\x00" + value))
+		copy(nonce, mac.Sum(nil))
+	} else {
+		_, err := io.ReadFull(rand.Reader, nonce)
+		if err != nil {
+			err = errors.Wrap(err, "Error generating nonce")
+			return "", err
+		}
+	}
+	sealed := k.gcm.Seal(nonce, nonce, []byte(value), []byte(field))
+	encoded := base64.RawStdEncoding.EncodeToString(sealed)
+	return Prefix + k.id + ":" + mode + ":" + encoded, nil
+}
+
+// IsEncrypted returns true if the value is encrypted.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, Prefix)
+}
+
+// decrypt decrypts the value of the field. Values which are not encrypted
+// are returned as they are.
+func (c *Codec) decrypt(field string, value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	parts := strings.SplitN(strings.TrimPrefix(value, Prefix), ":", 3)
+	if len(parts) != 3 {
+		return "", errors.Errorf("malformed encrypted value of field %s", field)
+	}
+	key := c.keys[parts[0]]
+	if key == nil {
+		return "", errors.Errorf("key %q of field %s not found", parts[0], field)
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil || len(sealed) < key.gcm.NonceSize() {
+		return "", errors.Errorf("malformed encrypted value of field %s", field)
+	}
+	nonceSize := key.gcm.NonceSize()
+	plain, err := key.gcm.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte(field))
+	if err != nil {
+		err = errors.Wrapf(err, "Error decrypting field %s", field)
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// userFields returns the encryptable fields of the user by their name.
+func userFields(u *user.User) map[string]*string {
+	return map[string]*string{
+		"email":     &u.Email,
+		"firstName": &u.FirstName,
+		"lastName":  &u.LastName,
+		"userName":  &u.UserName,
+		"role":      &u.Role,
+	}
+}
+
+// EncryptUser returns a copy of the user with its fields encrypted using
+// the active key.
+func (c *Codec) EncryptUser(u *user.User) (*user.User, error) {
+	if c == nil || u == nil {
+		return u, nil
+	}
+	encrypted := *u
+	for field, value := range userFields(&encrypted) {
+		mode, ok := c.modes[field]
+		if !ok || *value == "" || IsEncrypted(*value) {
+			continue
+		}
+		var err error
+		*value, err = c.active.encrypt(field, mode, *value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &encrypted, nil
+}
+
+// DecryptUser decrypts the fields of the user.
+func (c *Codec) DecryptUser(u *user.User) error {
+	if c == nil || u == nil {
+		return nil
+	}
+	for field, value := range userFields(u) {
+		plain, err := c.decrypt(field, *value)
+		if err != nil {
+			return err
+		}
+		*value = plain
+	}
+	return nil
+}
+
+// DecryptResults decrypts the users in the results of Find.
+func (c *Codec) DecryptResults(results []interface{}) error {
+	for _, result := range results {
+		if u, ok := result.(*user.User); ok {
+			err := c.DecryptUser(u)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// EncryptUpdate returns a copy of the update with its fields encrypted using
+// the active key.
+func (c *Codec) EncryptUpdate(update map[string]interface{}) (map[string]interface{}, error) {
+	if c == nil {
+		return update, nil
+	}
+	encrypted := map[string]interface{}{}
+	for field, value := range update {
+		encrypted[field] = value
+		str, ok := value.(string)
+		mode, encrypt := c.modes[field]
+		if !ok || !encrypt || str == "" || IsEncrypted(str) {
+			continue
+		}
+		var err error
+		encrypted[field], err = c.active.encrypt(field, mode, str)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return encrypted, nil
+}
+
+// Stale returns true if a field of the stored user is not encrypted as
+// configured, such as when it was encrypted with a key which is no longer
+// active, or before encryption was enabled.
+func (c *Codec) Stale(u *user.User) bool {
+	if c == nil || u == nil {
+		return false
+	}
+	for field, value := range userFields(u) {
+		mode, ok := c.modes[field]
+		if !ok || *value == "" {
+			continue
+		}
+		if !strings.HasPrefix(*value, Prefix+c.active.id+":"+mode+":") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+package fieldcrypt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"github.com/TerrexTech/agg-userauth-model/user"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// TestFieldCrypt tests field-level encryption.
+func TestFieldCrypt(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "FieldCrypt Suite")
+}
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, KeySize))
+}
+
+func newTestCodec(keys string, activeKeyID string) *Codec {
+	codec, err := NewCodec(&Config{
+		Keys:                keys,
+		ActiveKeyID:         activeKeyID,
+		DeterministicFields: []string{"email", "userName"},
+		RandomizedFields:    []string{"firstName", "lastName"},
+	})
+	Expect(err).ToNot(HaveOccurred())
+	return codec
+}
+
+var _ = Describe("Codec", func() {
+	var (
+		codec    *Codec
+		testUser *user.User
+	)
+
+	BeforeEach(func() {
+		codec = newTestCodec("k1:"+testKey(1), "k1")
+		testUser = &user.User{
+			UserID:    "test-user",
+			Email:     "test@example.com",
+			FirstName: "Test",
+			LastName:  "User",
+			UserName:  "test-user-name",
+			Password:  "hash",
+			Role:      "customer",
+		}
+	})
+
+	It("should encrypt the configured fields", func() {
+		encrypted, err := codec.EncryptUser(testUser)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(encrypted.UserID).To(Equal(testUser.UserID))
+		Expect(encrypted.Password).To(Equal(testUser.Password))
+		Expect(encrypted.Role).To(Equal(testUser.Role))
+		Expect(IsEncrypted(encrypted.Email)).To(BeTrue())
+		Expect(IsEncrypted(encrypted.FirstName)).To(BeTrue())
+		// The user itself is not changed
+		Expect(testUser.Email).To(Equal("test@example.com"))
+
+		err = codec.DecryptUser(encrypted)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(encrypted).To(Equal(testUser))
+	})
+
+	It("should encrypt deterministic fields equally", func() {
+		first, err := codec.EncryptUser(testUser)
+		Expect(err).ToNot(HaveOccurred())
+		second, err := codec.EncryptUser(testUser)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(first.Email).To(Equal(second.Email))
+		Expect(first.FirstName).ToNot(Equal(second.FirstName))
+	})
+
+	It("should match deterministic fields in filters", func() {
+		encrypted, err := codec.EncryptUser(testUser)
+		Expect(err).ToNot(HaveOccurred())
+
+		filter, err := codec.Filter(map[string]interface{}{
+			"$or": []user.User{
+				user.User{UserID: "test-user"},
+				user.User{UserName: "test-user-name"},
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		conditions := filter.(map[string]interface{})["$or"].([]interface{})
+		Expect(conditions[0]).To(Equal(map[string]interface{}{
+			"userID": "test-user",
+		}))
+		Expect(conditions[1]).To(Equal(map[string]interface{}{
+			"userName": map[string]interface{}{
+				"$in": []interface{}{"test-user-name", encrypted.UserName},
+			},
+		}))
+	})
+
+	It("should return error when filtering on randomized fields", func() {
+		_, err := codec.Filter(&user.User{FirstName: "Test"})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should decrypt fields encrypted with previous keys after rotation", func() {
+		encrypted, err := codec.EncryptUser(testUser)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(codec.Stale(encrypted)).To(BeFalse())
+		Expect(codec.Stale(testUser)).To(BeTrue())
+
+		rotated := newTestCodec("k1:"+testKey(1)+",k2:"+testKey(2), "k2")
+		Expect(rotated.Stale(encrypted)).To(BeTrue())
+		decrypted := *encrypted
+		err = rotated.DecryptUser(&decrypted)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(&decrypted).To(Equal(testUser))
+
+		reencrypted, err := rotated.EncryptUser(&decrypted)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rotated.Stale(reencrypted)).To(BeFalse())
+	})
+
+	It("should encrypt updates", func() {
+		update, err := codec.EncryptUpdate(map[string]interface{}{
+			"userID":   "test-user",
+			"lastName": "Other",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(update["userID"]).To(Equal("test-user"))
+		Expect(IsEncrypted(update["lastName"].(string))).To(BeTrue())
+	})
+
+	It("should leave users as they are if nil", func() {
+		var nilCodec *Codec
+		encrypted, err := nilCodec.EncryptUser(testUser)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(encrypted).To(Equal(testUser))
+		filter, err := nilCodec.Filter(testUser)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(filter).To(Equal(testUser))
+	})
+
+	It("should validate its config", func() {
+		_, err := NewCodec(&Config{Keys: "k1:" + testKey(1), ActiveKeyID: "k2"})
+		Expect(err).To(HaveOccurred())
+		_, err = NewCodec(&Config{Keys: "k1:c2hvcnQ=", ActiveKeyID: "k1"})
+		Expect(err).To(HaveOccurred())
+		_, err = NewCodec(&Config{
+			Keys:             "k1:" + testKey(1),
+			ActiveKeyID:      "k1",
+			RandomizedFields: []string{"userName"},
+		})
+		Expect(err).To(HaveOccurred())
+		_, err = NewCodec(&Config{
+			Keys:                "k1:" + testKey(1),
+			ActiveKeyID:         "k1",
+			DeterministicFields: []string{"password"},
+		})
+		Expect(err).To(HaveOccurred())
+	})
+})
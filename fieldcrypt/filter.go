@@ -0,0 +1,79 @@
+package fieldcrypt
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// Filter returns the Mongo-filter matching the users with the encrypted
+// fields. Deterministic fields match their value encrypted with each key,
+// and their unencrypted value, so users are found while keys are rotated.
+// Randomized fields cannot be matched, and return an error.
+//
+// The filter is converted to a map, such as from a user.User.
+func (c *Codec) Filter(filter interface{}) (interface{}, error) {
+	if c == nil || filter == nil {
+		return filter, nil
+	}
+	data, err := json.Marshal(filter)
+	if err != nil {
+		err = errors.Wrap(err, "Error marshalling filter")
+		return nil, err
+	}
+	filterMap := map[string]interface{}{}
+	err = json.Unmarshal(data, &filterMap)
+	if err != nil {
+		err = errors.Wrap(err, "Error unmarshalling filter")
+		return nil, err
+	}
+	err = c.filterMap(filterMap)
+	if err != nil {
+		return nil, err
+	}
+	return filterMap, nil
+}
+
+func (c *Codec) filterMap(filter map[string]interface{}) error {
+	for field, value := range filter {
+		switch field {
+		case "$and", "$or", "$nor":
+			conditions, ok := value.([]interface{})
+			if !ok {
+				return errors.Errorf("%s must be an array", field)
+			}
+			for _, condition := range conditions {
+				conditionMap, ok := condition.(map[string]interface{})
+				if !ok {
+					return errors.Errorf("%s must contain objects", field)
+				}
+				err := c.filterMap(conditionMap)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		mode, ok := c.modes[field]
+		if !ok {
+			continue
+		}
+		str, ok := value.(string)
+		if mode == Randomized || !ok {
+			return errors.Errorf("field %s is encrypted, and cannot be filtered on", field)
+		}
+		matches := []interface{}{str}
+		for _, id := range c.keyIDs {
+			encrypted, err := c.keys[id].encrypt(field, Deterministic, str)
+			if err != nil {
+				return err
+			}
+			matches = append(matches, encrypted)
+		}
+		filter[field] = map[string]interface{}{
+			"$in": matches,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+// Package health serves the liveness (/healthz) and readiness (/readyz)
+// endpoints of the service, each backed by a set of named checks.
+package health
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/TerrexTech/agg-userauth-cmd/logger"
+	"github.com/pkg/errors"
+)
+
+// Check reports the state of a dependency. The detail is included in the
+// check-result, and a non-nil error marks the dependency as unhealthy.
+type Check func() (detail string, err error)
+
+// Status values for checks and endpoints.
+const (
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+)
+
+// CheckResult is the result of a single Check.
+type CheckResult struct {
+	Status     string `json:"status"`
+	Detail     string `json:"detail,omitempty"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// Report is the response-body of the health-endpoints.
+type Report struct {
+	Status string                  `json:"status"`
+	Checks map[string]*CheckResult `json:"checks"`
+}
+
+// Checker runs the registered liveness and readiness checks.
+type Checker struct {
+	// Timeout for each check. A check that does not complete
+	// in time is reported as unavailable.
+	Timeout time.Duration
+
+	mu        sync.RWMutex
+	liveness  map[string]Check
+	readiness map[string]Check
+}
+
+// NewChecker creates a Checker with no checks.
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{
+		Timeout:   timeout,
+		liveness:  map[string]Check{},
+		readiness: map[string]Check{},
+	}
+}
+
+// AddLiveness registers a check that decides if the service must be restarted.
+// Liveness checks are also part of readiness.
+func (c *Checker) AddLiveness(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.liveness[name] = check
+}
+
+// AddReadiness registers a check that decides if the service can do work.
+func (c *Checker) AddReadiness(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readiness[name] = check
+}
+
+// Live runs the liveness checks.
+func (c *Checker) Live() *Report {
+	c.mu.RLock()
+	checks := copyChecks(c.liveness)
+	c.mu.RUnlock()
+
+	return c.run(checks)
+}
+
+// Ready runs the liveness and readiness checks.
+func (c *Checker) Ready() *Report {
+	c.mu.RLock()
+	checks := copyChecks(c.liveness, c.readiness)
+	c.mu.RUnlock()
+
+	return c.run(checks)
+}
+
+// Handler returns an http.Handler serving /healthz and /readyz.
+func (c *Checker) Handler() http.Handler {
+	mux := http.NewServeMux()
+	c.Register(mux)
+	return mux
+}
+
+// Register adds the /healthz and /readyz endpoints to the mux.
+func (c *Checker) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, c.Live())
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, c.Ready())
+	})
+}
+
+func (c *Checker) run(checks map[string]Check) *Report {
+	report := &Report{
+		Status: StatusOK,
+		Checks: map[string]*CheckResult{},
+	}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+			result := c.runCheck(check)
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[name] = result
+			if result.Status != StatusOK {
+				report.Status = StatusUnavailable
+			}
+		}(name, check)
+	}
+	wg.Wait()
+
+	return report
+}
+
+func (c *Checker) runCheck(check Check) *CheckResult {
+	type checkOutput struct {
+		detail string
+		err    error
+	}
+	// Buffered so a timed-out check does not leak its goroutine forever
+	outChan := make(chan checkOutput, 1)
+	start := time.Now()
+	go func() {
+		detail, err := check()
+		outChan <- checkOutput{detail, err}
+	}()
+
+	var timeout <-chan time.Time
+	if c.Timeout > 0 {
+		timeout = time.After(c.Timeout)
+	}
+
+	var out checkOutput
+	select {
+	case out = <-outChan:
+	case <-timeout:
+		out.err = errors.Errorf("check timed out after %s", c.Timeout)
+	}
+
+	result := &CheckResult{
+		Status:     StatusOK,
+		Detail:     out.detail,
+		DurationMs: time.Since(start).Nanoseconds() / int64(time.Millisecond),
+	}
+	if out.err != nil {
+		result.Status = StatusUnavailable
+		result.Error = out.err.Error()
+	}
+	return result
+}
+
+func copyChecks(checkMaps ...map[string]Check) map[string]Check {
+	checks := map[string]Check{}
+	for _, m := range checkMaps {
+		for name, check := range m {
+			checks[name] = check
+		}
+	}
+	return checks
+}
+
+func writeReport(w http.ResponseWriter, report *Report) {
+	// Sorted check-names make failures easier to read in logs
+	failed := []string{}
+	for name, result := range report.Checks {
+		if result.Status != StatusOK {
+			failed = append(failed, name)
+		}
+	}
+	sort.Strings(failed)
+	if len(failed) > 0 {
+		logger.DefaultLogger().Warnf("Health: failing checks: %v", failed)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status == StatusOK {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	err := json.NewEncoder(w).Encode(report)
+	if err != nil {
+		err = errors.Wrap(err, "Error writing health-report")
+		log.Println(err)
+	}
+}
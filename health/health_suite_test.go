@@ -0,0 +1,88 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// TestHealth tests the health-endpoints.
+func TestHealth(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Health Suite")
+}
+
+func getReport(handler http.Handler, path string) (int, *Report) {
+	req := httptest.NewRequest("GET", path, nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	report := &Report{}
+	err := json.Unmarshal(rec.Body.Bytes(), report)
+	Expect(err).ToNot(HaveOccurred())
+	return rec.Code, report
+}
+
+var _ = Describe("Checker", func() {
+	var checker *Checker
+
+	BeforeEach(func() {
+		checker = NewChecker(100 * time.Millisecond)
+		checker.AddLiveness("live", func() (string, error) {
+			return "running", nil
+		})
+	})
+
+	It("should report ok if all checks pass", func() {
+		checker.AddReadiness("dep", func() (string, error) {
+			return "reachable", nil
+		})
+
+		code, report := getReport(checker.Handler(), "/readyz")
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(report.Status).To(Equal(StatusOK))
+		Expect(report.Checks).To(HaveKey("live"))
+		Expect(report.Checks).To(HaveKey("dep"))
+		Expect(report.Checks["dep"].Detail).To(Equal("reachable"))
+	})
+
+	It("should report unavailable with error if a readiness check fails", func() {
+		checker.AddReadiness("dep", func() (string, error) {
+			return "", errors.New("dep down")
+		})
+
+		code, report := getReport(checker.Handler(), "/readyz")
+		Expect(code).To(Equal(http.StatusServiceUnavailable))
+		Expect(report.Status).To(Equal(StatusUnavailable))
+		Expect(report.Checks["dep"].Status).To(Equal(StatusUnavailable))
+		Expect(report.Checks["dep"].Error).To(Equal("dep down"))
+		Expect(report.Checks["live"].Status).To(Equal(StatusOK))
+	})
+
+	It("should not run readiness checks for liveness", func() {
+		checker.AddReadiness("dep", func() (string, error) {
+			return "", errors.New("dep down")
+		})
+
+		code, report := getReport(checker.Handler(), "/healthz")
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(report.Checks).ToNot(HaveKey("dep"))
+	})
+
+	It("should report checks that time out as unavailable", func() {
+		checker.AddReadiness("slow", func() (string, error) {
+			time.Sleep(time.Second)
+			return "", nil
+		})
+
+		code, report := getReport(checker.Handler(), "/readyz")
+		Expect(code).To(Equal(http.StatusServiceUnavailable))
+		Expect(report.Checks["slow"].Error).To(ContainSubstring("timed out"))
+	})
+})
@@ -0,0 +1,272 @@
+// Package history keeps the Events applied to each user, for exporting the
+// data held on users, such as for data-subject access requests.
+//
+// Events are recorded by domain.BuildState as they are applied. The Events
+// applied before the collection was configured are recorded once by
+// domain.BackfillHistory, which tracks its progress using Store.Backfill.
+// Secrets, such as password-hashes, are redacted before Events are recorded.
+package history
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/TerrexTech/agg-userauth-cmd/util"
+	"github.com/TerrexTech/go-common-models/model"
+	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/pkg/errors"
+)
+
+// secretFields are the lowercase fields removed from Event-data by Redact.
+var secretFields = map[string]bool{
+	"password":     true,
+	"passwordhash": true,
+}
+
+// Entry is an Event applied to a user.
+type Entry struct {
+	UserID    string `bson:"userID,omitempty" json:"-"`
+	EventUUID string `bson:"eventUUID,omitempty" json:"eventUUID"`
+	Action    string `bson:"action,omitempty" json:"action"`
+	Version   int64  `bson:"version,omitempty" json:"version,omitempty"`
+	NanoTime  int64  `bson:"nanoTime,omitempty" json:"nanoTime"`
+	// Data is the redacted Event-data.
+	Data string `bson:"data,omitempty" json:"-"`
+}
+
+// StoreConfig is the config for Store.
+type StoreConfig struct {
+	Conn       *mongo.ConnectionConfig
+	Database   string
+	Collection string
+}
+
+// Store holds the Entries of users in a Mongo-collection.
+type Store struct {
+	coll *mongo.Collection
+}
+
+// NewStore creates the collection (if required) and returns a Store backed
+// by it.
+func NewStore(config *StoreConfig) (*Store, error) {
+	if config == nil {
+		return nil, errors.New("config cannot be nil")
+	}
+	if config.Conn == nil {
+		return nil, errors.New("Conn cannot be nil")
+	}
+	if config.Database == "" {
+		return nil, errors.New("Database cannot be blank")
+	}
+	if config.Collection == "" {
+		return nil, errors.New("Collection cannot be blank")
+	}
+
+	coll, err := util.EnsureCollection(&mongo.Collection{
+		Connection:   config.Conn,
+		Database:     config.Database,
+		Name:         config.Collection,
+		SchemaStruct: &Entry{},
+		Indexes: []mongo.IndexConfig{
+			mongo.IndexConfig{
+				ColumnConfig: []mongo.IndexColumnConfig{
+					mongo.IndexColumnConfig{
+						Name: "userID",
+					},
+					mongo.IndexColumnConfig{
+						Name: "eventUUID",
+					},
+				},
+				IsUnique: true,
+				Name:     "userID_eventUUID_index",
+			},
+		},
+	})
+	if err != nil {
+		err = errors.Wrap(err, "Error creating History-collection")
+		return nil, err
+	}
+	return &Store{
+		coll: coll,
+	}, nil
+}
+
+// Record records the Event for the user, with the user's part of the
+// Event-data. Events which were already recorded, such as when replaying
+// Events, are replaced.
+func (s *Store) Record(userID string, event *model.Event, data []byte) error {
+	data, err := Redact(data)
+	if err != nil {
+		err = errors.Wrap(err, "Error redacting Event-data")
+		return err
+	}
+	eventUUID := event.UUID.String()
+
+	_, err = s.coll.DeleteMany(map[string]interface{}{
+		"userID":    userID,
+		"eventUUID": eventUUID,
+	})
+	if err != nil {
+		err = errors.Wrap(err, "Error deleting history-entry")
+		return err
+	}
+	_, err = s.coll.InsertOne(&Entry{
+		UserID:    userID,
+		EventUUID: eventUUID,
+		Action:    event.Action,
+		Version:   event.Version,
+		NanoTime:  event.NanoTime,
+		Data:      string(data),
+	})
+	if err != nil {
+		err = errors.Wrap(err, "Error inserting history-entry")
+		return err
+	}
+	return nil
+}
+
+// Remove removes the Entries of the user, such as when the user is erased.
+func (s *Store) Remove(userID string) error {
+	_, err := s.coll.DeleteMany(map[string]interface{}{
+		"userID": userID,
+	})
+	if err != nil {
+		err = errors.Wrap(err, "Error deleting history-entries")
+		return err
+	}
+	return nil
+}
+
+// Entries returns the Entries of the user, in the order the Events
+// were produced.
+func (s *Store) Entries(userID string) ([]*Entry, error) {
+	results, err := s.coll.Find(map[string]interface{}{
+		"userID": userID,
+	})
+	if err != nil {
+		err = errors.Wrap(err, "Error finding history-entries")
+		return nil, err
+	}
+
+	entries := make([]*Entry, 0, len(results))
+	for _, result := range results {
+		entry, assertOK := result.(*Entry)
+		if !assertOK {
+			err = errors.New("error asserting find-result to Entry")
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].NanoTime < entries[j].NanoTime
+	})
+	return entries, nil
+}
+
+// backfillID is the UserID and EventUUID of the Entry recording the
+// Backfill, which is not the UserID of any user since UserIDs are UUIDs.
+const backfillID = "backfill"
+
+// Backfill is the progress of recording the Events applied before the
+// History was configured.
+type Backfill struct {
+	// Started is true once the backfill was started.
+	Started bool
+	// Complete is true once the backfill was completed.
+	Complete bool
+	// Version is the Aggregate-version when the backfill was started, so
+	// Events up to it were already applied to the Aggregate.
+	Version int64
+}
+
+// Backfill returns the progress of the backfill.
+func (s *Store) Backfill() (*Backfill, error) {
+	results, err := s.coll.Find(map[string]interface{}{
+		"userID":    backfillID,
+		"eventUUID": backfillID,
+	})
+	if err != nil {
+		err = errors.Wrap(err, "Error finding backfill-entry")
+		return nil, err
+	}
+	if len(results) == 0 {
+		return &Backfill{}, nil
+	}
+	entry, assertOK := results[0].(*Entry)
+	if !assertOK {
+		err = errors.New("error asserting find-result to Entry")
+		return nil, err
+	}
+	return &Backfill{
+		Started:  true,
+		Complete: entry.Action == "BackfillCompleted",
+		Version:  entry.Version,
+	}, nil
+}
+
+// StartBackfill records that the backfill was started at the
+// Aggregate-version.
+func (s *Store) StartBackfill(version int64) error {
+	return s.putBackfill("BackfillStarted", version)
+}
+
+// CompleteBackfill records that the backfill was completed.
+func (s *Store) CompleteBackfill(version int64) error {
+	return s.putBackfill("BackfillCompleted", version)
+}
+
+func (s *Store) putBackfill(action string, version int64) error {
+	_, err := s.coll.DeleteMany(map[string]interface{}{
+		"userID":    backfillID,
+		"eventUUID": backfillID,
+	})
+	if err != nil {
+		err = errors.Wrap(err, "Error deleting backfill-entry")
+		return err
+	}
+	_, err = s.coll.InsertOne(&Entry{
+		UserID:    backfillID,
+		EventUUID: backfillID,
+		Action:    action,
+		Version:   version,
+	})
+	if err != nil {
+		err = errors.Wrap(err, "Error inserting backfill-entry")
+		return err
+	}
+	return nil
+}
+
+// Redact removes the secretFields from the JSON-data, including from
+// nested objects, such as the users of UsersImported Events.
+func Redact(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+	var value interface{}
+	err := json.Unmarshal(data, &value)
+	if err != nil {
+		err = errors.Wrap(err, "Error unmarshalling data")
+		return nil, err
+	}
+	return json.Marshal(redact(value))
+}
+
+func redact(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if secretFields[strings.ToLower(key)] {
+				delete(v, key)
+				continue
+			}
+			v[key] = redact(field)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redact(item)
+		}
+	}
+	return value
+}
@@ -0,0 +1,50 @@
+package history
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// TestHistory tests the Event-history of users.
+func TestHistory(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "History Suite")
+}
+
+var _ = Describe("Redact", func() {
+	It("should remove passwords from Event-data", func() {
+		data := []byte(`{"userID": "test-user", "Password": "hash"}`)
+		redacted, err := Redact(data)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(redacted).To(MatchJSON(`{"userID": "test-user"}`))
+	})
+
+	It("should remove password-hashes of credential-Events", func() {
+		data := []byte(`{"userID": "test-user", "passwordHash": "hash", "changedAt": 1}`)
+		redacted, err := Redact(data)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(redacted).To(MatchJSON(`{"userID": "test-user", "changedAt": 1}`))
+	})
+
+	It("should remove passwords from nested objects", func() {
+		data := []byte(`{
+			"filter": {"userID": "test-user"},
+			"update": {"userID": "test-user", "password": "hash"},
+			"users": [{"userID": "other-user", "password": "hash"}]
+		}`)
+		redacted, err := Redact(data)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(redacted).To(MatchJSON(`{
+			"filter": {"userID": "test-user"},
+			"update": {"userID": "test-user"},
+			"users": [{"userID": "other-user"}]
+		}`))
+	})
+
+	It("should return error on invalid data", func() {
+		_, err := Redact([]byte("{invalid"))
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,150 @@
+// Package importer imports users in bulk, such as when migrating users from
+// legacy systems.
+//
+// Users are read from CSV or JSONL-files, and sent in Batches using
+// ImportUsers-commands. Each Batch results in a UsersImported Event (followed
+// by a CredentialSet Event for each imported user), and the Progress of each
+// import is a projection updated when the Events are applied, so an
+// interrupted import is resumed from the next Batch.
+package importer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/TerrexTech/agg-userauth-model/user"
+	"github.com/pkg/errors"
+)
+
+// Formats of import-files.
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+)
+
+// Batch is the data of ImportUsers-commands.
+type Batch struct {
+	ImportID string `json:"importID,omitempty"`
+	// Offset is the position of the Batch's first user in the import, and
+	// must be the NextOffset of the import's Progress.
+	Offset int          `json:"offset"`
+	Users  []*user.User `json:"users,omitempty"`
+}
+
+// RowError is the error for a user that could not be imported.
+type RowError struct {
+	// Offset is the position of the user in the import.
+	Offset int    `json:"offset"`
+	Error  string `json:"error"`
+}
+
+// BatchResult is the result of ImportUsers-commands.
+type BatchResult struct {
+	ImportID   string      `json:"importID"`
+	Offset     int         `json:"offset"`
+	NextOffset int         `json:"nextOffset"`
+	Imported   int         `json:"imported"`
+	Errors     []*RowError `json:"errors,omitempty"`
+}
+
+// ImportedBatch is the data of UsersImported Events. Users only contains the
+// valid users of the Batch, without their passwords.
+type ImportedBatch struct {
+	ImportID   string       `json:"importID"`
+	Offset     int          `json:"offset"`
+	NextOffset int          `json:"nextOffset"`
+	Users      []*user.User `json:"users"`
+}
+
+// ReadUsers reads the users from a CSV or JSONL-file. CSV-files have a
+// header-row naming the user-field of each column, such as "userName".
+func ReadUsers(r io.Reader, format string) ([]*user.User, error) {
+	switch format {
+	case FormatCSV:
+		return readCSV(r)
+	case FormatJSONL:
+		return readJSONL(r)
+	}
+	return nil, errors.Errorf("unsupported import-format %q", format)
+}
+
+func readCSV(r io.Reader) ([]*user.User, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		err = errors.Wrap(err, "Error reading CSV-header")
+		return nil, err
+	}
+	fields := make([]string, len(header))
+	for i, h := range header {
+		field, ok := csvFields[strings.ToLower(strings.TrimSpace(h))]
+		if !ok {
+			return nil, errors.Errorf("unknown CSV-column %q", h)
+		}
+		fields[i] = field
+	}
+
+	users := []*user.User{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return users, nil
+		}
+		if err != nil {
+			err = errors.Wrap(err, "Error reading CSV-row")
+			return nil, err
+		}
+		userMap := map[string]string{}
+		for i, value := range record {
+			userMap[fields[i]] = value
+		}
+		u := &user.User{}
+		// The map only holds string-fields, so it always converts to a User
+		data, _ := json.Marshal(userMap)
+		_ = json.Unmarshal(data, u)
+		users = append(users, u)
+	}
+}
+
+// csvFields are the user-fields by their lowercase CSV-column.
+var csvFields = map[string]string{
+	"userid":    "userID",
+	"email":     "email",
+	"firstname": "firstName",
+	"lastname":  "lastName",
+	"username":  "userName",
+	"password":  "password",
+	"role":      "role",
+}
+
+func readJSONL(r io.Reader) ([]*user.User, error) {
+	users := []*user.User{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		u := &user.User{}
+		err := json.Unmarshal([]byte(text), u)
+		if err != nil {
+			err = errors.Wrapf(err, "Error parsing JSONL-line %d", line)
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	err := scanner.Err()
+	if err != nil {
+		err = errors.Wrap(err, "Error reading JSONL")
+		return nil, err
+	}
+	return users, nil
+}
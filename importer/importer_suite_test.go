@@ -0,0 +1,71 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/TerrexTech/agg-userauth-model/user"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// TestImporter tests reading import-files.
+func TestImporter(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Importer Suite")
+}
+
+var _ = Describe("ReadUsers", func() {
+	It("should read CSV-files by their header-row", func() {
+		data := "UserName, email,Password,role\n" +
+			"test-user,test@example.com,$2a$10$abcdefghijklmnopqrstuv,customer\n" +
+			"other-user,other@example.com,secret,\n"
+
+		users, err := ReadUsers(strings.NewReader(data), FormatCSV)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(users).To(Equal([]*user.User{
+			&user.User{
+				UserName: "test-user",
+				Email:    "test@example.com",
+				Password: "$2a$10$abcdefghijklmnopqrstuv",
+				Role:     "customer",
+			},
+			&user.User{
+				UserName: "other-user",
+				Email:    "other@example.com",
+				Password: "secret",
+			},
+		}))
+	})
+
+	It("should return error on unknown CSV-columns", func() {
+		data := "userName,nickname\ntest-user,test\n"
+		_, err := ReadUsers(strings.NewReader(data), FormatCSV)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("nickname"))
+	})
+
+	It("should read JSONL-files, skipping blank lines", func() {
+		data := `{"userName": "test-user", "email": "test@example.com"}
+
+{"userName": "other-user", "firstName": "Other"}
+`
+		users, err := ReadUsers(strings.NewReader(data), FormatJSONL)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(users).To(HaveLen(2))
+		Expect(users[0].Email).To(Equal("test@example.com"))
+		Expect(users[1].FirstName).To(Equal("Other"))
+	})
+
+	It("should report the line of invalid JSONL", func() {
+		data := "{\"userName\": \"test-user\"}\n{invalid\n"
+		_, err := ReadUsers(strings.NewReader(data), FormatJSONL)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("line 2"))
+	})
+
+	It("should return error on unsupported formats", func() {
+		_, err := ReadUsers(strings.NewReader(""), "xml")
+		Expect(err).To(HaveOccurred())
+	})
+})
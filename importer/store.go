@@ -0,0 +1,122 @@
+package importer
+
+import (
+	"github.com/TerrexTech/agg-userauth-cmd/util"
+	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/pkg/errors"
+)
+
+// Progress is the progress of an import.
+type Progress struct {
+	ImportID string `bson:"importID,omitempty" json:"importID,omitempty"`
+	// NextOffset is the Offset of the next Batch.
+	NextOffset int `bson:"nextOffset" json:"nextOffset"`
+	// Imported is the number of users imported.
+	Imported int `bson:"imported" json:"imported"`
+}
+
+// Store holds the Progress of imports in a Mongo-collection.
+type Store struct {
+	coll *mongo.Collection
+}
+
+// NewStore creates the collection (if required) and returns a Store backed
+// by it.
+func NewStore(conn *mongo.ConnectionConfig, db string, collName string) (*Store, error) {
+	if conn == nil {
+		return nil, errors.New("conn cannot be nil")
+	}
+	if db == "" {
+		return nil, errors.New("db cannot be blank")
+	}
+	if collName == "" {
+		return nil, errors.New("collName cannot be blank")
+	}
+
+	coll, err := util.EnsureCollection(&mongo.Collection{
+		Connection:   conn,
+		Database:     db,
+		Name:         collName,
+		SchemaStruct: &Progress{},
+		Indexes: []mongo.IndexConfig{
+			mongo.IndexConfig{
+				ColumnConfig: []mongo.IndexColumnConfig{
+					mongo.IndexColumnConfig{
+						Name: "importID",
+					},
+				},
+				IsUnique: true,
+				Name:     "importID_index",
+			},
+		},
+	})
+	if err != nil {
+		err = errors.Wrap(err, "Error creating Imports-collection")
+		return nil, err
+	}
+	return &Store{
+		coll: coll,
+	}, nil
+}
+
+// Progress returns the Progress of the import, which is at the start
+// if the import has no imported Batches.
+func (s *Store) Progress(importID string) (*Progress, error) {
+	results, err := s.coll.Find(map[string]interface{}{
+		"importID": importID,
+	})
+	if err != nil {
+		err = errors.Wrap(err, "Error finding import-progress")
+		return nil, err
+	}
+	if len(results) == 0 {
+		return &Progress{
+			ImportID: importID,
+		}, nil
+	}
+	progress, assertOK := results[0].(*Progress)
+	if !assertOK {
+		err = errors.New("error asserting find-result to Progress")
+		return nil, err
+	}
+	return progress, nil
+}
+
+// Advance records the imported Batch in the import's Progress. Batches
+// which were already recorded are ignored.
+func (s *Store) Advance(batch *ImportedBatch) error {
+	progress, err := s.Progress(batch.ImportID)
+	if err != nil {
+		return err
+	}
+	if progress.NextOffset >= batch.NextOffset {
+		return nil
+	}
+
+	result, err := s.coll.UpdateMany(
+		map[string]interface{}{
+			"importID": batch.ImportID,
+		},
+		map[string]interface{}{
+			"nextOffset": batch.NextOffset,
+			"imported":   progress.Imported + len(batch.Users),
+		},
+	)
+	if err != nil {
+		err = errors.Wrap(err, "Error updating import-progress")
+		return err
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+	_, err = s.coll.InsertOne(&Progress{
+		ImportID:   batch.ImportID,
+		NextOffset: batch.NextOffset,
+		Imported:   len(batch.Users),
+	})
+	if err != nil {
+		err = errors.Wrap(err, "Error inserting import-progress")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+// Package lifecycle holds the users which are deactivated or deleted.
+//
+// Deleted users are kept as tombstones holding the deleted user, so they can
+// be restored using RestoreUser-commands within the grace-period, until they
+// are removed permanently using PurgeUser-commands. The records are a
+// projection, which is updated when the corresponding Events are applied by
+// domain.BuildState.
+package lifecycle
+
+import (
+	"time"
+
+	"github.com/TerrexTech/agg-userauth-cmd/util"
+	"github.com/TerrexTech/agg-userauth-model/user"
+	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/pkg/errors"
+)
+
+// Statuses of users with a Record. Users without a Record are active.
+const (
+	StatusDeactivated = "deactivated"
+	StatusDeleted     = "deleted"
+)
+
+// Record is the lifecycle-status of a user. Times are Unix-seconds.
+type Record struct {
+	UserID        string `bson:"userID,omitempty" json:"userID,omitempty"`
+	Status        string `bson:"status,omitempty" json:"status,omitempty"`
+	DeactivatedAt int64  `bson:"deactivatedAt,omitempty" json:"deactivatedAt,omitempty"`
+	DeactivatedBy string `bson:"deactivatedBy,omitempty" json:"deactivatedBy,omitempty"`
+	DeletedAt     int64  `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"`
+	DeletedBy     string `bson:"deletedBy,omitempty" json:"deletedBy,omitempty"`
+
+	// User is the deleted user, which is reinstated when restored.
+	User *user.User `bson:"user,omitempty" json:"-"`
+}
+
+// Restorable returns true if the Record is a tombstone which was deleted
+// within the grace-period.
+func (r *Record) Restorable(now time.Time, gracePeriod time.Duration) bool {
+	if r.Status != StatusDeleted || r.User == nil {
+		return false
+	}
+	return now.Sub(time.Unix(r.DeletedAt, 0)) <= gracePeriod
+}
+
+// StoreConfig is the config for the Store.
+type StoreConfig struct {
+	Conn       *mongo.ConnectionConfig
+	Database   string
+	Collection string
+}
+
+// Store holds the Records in a Mongo-collection.
+type Store struct {
+	coll *mongo.Collection
+}
+
+// NewStore creates the collection (if required) and returns a Store backed
+// by it.
+func NewStore(config *StoreConfig) (*Store, error) {
+	if config == nil {
+		return nil, errors.New("config cannot be nil")
+	}
+	if config.Conn == nil {
+		return nil, errors.New("Conn cannot be nil")
+	}
+	if config.Database == "" {
+		return nil, errors.New("Database cannot be blank")
+	}
+	if config.Collection == "" {
+		return nil, errors.New("Collection cannot be blank")
+	}
+
+	coll, err := util.EnsureCollection(&mongo.Collection{
+		Connection:   config.Conn,
+		Database:     config.Database,
+		Name:         config.Collection,
+		SchemaStruct: &Record{},
+		Indexes: []mongo.IndexConfig{
+			mongo.IndexConfig{
+				ColumnConfig: []mongo.IndexColumnConfig{
+					mongo.IndexColumnConfig{
+						Name: "userID",
+					},
+				},
+				IsUnique: true,
+				Name:     "userID_index",
+			},
+		},
+	})
+	if err != nil {
+		err = errors.Wrap(err, "Error creating Lifecycle-collection")
+		return nil, err
+	}
+	return &Store{
+		coll: coll,
+	}, nil
+}
+
+// Record returns the Record of the user, or nil if the user is active.
+func (s *Store) Record(userID string) (*Record, error) {
+	results, err := s.coll.Find(map[string]interface{}{
+		"userID": userID,
+	})
+	if err != nil {
+		err = errors.Wrap(err, "Error finding lifecycle-record")
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, nil
+	}
+	record, assertOK := results[0].(*Record)
+	if !assertOK {
+		err = errors.New("error asserting find-result to Record")
+		return nil, err
+	}
+	return record, nil
+}
+
+// Put replaces the Record of the user.
+func (s *Store) Put(record *Record) error {
+	err := s.Remove(record.UserID)
+	if err != nil {
+		return err
+	}
+	_, err = s.coll.InsertOne(record)
+	if err != nil {
+		err = errors.Wrap(err, "Error inserting lifecycle-record")
+		return err
+	}
+	return nil
+}
+
+// Remove removes the Record of the user, which makes the user active,
+// or removes its tombstone.
+func (s *Store) Remove(userID string) error {
+	_, err := s.coll.DeleteMany(map[string]interface{}{
+		"userID": userID,
+	})
+	if err != nil {
+		err = errors.Wrap(err, "Error deleting lifecycle-record")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package lifecycle
+
+import (
+	"testing"
+	"time"
+
+	"github.com/TerrexTech/agg-userauth-model/user"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// TestLifecycle tests the lifecycle-records.
+func TestLifecycle(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Lifecycle Suite")
+}
+
+var _ = Describe("Record", func() {
+	now := time.Now()
+	grace := 24 * time.Hour
+
+	It("should be restorable within the grace-period", func() {
+		record := &Record{
+			UserID:    "test-user",
+			Status:    StatusDeleted,
+			DeletedAt: now.Add(-time.Hour).Unix(),
+			User:      &user.User{UserID: "test-user"},
+		}
+		Expect(record.Restorable(now, grace)).To(BeTrue())
+
+		record.DeletedAt = now.Add(-grace - time.Hour).Unix()
+		Expect(record.Restorable(now, grace)).To(BeFalse())
+	})
+
+	It("should only restore tombstones", func() {
+		record := &Record{
+			UserID:        "test-user",
+			Status:        StatusDeactivated,
+			DeactivatedAt: now.Unix(),
+		}
+		Expect(record.Restorable(now, grace)).To(BeFalse())
+
+		record.Status = StatusDeleted
+		Expect(record.Restorable(now, grace)).To(BeFalse())
+	})
+})
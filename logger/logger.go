@@ -0,0 +1,282 @@
+// Package logger provides leveled, structured (JSON) logging. Records are
+// written to an output (stdout by default) and optionally to a Sink, such
+// as a Kafka-topic.
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TerrexTech/go-common-models/model"
+	"github.com/pkg/errors"
+)
+
+// Level is the severity of a log-record.
+type Level int
+
+// Log-levels, in increasing severity.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+// ParseLevel parses a level-name (case-insensitive). An empty name is
+// parsed as LevelInfo.
+func ParseLevel(name string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "debug":
+		return LevelDebug, nil
+	case "", "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return LevelInfo, errors.Errorf("unknown log-level: %s", name)
+}
+
+// Field-names used for Command-context.
+const (
+	FieldService       = "service"
+	FieldCommandUUID   = "commandUUID"
+	FieldAction        = "action"
+	FieldUserID        = "userID"
+	FieldCorrelationID = "correlationID"
+)
+
+// Fields are additional key-values included in log-records.
+type Fields map[string]interface{}
+
+// Sink receives each encoded log-record. Sinks must not block.
+type Sink func(record []byte)
+
+// Config is the configuration for Logger.
+type Config struct {
+	Service string
+	Level   Level
+	// Output defaults to os.Stdout.
+	Output io.Writer
+	// Sink is optional, and receives records in addition to Output.
+	Sink Sink
+}
+
+// Logger writes structured log-records. A nil *Logger logs using the
+// default Logger.
+type Logger struct {
+	out    *output
+	fields Fields
+}
+
+// output is shared by a Logger and all Loggers derived from it.
+type output struct {
+	mu      sync.Mutex
+	service string
+	level   Level
+	writer  io.Writer
+	sink    Sink
+}
+
+var std = New(&Config{
+	Level: LevelInfo,
+})
+
+// New creates a Logger.
+func New(config *Config) *Logger {
+	writer := config.Output
+	if writer == nil {
+		writer = os.Stdout
+	}
+	return &Logger{
+		out: &output{
+			service: config.Service,
+			level:   config.Level,
+			writer:  writer,
+			sink:    config.Sink,
+		},
+		fields: Fields{},
+	}
+}
+
+// DefaultLogger returns the default Logger.
+func DefaultLogger() *Logger {
+	return std
+}
+
+// SetDefault replaces the default Logger.
+func SetDefault(l *Logger) {
+	if l != nil {
+		std = l
+	}
+}
+
+// With returns a Logger that includes the fields in its records.
+func (l *Logger) With(fields Fields) *Logger {
+	if l == nil {
+		l = std
+	}
+	merged := make(Fields, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &Logger{
+		out:    l.out,
+		fields: merged,
+	}
+}
+
+// WithCommand returns a Logger that includes the UUID, Action and
+// CorrelationID of the Command in its records.
+func (l *Logger) WithCommand(cmd *model.Command) *Logger {
+	if cmd == nil {
+		return l.With(nil)
+	}
+	return l.With(Fields{
+		FieldCommandUUID:   cmd.UUID.String(),
+		FieldAction:        cmd.Action,
+		FieldCorrelationID: cmd.CorrelationID.String(),
+	})
+}
+
+// Enabled returns true if records of the level are logged.
+func (l *Logger) Enabled(level Level) bool {
+	if l == nil {
+		l = std
+	}
+	return level >= l.out.level
+}
+
+// Debugf logs a formatted message at LevelDebug.
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.log(LevelDebug, fmt.Sprintf(format, args...))
+}
+
+// Infof logs a formatted message at LevelInfo.
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.log(LevelInfo, fmt.Sprintf(format, args...))
+}
+
+// Warnf logs a formatted message at LevelWarn.
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.log(LevelWarn, fmt.Sprintf(format, args...))
+}
+
+// Errorf logs a formatted message at LevelError.
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.log(LevelError, fmt.Sprintf(format, args...))
+}
+
+// Error logs the error at LevelError.
+func (l *Logger) Error(err error) {
+	if err == nil {
+		return
+	}
+	l.log(LevelError, err.Error())
+}
+
+func (l *Logger) log(level Level, msg string) {
+	if l == nil {
+		l = std
+	}
+	if !l.Enabled(level) {
+		return
+	}
+
+	record := make(Fields, len(l.fields)+4)
+	for k, v := range l.fields {
+		record[k] = v
+	}
+	record["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	record["level"] = level.String()
+	record["msg"] = msg
+	if l.out.service != "" {
+		record[FieldService] = l.out.service
+	}
+
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		encoded, _ = json.Marshal(Fields{
+			"time":  record["time"],
+			"level": LevelError.String(),
+			"msg":   errors.Wrap(err, "Error encoding log-record").Error(),
+		})
+	}
+
+	l.out.mu.Lock()
+	l.out.writer.Write(append(encoded, '\n'))
+	l.out.mu.Unlock()
+	if l.out.sink != nil {
+		l.out.sink(encoded)
+	}
+}
+
+// Writer returns an io.Writer that logs each written line at the level.
+// This is intended for log.SetOutput, so the standard logger's output is
+// also structured.
+func (l *Logger) Writer(level Level) io.Writer {
+	return &lineWriter{
+		logger: l,
+		level:  level,
+	}
+}
+
+type lineWriter struct {
+	logger *Logger
+	level  Level
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	for _, line := range bytes.Split(bytes.TrimRight(p, "\n"), []byte("\n")) {
+		if len(line) > 0 {
+			w.logger.log(w.level, string(line))
+		}
+	}
+	return len(p), nil
+}
+
+type ctxKey struct{}
+
+// NewContext returns a context carrying the Logger.
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, ctxKey{}, l)
+}
+
+// FromContext returns the Logger carried by ctx, or the default Logger if
+// ctx (which can be nil) carries none.
+func FromContext(ctx context.Context) *Logger {
+	if ctx != nil {
+		if l, ok := ctx.Value(ctxKey{}).(*Logger); ok && l != nil {
+			return l
+		}
+	}
+	return std
+}
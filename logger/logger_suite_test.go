@@ -0,0 +1,140 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"testing"
+
+	"github.com/TerrexTech/go-common-models/model"
+	"github.com/TerrexTech/uuuid"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// TestLogger tests the structured logger.
+func TestLogger(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Logger Suite")
+}
+
+func readRecords(buf *bytes.Buffer) []map[string]interface{} {
+	records := []map[string]interface{}{}
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		record := map[string]interface{}{}
+		err := json.Unmarshal(line, &record)
+		Expect(err).ToNot(HaveOccurred())
+		records = append(records, record)
+	}
+	return records
+}
+
+var _ = Describe("Logger", func() {
+	var (
+		buf *bytes.Buffer
+		l   *Logger
+	)
+
+	BeforeEach(func() {
+		buf = &bytes.Buffer{}
+		l = New(&Config{
+			Service: "test-service",
+			Level:   LevelInfo,
+			Output:  buf,
+		})
+	})
+
+	It("should parse levels", func() {
+		level, err := ParseLevel("DEBUG")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(level).To(Equal(LevelDebug))
+
+		level, err = ParseLevel("")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(level).To(Equal(LevelInfo))
+
+		_, err = ParseLevel("verbose")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should write JSON-records with service and level", func() {
+		l.Infof("hello %s", "world")
+
+		records := readRecords(buf)
+		Expect(records).To(HaveLen(1))
+		Expect(records[0]["msg"]).To(Equal("hello world"))
+		Expect(records[0]["level"]).To(Equal("info"))
+		Expect(records[0][FieldService]).To(Equal("test-service"))
+		Expect(records[0]).To(HaveKey("time"))
+	})
+
+	It("should skip records below the configured level", func() {
+		l.Debugf("debug")
+		l.Warnf("warn")
+
+		records := readRecords(buf)
+		Expect(records).To(HaveLen(1))
+		Expect(records[0]["msg"]).To(Equal("warn"))
+	})
+
+	It("should include Command-fields", func() {
+		cmdUUID, err := uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+		cid, err := uuuid.NewV4()
+		Expect(err).ToNot(HaveOccurred())
+
+		cmdLog := l.WithCommand(&model.Command{
+			Action:        "RegisterUser",
+			CorrelationID: cid,
+			UUID:          cmdUUID,
+		}).With(Fields{
+			FieldUserID: "test-user",
+		})
+		cmdLog.Infof("handled")
+		// Fields do not leak into the parent Logger
+		l.Infof("parent")
+
+		records := readRecords(buf)
+		Expect(records).To(HaveLen(2))
+		Expect(records[0][FieldCommandUUID]).To(Equal(cmdUUID.String()))
+		Expect(records[0][FieldCorrelationID]).To(Equal(cid.String()))
+		Expect(records[0][FieldAction]).To(Equal("RegisterUser"))
+		Expect(records[0][FieldUserID]).To(Equal("test-user"))
+		Expect(records[1]).ToNot(HaveKey(FieldUserID))
+	})
+
+	It("should send records to the sink", func() {
+		sinkRecords := [][]byte{}
+		l = New(&Config{
+			Level:  LevelInfo,
+			Output: buf,
+			Sink: func(record []byte) {
+				sinkRecords = append(sinkRecords, record)
+			},
+		})
+		l.Infof("to sink")
+
+		Expect(sinkRecords).To(HaveLen(1))
+		Expect(string(sinkRecords[0])).To(ContainSubstring("to sink"))
+	})
+
+	It("should carry the Logger in context", func() {
+		ctx := NewContext(context.Background(), l)
+		Expect(FromContext(ctx)).To(Equal(l))
+		Expect(FromContext(nil)).To(Equal(DefaultLogger()))
+	})
+
+	It("should structure the standard logger's output", func() {
+		stdLog := log.New(l.Writer(LevelWarn), "", 0)
+		stdLog.Println("legacy")
+
+		records := readRecords(buf)
+		Expect(records).To(HaveLen(1))
+		Expect(records[0]["msg"]).To(Equal("legacy"))
+		Expect(records[0]["level"]).To(Equal("warn"))
+	})
+})
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/TerrexTech/agg-userauth-cmd/api"
+	"github.com/TerrexTech/go-common-models/model"
+	"github.com/pkg/errors"
+)
+
+// apiClient issues Commands using the HTTP Command-API, such as for the
+// import-users tool.
+type apiClient struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func newAPIClient(baseURL string, token string, timeout time.Duration) *apiClient {
+	return &apiClient{
+		url:   strings.TrimSuffix(baseURL, "/") + api.HTTPPath,
+		token: token,
+		client: &http.Client{
+			Timeout: timeout,
+		},
+	}
+}
+
+// execute issues the Command, and unmarshals its result into result.
+// Commands which fail are returned as errors.
+func (c *apiClient) execute(action string, data interface{}, result interface{}) error {
+	cmdData, err := json.Marshal(data)
+	if err != nil {
+		err = errors.Wrap(err, "Error marshalling command-data")
+		return err
+	}
+	body, err := json.Marshal(&api.Request{
+		Action: action,
+		Data:   cmdData,
+	})
+	if err != nil {
+		err = errors.Wrap(err, "Error marshalling Request")
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		err = errors.Wrap(err, "Error creating HTTP-request")
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		err = errors.Wrapf(err, "Error issuing %s-command", action)
+		return err
+	}
+	defer resp.Body.Close()
+
+	doc := &model.Document{}
+	err = json.NewDecoder(resp.Body).Decode(doc)
+	if err != nil {
+		err = errors.Wrapf(err, "Error decoding response (HTTP-status %d)", resp.StatusCode)
+		return err
+	}
+	if doc.Error != "" {
+		return errors.Errorf("%s-command failed (error-code %d): %s", action, doc.ErrorCode, doc.Error)
+	}
+	if result == nil {
+		return nil
+	}
+	err = json.Unmarshal(doc.Data, result)
+	if err != nil {
+		err = errors.Wrapf(err, "Error unmarshalling %s-result", action)
+		return err
+	}
+	return nil
+}
@@ -1,25 +1,59 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"sync"
 	"time"
 
+	"github.com/TerrexTech/agg-userauth-cmd/auth"
+	"github.com/TerrexTech/agg-userauth-cmd/command"
 	"github.com/TerrexTech/agg-userauth-cmd/domain"
-
-	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/TerrexTech/agg-userauth-cmd/logger"
+	"github.com/TerrexTech/agg-userauth-cmd/metrics"
+	"github.com/TerrexTech/agg-userauth-cmd/tracing"
 
 	"github.com/Shopify/sarama"
 	"github.com/TerrexTech/go-common-models/model"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// errRewind ends the consumer-session, so it is joined again from the last
+// committed offset.
+var errRewind = errors.New("consumer-session ended to rewind uncommitted Command")
+
 type cmdConsConfig struct {
-	collection        *mongo.Collection
+	projection        *domain.Projection
 	builderFunc       domain.BuilderFunc
 	builderTimeoutSec int
 
-	handle func(*model.Command)
+	// handle and process receive a context carrying the trace-context
+	// extracted from the Command's message-headers, and a Logger with
+	// the Command's fields.
+	handle func(context.Context, *model.Command)
+
+	// txnProd is optional. If set, Commands are processed one at a time using
+	// process, and their output is produced by txnProd in the same
+	// transaction that commits their consumer-offsets.
+	txnProd *txnProducer
+	process func(context.Context, *model.Command) ([]*command.EventMsg, *command.ResponseMsg)
+
+	// queues is optional. If set, consumption is paused while the
+	// producer-queues are filled above highWaterMark (a ratio from 0 to 1).
+	queues        *queueMonitor
+	highWaterMark float64
+
+	// status is optional, and tracks the consumer's health if set
+	status *consumerStatus
+
+	// lock is optional. If set, it is held from building the Aggregate-state
+	// until the Command's output is produced.
+	lock sync.Locker
+
+	// logger is optional, and defaults to logger.DefaultLogger()
+	logger *logger.Logger
 }
 
 // Handler for Consumer Messages
@@ -28,8 +62,8 @@ type cmdConsumer struct {
 }
 
 func newCmdConsumer(config cmdConsConfig) (*cmdConsumer, error) {
-	if config.collection == nil {
-		err := errors.New("collection cannot be nil")
+	if config.projection == nil {
+		err := errors.New("projection cannot be nil")
 		return nil, err
 	}
 	if config.builderFunc == nil {
@@ -44,19 +78,25 @@ func newCmdConsumer(config cmdConsConfig) (*cmdConsumer, error) {
 		err := errors.New("handle cannot be nil")
 		return nil, err
 	}
+	if config.txnProd != nil && config.process == nil {
+		err := errors.New("process cannot be nil when txnProd is set")
+		return nil, err
+	}
 
 	return &cmdConsumer{
 		config,
 	}, nil
 }
 
-func (*cmdConsumer) Setup(sarama.ConsumerGroupSession) error {
-	log.Println("Initializing Kafka CmdConsumer")
+func (m *cmdConsumer) Setup(sarama.ConsumerGroupSession) error {
+	m.logger.Infof("Initializing Kafka CmdConsumer")
+	m.status.setJoined(true)
 	return nil
 }
 
-func (*cmdConsumer) Cleanup(sarama.ConsumerGroupSession) error {
-	log.Println("Closing Kafka CmdConsumer")
+func (m *cmdConsumer) Cleanup(sarama.ConsumerGroupSession) error {
+	m.logger.Infof("Closing Kafka CmdConsumer")
+	m.status.setJoined(false)
 	return nil
 }
 
@@ -64,51 +104,175 @@ func (m *cmdConsumer) ConsumeClaim(
 	session sarama.ConsumerGroupSession,
 	claim sarama.ConsumerGroupClaim,
 ) error {
-	log.Println("Listening for Commands...")
+	m.logger.Infof("Listening for Commands...")
 
 	for msg := range claim.Messages() {
 		if msg == nil {
 			continue
 		}
+		// Unconsumed messages are left to Kafka while the producers catch up
+		m.queues.waitBelow(session.Context(), m.highWaterMark)
 
-		go func(session sarama.ConsumerGroupSession, msg *sarama.ConsumerMessage) {
-			session.MarkMessage(msg, "")
-
-			cmd := &model.Command{}
-			err := json.Unmarshal(msg.Value, cmd)
+		if m.txnProd != nil {
+			err := m.consumeTxn(msg)
 			if err != nil {
-				err = errors.Wrap(err, "Error unmarshalling to Command")
-				log.Println(err)
-				return
+				// Ending the session rewinds the claim to the last committed
+				// offset, so the uncommitted message is consumed again.
+				return errRewind
 			}
-			log.Printf("Received Command with ID: %s", cmd.UUID)
+			continue
+		}
 
-			if cmd.ResponseTopic == "" {
-				log.Println("Command contains empty ResponseTopic")
-				return
-			}
-			if cmd.Action == "" {
-				log.Println("Comman contains empty Action")
-				return
-			}
+		// Commands are handled one at a time in the order they were consumed,
+		// so the queue-check above pauses consumption while producers lag.
+		// The message is only marked once its Command was handled, so its
+		// offset is not committed while it waits for the lock.
+		m.consume(msg)
+		session.MarkMessage(msg, "")
+	}
+	return errors.New("context-closed")
+}
 
-			ttlSec := time.Duration(cmd.TTLSec) * time.Second
-			expTime := time.Unix(cmd.Timestamp, 0).Add(ttlSec).UTC()
-			curTime := time.Now().UTC()
-			if expTime.Before(curTime) {
-				log.Printf("Command expired, ignoring")
-				return
-			}
+// consume handles the Command in the message once the Aggregate-state is
+// built. Messages that cannot be parsed are skipped.
+func (m *cmdConsumer) consume(msg *sarama.ConsumerMessage) {
+	ctx, span := m.consumeContext(msg)
+	defer span.End()
 
-			err = domain.BuildState(m.collection, m.builderFunc, m.builderTimeoutSec)
-			if err != nil {
-				err = errors.Wrap(err, "Error building Aggregate-state")
-				log.Println(err)
-				return
-			}
+	ctx, cmd := parseCommand(ctx, msg)
+	if cmd == nil {
+		return
+	}
 
-			m.handle(cmd)
-		}(session, msg)
+	unlock := m.acquire()
+	defer unlock()
+
+	err := domain.BuildState(ctx, m.projection, m.builderFunc, m.builderTimeoutSec)
+	m.status.buildResult(err)
+	if err != nil {
+		err = errors.Wrap(err, "Error building Aggregate-state")
+		logger.FromContext(ctx).Error(err)
+		return
 	}
-	return errors.New("context-closed")
+
+	m.handle(ctx, cmd)
+}
+
+// consumeTxn processes the message and commits its output and offset in one
+// transaction. Messages that cannot be parsed are still committed, so they
+// are not consumed again. An error is returned, and nothing is committed, if
+// the Aggregate-state cannot be built or the transaction fails.
+func (m *cmdConsumer) consumeTxn(msg *sarama.ConsumerMessage) error {
+	var (
+		eventMsgs []*command.EventMsg
+		respMsg   *command.ResponseMsg
+	)
+
+	ctx, span := m.consumeContext(msg)
+	defer span.End()
+
+	ctx, cmd := parseCommand(ctx, msg)
+	if cmd != nil {
+		unlock := m.acquire()
+		defer unlock()
+
+		err := domain.BuildState(ctx, m.projection, m.builderFunc, m.builderTimeoutSec)
+		m.status.buildResult(err)
+		if err != nil {
+			err = errors.Wrap(err, "Error building Aggregate-state")
+			logger.FromContext(ctx).Error(err)
+			return err
+		}
+		eventMsgs, respMsg = m.process(ctx, cmd)
+	}
+
+	_, txnSpan := tracing.Start(ctx, "ProduceTxn")
+	err := m.txnProd.produce(msg, eventMsgs, respMsg)
+	tracing.End(txnSpan, err)
+	if err != nil {
+		err = errors.Wrap(err, "Error producing Command-output in transaction")
+		logger.FromContext(ctx).Error(err)
+		return err
+	}
+	return nil
+}
+
+// acquire holds the lock, if set, until the returned func is called.
+func (m *cmdConsumer) acquire() func() {
+	if m.lock == nil {
+		return func() {}
+	}
+	m.lock.Lock()
+	return m.lock.Unlock
+}
+
+// consumeContext returns a context carrying the consumer's Logger, and the
+// signed token and userUUID from the message-headers, and starts the span
+// for consuming the message as child of the trace-context in the headers.
+func (m *cmdConsumer) consumeContext(
+	msg *sarama.ConsumerMessage,
+) (context.Context, trace.Span) {
+	ctx := tracing.Extract(context.Background(), msg)
+	ctx = logger.NewContext(ctx, m.logger)
+	for _, h := range msg.Headers {
+		if h == nil {
+			continue
+		}
+		switch string(h.Key) {
+		case auth.HeaderAuthorization:
+			ctx = auth.WithToken(ctx, auth.BearerToken(string(h.Value)))
+		case auth.HeaderUserUUID:
+			ctx = auth.WithUserUUID(ctx, string(h.Value))
+		}
+	}
+	return tracing.Start(
+		ctx,
+		"ConsumeCommand",
+		attribute.String("messaging.kafka.topic", msg.Topic),
+		attribute.Int("messaging.kafka.partition", int(msg.Partition)),
+		attribute.Int64("messaging.kafka.offset", msg.Offset),
+	)
+}
+
+// parseCommand unmarshals the message into a Command, and returns nil if the
+// Command is invalid or has expired. The returned context carries a Logger
+// with the Command's fields.
+func parseCommand(
+	ctx context.Context,
+	msg *sarama.ConsumerMessage,
+) (context.Context, *model.Command) {
+	_, span := tracing.Start(ctx, "UnmarshalCommand")
+	cmd := &model.Command{}
+	err := json.Unmarshal(msg.Value, cmd)
+	tracing.End(span, err)
+	if err != nil {
+		err = errors.Wrap(err, "Error unmarshalling to Command")
+		logger.FromContext(ctx).Error(err)
+		return ctx, nil
+	}
+
+	cmdLog := logger.FromContext(ctx).WithCommand(cmd)
+	ctx = logger.NewContext(ctx, cmdLog)
+	cmdLog.Infof("Received Command with ID: %s", cmd.UUID)
+	metrics.CommandsReceived.WithLabelValues(command.ActionLabel(cmd.Action)).Inc()
+
+	if cmd.ResponseTopic == "" {
+		cmdLog.Warnf("Command contains empty ResponseTopic")
+		return ctx, nil
+	}
+	if cmd.Action == "" {
+		cmdLog.Warnf("Comman contains empty Action")
+		return ctx, nil
+	}
+
+	ttlSec := time.Duration(cmd.TTLSec) * time.Second
+	expTime := time.Unix(cmd.Timestamp, 0).Add(ttlSec).UTC()
+	curTime := time.Now().UTC()
+	if expTime.Before(curTime) {
+		cmdLog.Warnf("Command expired, ignoring")
+		metrics.CommandsExpired.Inc()
+		return ctx, nil
+	}
+
+	return ctx, cmd
 }
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// runExportUserData runs the export-user-data tool, which writes the data
+// kept on a user to a JSON-file, such as for data-subject access requests.
+func runExportUserData(args []string) error {
+	flags := flag.NewFlagSet("export-user-data", flag.ContinueOnError)
+	userID := flags.String("user-id", "", "UserID of the user to export")
+	out := flags.String("out", "", "file to write the export to, defaults to stdout")
+	apiURL := flags.String("api-url", "http://localhost:8080", "base-URL of the Command-API")
+	token := flags.String("token", os.Getenv("AUTH_TOKEN"), "signed token of the actor")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *userID == "" {
+		return errors.New("-user-id is required")
+	}
+
+	client := newAPIClient(*apiURL, *token, time.Minute)
+	export := json.RawMessage{}
+	err = client.execute("ExportUserData", map[string]string{
+		"userID": *userID,
+	}, &export)
+	if err != nil {
+		return err
+	}
+
+	buf := &bytes.Buffer{}
+	err = json.Indent(buf, export, "", "  ")
+	if err != nil {
+		err = errors.Wrap(err, "Error formatting export")
+		return err
+	}
+	buf.WriteString("\n")
+
+	if *out == "" {
+		_, err = buf.WriteTo(os.Stdout)
+		return err
+	}
+	// Exports hold personal data, so they are only readable by their owner
+	err = ioutil.WriteFile(*out, buf.Bytes(), 0600)
+	if err != nil {
+		err = errors.Wrap(err, "Error writing export")
+		return err
+	}
+	log.Printf("Exported user %s to %s", *userID, *out)
+	return nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/TerrexTech/agg-userauth-cmd/health"
+	"github.com/TerrexTech/agg-userauth-cmd/logger"
+	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// deliveryStats tracks the results of the most recent producer-deliveries.
+type deliveryStats struct {
+	mu      sync.Mutex
+	results []bool
+	next    int
+	filled  bool
+}
+
+func newDeliveryStats(window int) *deliveryStats {
+	return &deliveryStats{
+		results: make([]bool, window),
+	}
+}
+
+// record adds a delivery-result, overwriting the oldest one.
+func (d *deliveryStats) record(success bool) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.results[d.next] = success
+	d.next = (d.next + 1) % len(d.results)
+	if d.next == 0 {
+		d.filled = true
+	}
+}
+
+// errorRate returns the ratio of failed deliveries, and the
+// number of deliveries it was calculated from.
+func (d *deliveryStats) errorRate() (float64, int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	count := d.next
+	if d.filled {
+		count = len(d.results)
+	}
+	if count == 0 {
+		return 0, 0
+	}
+	var failed int
+	for _, success := range d.results[:count] {
+		if !success {
+			failed++
+		}
+	}
+	return float64(failed) / float64(count), count
+}
+
+// consumerStatus tracks the consumer-group membership of the Command-Consumer
+// and the results of BuildState.
+type consumerStatus struct {
+	mu           sync.RWMutex
+	joined       bool
+	lastBuild    time.Time
+	lastBuildErr error
+}
+
+func (c *consumerStatus) setJoined(joined bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.joined = joined
+}
+
+// buildResult records the result of a BuildState run.
+func (c *consumerStatus) buildResult(err error) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil {
+		c.lastBuild = time.Now()
+	}
+	c.lastBuildErr = err
+}
+
+type healthConfig struct {
+	ctx          context.Context
+	coll         *mongo.Collection
+	mongoLock    *sync.RWMutex
+	stats        *deliveryStats
+	status       *consumerStatus
+	maxErrorRate float64
+}
+
+// newHealthChecker registers the checks for the service's dependencies.
+func newHealthChecker(config *healthConfig) *health.Checker {
+	checker := health.NewChecker(3 * time.Second)
+
+	checker.AddLiveness("service", func() (string, error) {
+		if config.ctx.Err() != nil {
+			return "", errors.New("service context closed")
+		}
+		return "running", nil
+	})
+
+	checker.AddReadiness("mongo", func() (string, error) {
+		config.mongoLock.RLock()
+		defer config.mongoLock.RUnlock()
+
+		_, err := config.coll.Find(map[string]interface{}{
+			"userID": "__healthcheck__",
+		})
+		if err != nil {
+			err = errors.Wrap(err, "Error querying Mongo")
+			return "", err
+		}
+		return "reachable", nil
+	})
+
+	checker.AddReadiness("producer", func() (string, error) {
+		rate, count := config.stats.errorRate()
+		detail := fmt.Sprintf("error-rate %.2f over last %d deliveries", rate, count)
+		if rate > config.maxErrorRate {
+			return detail, errors.Errorf(
+				"error-rate above threshold of %.2f", config.maxErrorRate,
+			)
+		}
+		return detail, nil
+	})
+
+	checker.AddReadiness("consumer", func() (string, error) {
+		config.status.mu.RLock()
+		defer config.status.mu.RUnlock()
+		if !config.status.joined {
+			return "", errors.New("command-consumer has not joined consumer-group")
+		}
+		return "joined consumer-group", nil
+	})
+
+	checker.AddReadiness("buildState", func() (string, error) {
+		config.status.mu.RLock()
+		defer config.status.mu.RUnlock()
+
+		detail := "no successful BuildState yet"
+		if !config.status.lastBuild.IsZero() {
+			detail = fmt.Sprintf(
+				"last successful BuildState at %s",
+				config.status.lastBuild.UTC().Format(time.RFC3339),
+			)
+		}
+		if config.status.lastBuildErr != nil {
+			return detail, errors.Wrap(config.status.lastBuildErr, "last BuildState failed")
+		}
+		return detail, nil
+	})
+
+	return checker
+}
+
+// startHTTPServer serves the handler on addr until the context is done.
+func startHTTPServer(
+	ctx context.Context,
+	g *errgroup.Group,
+	addr string,
+	handler http.Handler,
+) {
+	server := &http.Server{
+		Addr:    addr,
+		Handler: handler,
+	}
+
+	g.Go(func() error {
+		logger.DefaultLogger().Infof("Starting HTTP-server on %s", addr)
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			err = errors.Wrap(err, "Error in HTTP-server")
+			return err
+		}
+		return nil
+	})
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err := server.Shutdown(shutdownCtx)
+		if err != nil {
+			err = errors.Wrap(err, "Error shutting down HTTP-server")
+			log.Println(err)
+		}
+	}()
+}
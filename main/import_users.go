@@ -0,0 +1,168 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/TerrexTech/agg-userauth-cmd/importer"
+	"github.com/pkg/errors"
+)
+
+// runImportUsers runs the import-users tool, which imports the users in a
+// CSV or JSONL-file using ImportUsers-commands. Imports are resumed from
+// their progress, so an interrupted import is completed by running the
+// tool again with the same import-id.
+//
+// The import's progress is only advanced once the UsersImported-Event of a
+// batch is applied, so the tool waits for the progress to reach the end of
+// each batch before sending the next batch.
+func runImportUsers(args []string) error {
+	flags := flag.NewFlagSet("import-users", flag.ContinueOnError)
+	file := flags.String("file", "", "CSV or JSONL-file with the users to import")
+	format := flags.String("format", "", "csv or jsonl, detected from the file-extension if blank")
+	importID := flags.String("import-id", "", "ID of the import, defaults to the file-name")
+	apiURL := flags.String("api-url", "http://localhost:8080", "base-URL of the Command-API")
+	batchSize := flags.Int("batch-size", 100, "number of users per ImportUsers-command")
+	token := flags.String("token", os.Getenv("AUTH_TOKEN"), "signed token of the actor")
+	progressTimeout := flags.Duration(
+		"progress-timeout", time.Minute, "time to wait for a batch to be recorded in the progress",
+	)
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if *file == "" {
+		return errors.New("-file is required")
+	}
+	if *batchSize <= 0 {
+		return errors.New("-batch-size must be positive")
+	}
+	if *format == "" {
+		*format = importFormat(*file)
+	}
+	if *importID == "" {
+		*importID = filepath.Base(*file)
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		err = errors.Wrap(err, "Error opening import-file")
+		return err
+	}
+	defer f.Close()
+	users, err := importer.ReadUsers(f, *format)
+	if err != nil {
+		err = errors.Wrap(err, "Error reading import-file")
+		return err
+	}
+
+	client := newAPIClient(*apiURL, *token, 5*time.Minute)
+	progress, err := importProgress(client, *importID)
+	if err != nil {
+		return err
+	}
+	if progress.NextOffset > 0 {
+		log.Printf("Resuming import %s at offset %d", *importID, progress.NextOffset)
+	}
+
+	imported, failed := 0, 0
+	offset := progress.NextOffset
+	for offset < len(users) {
+		end := offset + *batchSize
+		if end > len(users) {
+			end = len(users)
+		}
+		result := &importer.BatchResult{}
+		err = client.execute("ImportUsers", &importer.Batch{
+			ImportID: *importID,
+			Offset:   offset,
+			Users:    users[offset:end],
+		}, result)
+		if err != nil {
+			// The batch might have been imported even though the command failed,
+			// such as if the response timed out, so the import is continued from
+			// its progress if the progress is past the batch's offset.
+			progress, progressErr := importProgress(client, *importID)
+			if progressErr != nil || progress.NextOffset <= offset {
+				err = errors.Wrapf(err, "Error importing batch at offset %d, rerun to resume", offset)
+				return err
+			}
+			log.Printf(
+				"Batch at offset %d failed (%s), continuing at offset %d",
+				offset, err, progress.NextOffset,
+			)
+			offset = progress.NextOffset
+			continue
+		}
+		for _, rowErr := range result.Errors {
+			log.Printf("User at offset %d not imported: %s", rowErr.Offset, rowErr.Error)
+		}
+		imported += result.Imported
+		failed += len(result.Errors)
+
+		progress, err = waitForProgress(client, *importID, result.NextOffset, *progressTimeout)
+		if err != nil {
+			err = errors.Wrapf(err, "Error importing batch at offset %d, rerun to resume", offset)
+			return err
+		}
+		offset = progress.NextOffset
+		log.Printf("Imported %d of %d users", offset, len(users))
+	}
+
+	log.Printf("Import %s complete: %d imported, %d failed", *importID, imported, failed)
+	return nil
+}
+
+// importProgress returns the Progress of the import.
+func importProgress(client *apiClient, importID string) (*importer.Progress, error) {
+	progress := &importer.Progress{}
+	err := client.execute("ImportProgress", &importer.Batch{
+		ImportID: importID,
+	}, progress)
+	if err != nil {
+		return nil, err
+	}
+	return progress, nil
+}
+
+// waitForProgress polls the Progress of the import until its NextOffset
+// reaches nextOffset, or the timeout expires.
+func waitForProgress(
+	client *apiClient,
+	importID string,
+	nextOffset int,
+	timeout time.Duration,
+) (*importer.Progress, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		progress, err := importProgress(client, importID)
+		if err != nil {
+			err = errors.Wrap(err, "Error querying import-progress")
+			return nil, err
+		}
+		if progress.NextOffset >= nextOffset {
+			return progress, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.Errorf(
+				"import-progress did not reach offset %d within %s (at offset %d)",
+				nextOffset, timeout, progress.NextOffset,
+			)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// importFormat returns the format of the import-file by its extension.
+func importFormat(file string) string {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".jsonl", ".ndjson":
+		return importer.FormatJSONL
+	}
+	return importer.FormatCSV
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"github.com/TerrexTech/agg-userauth-cmd/broker"
+	"github.com/TerrexTech/agg-userauth-cmd/logger"
+	"github.com/TerrexTech/go-kafkautils/kafka"
+	"github.com/pkg/errors"
+)
+
+type logSinkConfig struct {
+	kafkaConfig *kafka.ProducerConfig
+	// memBroker is optional. If set, log-records are produced
+	// to the in-memory broker instead of Kafka.
+	memBroker *broker.Broker
+	topic     string
+	queueSize int
+	key       string
+}
+
+// kafkaLogSink returns a log-sink that produces each log-record to the topic,
+// and a function that stops the sink. Records are dropped if the sink's queue
+// is full, so logging never blocks on Kafka.
+func kafkaLogSink(config *logSinkConfig) (logger.Sink, func(), error) {
+	if config.topic == "" {
+		return nil, nil, errors.New("topic cannot be empty")
+	}
+	topic := config.topic
+	key := config.key
+
+	saramaConfig := sarama.NewConfig()
+	if config.kafkaConfig.SaramaConfig != nil {
+		saramaConfig = copySaramaConfig(config.kafkaConfig.SaramaConfig)
+	}
+	saramaConfig.Producer.Return.Errors = true
+	saramaConfig.Producer.Return.Successes = false
+	prod, err := newAsyncProducer(config.kafkaConfig, saramaConfig, config.memBroker)
+	if err != nil {
+		err = errors.Wrap(err, "Error creating Log-Producer")
+		return nil, nil, err
+	}
+
+	records := make(chan []byte, config.queueSize)
+	done := make(chan struct{})
+
+	// Errors are written to stderr, since logging them
+	// would feed them back into this sink.
+	go func() {
+		for prodErr := range prod.Errors() {
+			fmt.Fprintln(os.Stderr, errors.Wrap(prodErr, "Error producing log-record"))
+		}
+	}()
+	go func() {
+		for {
+			select {
+			case <-done:
+				prod.AsyncClose()
+				return
+			case record := <-records:
+				msg := kafka.CreateMessage(topic, record)
+				msg.Key = sarama.StringEncoder(key)
+				prod.Input() <- msg
+			}
+		}
+	}()
+
+	sink := func(record []byte) {
+		select {
+		case <-done:
+		case records <- record:
+		default:
+		}
+	}
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			close(done)
+		})
+	}
+	return sink, stop, nil
+}
@@ -1,23 +1,42 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
-	"strconv"
+	"sync"
+	"time"
 
+	"github.com/Shopify/sarama"
+	"github.com/TerrexTech/agg-userauth-cmd/api"
+	"github.com/TerrexTech/agg-userauth-cmd/auth"
+	"github.com/TerrexTech/agg-userauth-cmd/broker"
 	"github.com/TerrexTech/agg-userauth-cmd/command"
+	"github.com/TerrexTech/agg-userauth-cmd/config"
+	"github.com/TerrexTech/agg-userauth-cmd/domain"
+	"github.com/TerrexTech/agg-userauth-cmd/fieldcrypt"
+	"github.com/TerrexTech/agg-userauth-cmd/history"
+	"github.com/TerrexTech/agg-userauth-cmd/importer"
+	"github.com/TerrexTech/agg-userauth-cmd/lifecycle"
+	"github.com/TerrexTech/agg-userauth-cmd/logger"
+	"github.com/TerrexTech/agg-userauth-cmd/metrics"
+	"github.com/TerrexTech/agg-userauth-cmd/rbac"
+	"github.com/TerrexTech/agg-userauth-cmd/secrets"
+	"github.com/TerrexTech/agg-userauth-cmd/shred"
+	"github.com/TerrexTech/agg-userauth-cmd/tracing"
 	"github.com/TerrexTech/agg-userauth-cmd/util"
 	"github.com/TerrexTech/agg-userauth-model/user"
 	"github.com/TerrexTech/go-agg-builder/builder"
-	"github.com/TerrexTech/go-commonutils/commonutil"
 	"github.com/TerrexTech/go-kafkautils/kafka"
 	"github.com/joho/godotenv"
 	"github.com/pkg/errors"
 )
 
-// validateEnv checks if all required environment-variables are set.
-func validateEnv() {
+// loadConfig loads the Config. Env-vars are also read from the .env file,
+// if present.
+func loadConfig(args []string) (*config.Config, error) {
 	// Load environment-file.
 	// Env vars will be read directly from environment if this file fails loading
 	err := godotenv.Load()
@@ -27,92 +46,186 @@ func validateEnv() {
 		)
 		log.Println(err)
 	}
+	return config.Load(args)
+}
 
-	missingVar, err := commonutil.ValidateEnv(
-		"SERVICE_NAME",
-
-		"KAFKA_BROKERS",
-
-		"KAFKA_CONSUMER_GROUP_REQUEST",
-		"KAFKA_CONSUMER_TOPIC_REQUEST",
-
-		"KAFKA_CONSUMER_GROUP_ESRESP",
-		"KAFKA_CONSUMER_TOPIC_ESRESP",
-
-		"KAFKA_PRODUCER_TOPIC_ESREQ",
-		"KAFKA_PRODUCER_TOPIC_EVENTS",
-
-		"KAFKA_END_OF_STREAM_TOKEN",
-
-		"MONGO_HOSTS",
-		"MONGO_USERNAME",
-		"MONGO_PASSWORD",
-
-		"MONGO_DATABASE",
-		"MONGO_AGG_COLLECTION",
-		"MONGO_META_COLLECTION",
+// tools are the subcommands which run against a running service,
+// using its Command-API.
+var tools = map[string]func(args []string) error{
+	"import-users":     runImportUsers,
+	"export-user-data": runExportUserData,
+}
 
-		"MONGO_CONNECTION_TIMEOUT_MS",
-	)
+func main() {
+	// "import-users" and "export-user-data" run tools against a running service
+	args := os.Args[1:]
+	if len(args) > 0 {
+		if tool, ok := tools[args[0]]; ok {
+			err := tool(args[1:])
+			if err != nil {
+				log.Fatalln(err)
+			}
+			return
+		}
+	}
 
+	// "print-config" prints the effective config, with secrets redacted
+	printConfig := len(args) > 0 && args[0] == "print-config"
+	if printConfig {
+		args = args[1:]
+	}
+	cfg, err := loadConfig(args)
+	if printConfig && cfg != nil {
+		printErr := cfg.Print(os.Stdout)
+		if printErr != nil {
+			log.Fatalln(printErr)
+		}
+	}
 	if err != nil {
-		err = errors.Wrapf(err, "Env-var %s is required, but is not set", missingVar)
+		err = errors.Wrap(err, "Error loading config")
 		log.Fatalln(err)
 	}
-}
-
-func main() {
-	validateEnv()
+	if printConfig {
+		return
+	}
 
-	kafkaBrokersStr := os.Getenv("KAFKA_BROKERS")
-	kafkaBrokers := *commonutil.ParseHosts(kafkaBrokersStr)
+	// In standalone-mode, an in-memory broker replaces Kafka
+	var memBroker *broker.Broker
+	if cfg.Standalone {
+		log.Println("Standalone-mode enabled, using in-memory broker instead of Kafka")
+		memBroker = broker.New()
+	}
 
+	// The TLS and SASL settings in saramaConfig are applied to
+	// all Kafka consumers and producers.
+	kafkaBrokers := cfg.Kafka.Brokers
+	saramaConfig, err := util.NewSaramaConfig(&cfg.Kafka)
+	if err != nil {
+		err = errors.Wrap(err, "Error creating Kafka-config")
+		log.Fatalln(err)
+	}
 	kafkaProdConfig := &kafka.ProducerConfig{
 		KafkaBrokers: kafkaBrokers,
+		SaramaConfig: copySaramaConfig(saramaConfig),
 	}
 
-	// KafkaConfig for Agg-Builder
-	esQueryRespTopic := fmt.Sprintf(
-		"%s.%d",
-		os.Getenv("KAFKA_CONSUMER_TOPIC_ESRESP"),
-		user.AggregateID,
-	)
-	esQueryRespGroup := os.Getenv("KAFKA_CONSUMER_TOPIC_ESRESP")
-	esQueryReqTopic := os.Getenv("KAFKA_PRODUCER_TOPIC_ESREQ")
-	eosToken := os.Getenv("KAFKA_END_OF_STREAM_TOKEN")
-	kc := builder.KafkaConfig{
-		ESQueryResCons: &kafka.ConsumerConfig{
-			KafkaBrokers: kafkaBrokers,
-			Topics:       []string{esQueryRespTopic},
-			GroupName:    esQueryRespGroup,
-		},
-		ESQueryReqProd:  kafkaProdConfig,
-		ESQueryReqTopic: esQueryReqTopic,
-		EOSToken:        eosToken,
+	// Logger
+	serviceName := cfg.ServiceName
+	logLevel, err := logger.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		err = errors.Wrap(err, "Error parsing LOG_LEVEL")
+		log.Fatalln(err)
+	}
+	logConfig := &logger.Config{
+		Service: serviceName,
+		Level:   logLevel,
+	}
+	var stopLogSink func()
+	logTopic := cfg.Kafka.LogProducerTopic
+	if logTopic != "" {
+		logConfig.Sink, stopLogSink, err = kafkaLogSink(&logSinkConfig{
+			kafkaConfig: kafkaProdConfig,
+			memBroker:   memBroker,
+			topic:       logTopic,
+			queueSize:   1024,
+			key:         serviceName,
+		})
+		if err != nil {
+			err = errors.Wrap(err, "Error creating Kafka log-sink")
+			log.Fatalln(err)
+		}
 	}
+	appLog := logger.New(logConfig)
+	logger.SetDefault(appLog)
+	// Output from the standard logger is also structured. It is only used
+	// for errors, while other messages are logged with the Logger.
+	log.SetFlags(0)
+	log.SetOutput(appLog.Writer(logger.LevelError))
 
 	// Mongo Config
-	mc, err := util.LoadMongoConfig()
+	mc, err := util.NewMongoConfig(&cfg.Mongo)
 	if err != nil {
 		err = errors.Wrap(err, "Error initializing MongoConfig")
 		log.Fatalln(err)
 	}
-	eventsIO, err := builder.Init(builder.IOConfig{
-		KafkaConfig: kc,
-		MongoConfig: *mc,
-	})
+	// The MongoDB-Client is replaced when its credentials are rotated,
+	// which waits for the holders of the read-lock.
+	mongoLock := util.MongoLock(mc.Connection)
+
+	// Events for building the Aggregate-state are fetched from the
+	// event-store, or from the in-memory broker in standalone-mode.
+	var eventsIO aggEventsIO
+	if memBroker != nil {
+		eventsIO, err = newStandaloneIO(memBroker, cfg.Kafka.EventsProducerTopic)
+	} else {
+		eventsIO, err = initEventsIO(cfg, kafkaProdConfig, saramaConfig, mc)
+	}
 	if err != nil {
 		err = errors.Wrap(err, "Error initializing Aggregate-eventsIO")
 		log.Fatalln(err)
 	}
 
+	if stopLogSink != nil {
+		go func() {
+			<-eventsIO.Context().Done()
+			stopLogSink()
+		}()
+	}
+
+	// Secrets rotated in their files are applied without restarting
+	signingKey := secrets.NewValue(cfg.Auth.SigningKey)
+	err = watchSecrets(&secretRotationConfig{
+		ctx:        eventsIO.Context(),
+		g:          eventsIO.ErrGroup(),
+		cfg:        cfg,
+		mongoConn:  mc.Connection,
+		signingKey: signingKey,
+		log:        appLog,
+	})
+	if err != nil {
+		err = errors.Wrap(err, "Error watching secret-files")
+		log.Fatalln(err)
+	}
+
+	// Tracing
+	shutdownTracing, err := tracing.Init(&tracing.Config{
+		ServiceName: serviceName,
+		Exporter:    cfg.Tracing.Exporter,
+		FilePath:    cfg.Tracing.FilePath,
+	})
+	if err != nil {
+		err = errors.Wrap(err, "Error initializing tracing")
+		log.Fatalln(err)
+	}
+	go func() {
+		<-eventsIO.Context().Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err := shutdownTracing(shutdownCtx)
+		if err != nil {
+			err = errors.Wrap(err, "Error shutting down tracing")
+			log.Println(err)
+		}
+	}()
+
+	// Producer queues and backpressure
+	queueSize := cfg.Producer.QueueSize
+	sendTimeoutMs := cfg.Producer.SendTimeoutMs
+	highWaterMark := cfg.Producer.QueueHighWaterMark
+	queues := newQueueMonitor()
+	stats := newDeliveryStats(100)
+
 	prodConfig := &producerConfig{
 		ctx:         eventsIO.Context(),
 		kafkaConfig: kafkaProdConfig,
+		memBroker:   memBroker,
 		g:           eventsIO.ErrGroup(),
+		queueSize:   queueSize,
+		queues:      queues,
+		stats:       stats,
 	}
 	// Event Producer
-	eventsTopic := os.Getenv("KAFKA_PRODUCER_TOPIC_EVENTS")
+	eventsTopic := cfg.Kafka.EventsProducerTopic
 	eventChan, err := eventProducer(prodConfig, eventsTopic)
 	if err != nil {
 		err = errors.Wrap(err, "Error creating EventProducer")
@@ -125,13 +238,208 @@ func main() {
 		log.Fatalln(err)
 	}
 
+	// Role-based access control is enabled when its collection is configured
+	projection := &domain.Projection{
+		Users: mc.AggCollection,
+	}
+	if cfg.RBAC.RolesCollection != "" {
+		projection.Roles, err = newRoleStore(cfg, mc)
+		if err != nil {
+			err = errors.Wrap(err, "Error initializing Role-Store")
+			log.Fatalln(err)
+		}
+	}
+
+	// Soft-delete is enabled when its collection is configured
+	if cfg.Lifecycle.Collection != "" {
+		projection.Lifecycle, err = lifecycle.NewStore(&lifecycle.StoreConfig{
+			Conn:       mc.Connection,
+			Database:   cfg.Mongo.Database,
+			Collection: cfg.Lifecycle.Collection,
+		})
+		if err != nil {
+			err = errors.Wrap(err, "Error initializing Lifecycle-Store")
+			log.Fatalln(err)
+		}
+	}
+	gracePeriodHours := cfg.Lifecycle.RestoreGracePeriodHours
+
+	// Bulk import is enabled when its collection is configured
+	if cfg.Import.Collection != "" {
+		projection.Imports, err = importer.NewStore(
+			mc.Connection,
+			cfg.Mongo.Database,
+			cfg.Import.Collection,
+		)
+		if err != nil {
+			err = errors.Wrap(err, "Error initializing Import-Store")
+			log.Fatalln(err)
+		}
+	}
+
+	// User-export is enabled when the history-collection is configured
+	if cfg.History.Collection != "" {
+		projection.History, err = history.NewStore(&history.StoreConfig{
+			Conn:       mc.Connection,
+			Database:   cfg.Mongo.Database,
+			Collection: cfg.History.Collection,
+		})
+		if err != nil {
+			err = errors.Wrap(err, "Error initializing History-Store")
+			log.Fatalln(err)
+		}
+	}
+
+	// Crypto-shredding is enabled when the keys-collection is configured
+	if cfg.Erasure.KeysCollection != "" {
+		projection.Keys, err = shred.NewStore(&shred.StoreConfig{
+			Conn:       mc.Connection,
+			Database:   cfg.Mongo.Database,
+			Collection: cfg.Erasure.KeysCollection,
+		})
+		if err != nil {
+			err = errors.Wrap(err, "Error initializing Key-Store")
+			log.Fatalln(err)
+		}
+	}
+
+	// Field-level encryption is enabled when its keys are configured
+	if cfg.FieldEncryption.Keys != "" {
+		projection.Fields, err = fieldcrypt.NewCodec(&fieldcrypt.Config{
+			Keys:                cfg.FieldEncryption.Keys,
+			ActiveKeyID:         cfg.FieldEncryption.ActiveKeyID,
+			DeterministicFields: cfg.FieldEncryption.DeterministicFields,
+			RandomizedFields:    cfg.FieldEncryption.RandomizedFields,
+		})
+		if err != nil {
+			err = errors.Wrap(err, "Error initializing field-encryption")
+			log.Fatalln(err)
+		}
+	}
+
+	var authorizer *auth.Authorizer
+	if cfg.Auth.Enabled {
+		var policy *auth.Policy
+		if cfg.Auth.PolicyFile != "" {
+			policy, err = auth.LoadPolicy(cfg.Auth.PolicyFile)
+			if err != nil {
+				err = errors.Wrap(err, "Error loading Auth-Policy")
+				log.Fatalln(err)
+			}
+		}
+		authorizer, err = auth.NewAuthorizer(&auth.Config{
+			Users:        mc.AggCollection,
+			Fields:       projection.Fields,
+			Roles:        projection.Roles,
+			Policy:       policy,
+			SigningKey:   signingKey,
+			RequireToken: cfg.Auth.RequireToken,
+			AdminRole:    cfg.Auth.AdminRole,
+			Lifecycle:    projection.Lifecycle,
+		})
+		if err != nil {
+			err = errors.Wrap(err, "Error initializing Authorizer")
+			log.Fatalln(err)
+		}
+	}
+
+	// In exactly-once mode, Kafka-transactions are also used to emit the
+	// Events of a Command atomically. Else the Events are produced one at a
+	// time, so clusters without transaction-support are not required to
+	// have it.
+	cmdConsGroup := cfg.Kafka.CmdConsumerGroup
+	transactionalID := cfg.Kafka.TransactionalID
+	if transactionalID == "" {
+		hostname, _ := os.Hostname()
+		transactionalID = fmt.Sprintf("%s.%s", serviceName, hostname)
+	}
+	newEventsTxnProducer := func(transactionalID string) *txnProducer {
+		txnProd, err := newTxnProducer(&txnProducerConfig{
+			producerConfig:  prodConfig,
+			transactionalID: transactionalID,
+			groupName:       cmdConsGroup,
+			eventsTopic:     eventsTopic,
+		})
+		if err != nil {
+			err = errors.Wrap(err, "Error creating Transactional-Producer")
+			log.Fatalln(err)
+		}
+		return txnProd
+	}
+
+	// Outbox is enabled when its collection is configured
+	var outbox *command.Outbox
+	if cfg.Outbox.Collection != "" {
+		outbox, err = command.NewOutbox(
+			mc.Connection,
+			cfg.Mongo.Database,
+			cfg.Outbox.Collection,
+		)
+		if err != nil {
+			err = errors.Wrap(err, "Error initializing Outbox")
+			log.Fatalln(err)
+		}
+
+		var relayTxnProd *txnProducer
+		if cfg.Kafka.EOSEnabled {
+			relayTxnProd = newEventsTxnProducer(transactionalID + ".outbox")
+		}
+		pollInterval := cfg.Outbox.PollIntervalMs
+		err = outboxRelay(&outboxRelayConfig{
+			ctx:          eventsIO.Context(),
+			g:            eventsIO.ErrGroup(),
+			outbox:       outbox,
+			mongoLock:    mongoLock,
+			prodConfig:   prodConfig,
+			eventsTopic:  eventsTopic,
+			pollInterval: time.Duration(pollInterval) * time.Millisecond,
+			txnProd:      relayTxnProd,
+			maxAttempts:  cfg.Outbox.MaxAttempts,
+			lease:        time.Duration(cfg.Outbox.LeaseMs) * time.Millisecond,
+			retention:    time.Duration(cfg.Outbox.RetentionHours) * time.Hour,
+		})
+		if err != nil {
+			err = errors.Wrap(err, "Error starting Outbox-Relay")
+			log.Fatalln(err)
+		}
+	}
+
+	// Exactly-once mode: Command-offsets and the produced Events/Responses
+	// are committed in a single Kafka-transaction. Without Outbox, the
+	// Events of Commands with several Events are also produced in a single
+	// transaction.
+	var txnProd *txnProducer
+	if cfg.Kafka.EOSEnabled {
+		txnProd = newEventsTxnProducer(transactionalID)
+	}
+	var publishEvents func([]*command.EventMsg) error
+	if outbox == nil && txnProd != nil {
+		publishEvents = txnProd.produceEvents
+	}
+
 	// Command Handler
-	serviceName := os.Getenv("SERVICE_NAME")
 	cmdHandler, err := command.NewHandler(&command.HandlerConfig{
-		Coll:        mc.AggCollection,
-		ServiceName: serviceName,
-		EventProd:   eventChan,
-		ResultProd:  respChan,
+		Coll:          mc.AggCollection,
+		ServiceName:   serviceName,
+		EventProd:     eventChan,
+		ResultProd:    respChan,
+		PublishEvents: publishEvents,
+		Outbox:        outbox,
+		SendTimeout:   time.Duration(sendTimeoutMs) * time.Millisecond,
+		Done:          eventsIO.Context().Done(),
+		Logger:        appLog,
+		Roles:         projection.Roles,
+		Auth:          authorizer,
+
+		Lifecycle:          projection.Lifecycle,
+		RestoreGracePeriod: time.Duration(gracePeriodHours) * time.Hour,
+
+		Imports:            projection.Imports,
+		MaxImportBatchSize: cfg.Import.MaxBatchSize,
+
+		History: projection.History,
+		Keys:    projection.Keys,
+		Fields:  projection.Fields,
 	})
 	if err != nil {
 		err = errors.Wrap(err, "Error initializing command-handler")
@@ -139,39 +447,248 @@ func main() {
 	}
 
 	// Command Consumer
-	cmdConsGroup := os.Getenv("KAFKA_CONSUMER_GROUP_REQUEST")
-	cmdConsTopic := os.Getenv("KAFKA_CONSUMER_TOPIC_REQUEST")
-	cmdCons, err := kafka.NewConsumer(&kafka.ConsumerConfig{
+	cmdConsTopic := cfg.Kafka.CmdConsumerTopic
+	cmdKafkaConfig := &kafka.ConsumerConfig{
 		KafkaBrokers: kafkaBrokers,
 		GroupName:    cmdConsGroup,
 		Topics:       []string{cmdConsTopic},
-	})
+		SaramaConfig: consumerSaramaConfig(saramaConfig),
+	}
+
+	// The consumer only produces using the Transactional-Producer in
+	// exactly-once mode.
+	var eosProd *txnProducer
+	if cfg.Kafka.EOSEnabled {
+		appLog.Infof(
+			"Exactly-once mode enabled, consumed Commands are emitted without the Outbox",
+		)
+		eosProd = txnProd
+
+		// Offsets are committed by the transaction, and only committed
+		// Commands are read.
+		txnConsConfig := cmdKafkaConfig.SaramaConfig
+		if !txnConsConfig.Version.IsAtLeast(sarama.V0_11_0_0) {
+			txnConsConfig.Version = sarama.V0_11_0_0
+		}
+		txnConsConfig.Consumer.IsolationLevel = sarama.ReadCommitted
+		txnConsConfig.Consumer.Offsets.AutoCommit.Enable = false
+	}
+
+	cmdCons, err := newGroupConsumer(cmdKafkaConfig, memBroker)
 	if err != nil {
 		err = errors.Wrap(err, "Error creating consumer")
 		log.Fatalln(err)
 	}
 
-	builderTimeoutSecStr := os.Getenv("AGG_BUILDER_TIMEOUT_SEC")
-	builderTimeoutSec, err := strconv.Atoi(builderTimeoutSecStr)
-	if err != nil {
-		err = errors.Wrap(err, "Error converting AGG_BUILDER_TIMEOUT_SEC to integer")
-		log.Println(err)
-		log.Println("A defalt value of 5 will be used for AGG_BUILDER_TIMEOUT_SEC")
-		builderTimeoutSec = 5
+	builderTimeoutSec := cfg.AggBuilderTimeoutSec
+	// Commands from the API and the consumer are processed one at a time,
+	// from building the Aggregate-state until their Events are emitted, so
+	// each Command is validated against the state left by the previous one.
+	cmdLock := lockers{&sync.Mutex{}, mongoLock.RLocker()}
+
+	// The Events applied before the history-collection was configured are
+	// recorded before any Command is handled. Standalone-mode keeps its
+	// Events in memory, so its history always covers all Events.
+	if projection.History != nil && memBroker == nil {
+		metaVersion, err := domain.NewMetaVersion(mc)
+		if err != nil {
+			err = errors.Wrap(err, "Error initializing Aggregate-version")
+			log.Fatalln(err)
+		}
+		cmdLock.Lock()
+		err = domain.BackfillHistory(
+			logger.NewContext(eventsIO.Context(), appLog),
+			projection,
+			metaVersion,
+			eventsIO.BuildState,
+			builderTimeoutSec,
+		)
+		cmdLock.Unlock()
+		if err != nil {
+			err = errors.Wrap(err, "Error backfilling user-history")
+			log.Fatalln(err)
+		}
 	}
+
+	// Health-checks
+	maxErrorRate := cfg.Producer.MaxErrorRate
+	consStatus := &consumerStatus{}
+	healthChecker := newHealthChecker(&healthConfig{
+		ctx:          eventsIO.Context(),
+		coll:         mc.AggCollection,
+		mongoLock:    mongoLock,
+		stats:        stats,
+		status:       consStatus,
+		maxErrorRate: maxErrorRate,
+	})
+	httpAddr := cfg.HTTP.ListenAddr
+	httpMux := http.NewServeMux()
+	healthChecker.Register(httpMux)
+	httpMux.Handle("/metrics", metrics.Handler())
+	if memBroker != nil {
+		memBroker.RegisterHTTP(httpMux)
+	}
+
+	// Synchronous Command-API
+	if cfg.API.HTTPEnabled || cfg.API.GRPCListenAddr != "" {
+		apiService, err := api.NewService(&api.Config{
+			BuildState: func(ctx context.Context) error {
+				err := domain.BuildState(ctx, projection, eventsIO.BuildState, builderTimeoutSec)
+				consStatus.buildResult(err)
+				return err
+			},
+			Handle:      cmdHandler.Execute,
+			Explain:     cmdHandler.Explain,
+			Lock:        cmdLock,
+			ServiceName: serviceName,
+			Logger:      appLog,
+		})
+		if err != nil {
+			err = errors.Wrap(err, "Error initializing Command-API")
+			log.Fatalln(err)
+		}
+		if cfg.API.HTTPEnabled {
+			apiService.RegisterHTTP(httpMux)
+		}
+		if cfg.API.GRPCListenAddr != "" {
+			err = apiService.ServeGRPC(eventsIO.Context(), eventsIO.ErrGroup(), cfg.API.GRPCListenAddr)
+			if err != nil {
+				err = errors.Wrap(err, "Error starting gRPC Command-API")
+				log.Fatalln(err)
+			}
+		}
+	}
+	startHTTPServer(eventsIO.Context(), eventsIO.ErrGroup(), httpAddr, httpMux)
+
 	handler, err := newCmdConsumer(cmdConsConfig{
-		collection:        mc.AggCollection,
+		projection:        projection,
 		builderFunc:       eventsIO.BuildState,
 		builderTimeoutSec: builderTimeoutSec,
 		handle:            cmdHandler.Handle,
+		txnProd:           eosProd,
+		process:           cmdHandler.Process,
+		queues:            queues,
+		highWaterMark:     highWaterMark,
+		status:            consStatus,
+		lock:              cmdLock,
+		logger:            appLog,
 	})
 	if err != nil {
 		err = errors.Wrap(err, "Error initializing Cmd-Handler")
 		log.Fatalln(err)
 	}
-	err = cmdCons.Consume(eventsIO.Context(), handler)
+	// Sessions end on rebalances, and when uncommitted Commands are rewound,
+	// so the consumer-group is joined again until the service shuts down.
+	for eventsIO.Context().Err() == nil {
+		err = cmdCons.Consume(eventsIO.Context(), handler)
+		if err != nil && errors.Cause(err) != errRewind {
+			err = errors.Wrap(err, "Error while attempting to consume Commands")
+			log.Fatalln(err)
+		}
+	}
+
+	// Also disconnects the Clients replaced by rotated credentials
+	err = util.CloseMongo(mc.Connection)
 	if err != nil {
-		err = errors.Wrap(err, "Error while attempting to consume Commands")
-		log.Fatalln(err)
+		err = errors.Wrap(err, "Error closing MongoDB-connection")
+		log.Println(err)
+	}
+}
+
+// initEventsIO initializes the Agg-Builder, which fetches the Events
+// for building the Aggregate-state from the event-store.
+func initEventsIO(
+	cfg *config.Config,
+	kafkaProdConfig *kafka.ProducerConfig,
+	saramaConfig *sarama.Config,
+	mc *builder.MongoConfig,
+) (*builder.EventsIO, error) {
+	esQueryRespTopic := fmt.Sprintf(
+		"%s.%d",
+		cfg.Kafka.ESRespConsumerTopic,
+		user.AggregateID,
+	)
+	kc := builder.KafkaConfig{
+		ESQueryResCons: &kafka.ConsumerConfig{
+			KafkaBrokers: cfg.Kafka.Brokers,
+			Topics:       []string{esQueryRespTopic},
+			// The group is named after the topic, as it always has been,
+			// so the group's committed offsets are kept
+			GroupName:    cfg.Kafka.ESRespConsumerTopic,
+			SaramaConfig: consumerSaramaConfig(saramaConfig),
+		},
+		ESQueryReqProd:  kafkaProdConfig,
+		ESQueryReqTopic: cfg.Kafka.ESReqProducerTopic,
+		EOSToken:        cfg.Kafka.EndOfStreamToken,
+	}
+	return builder.Init(builder.IOConfig{
+		KafkaConfig: kc,
+		MongoConfig: *mc,
+	})
+}
+
+// newRoleStore creates the Store for the role-catalogue, which is
+// initialized with the roles from the config.
+func newRoleStore(cfg *config.Config, mc *builder.MongoConfig) (*rbac.Store, error) {
+	roles := make([]*rbac.Role, len(cfg.RBAC.Roles))
+	for i, role := range cfg.RBAC.Roles {
+		roles[i] = &rbac.Role{
+			Name:        role.Name,
+			Description: role.Description,
+			Permissions: role.Permissions,
+		}
+	}
+	return rbac.NewStore(&rbac.StoreConfig{
+		Conn:                mc.Connection,
+		Database:            cfg.Mongo.Database,
+		RolesCollection:     cfg.RBAC.RolesCollection,
+		UserRolesCollection: cfg.RBAC.UserRolesCollection,
+		Roles:               roles,
+	})
+}
+
+// lockers locks all its Lockers in order, and unlocks them in reverse.
+type lockers []sync.Locker
+
+func (l lockers) Lock() {
+	for _, locker := range l {
+		locker.Lock()
+	}
+}
+
+func (l lockers) Unlock() {
+	for i := len(l) - 1; i >= 0; i-- {
+		l[i].Unlock()
+	}
+}
+
+// copySaramaConfig returns a deep copy of the config, so each client
+// can change its settings independently.
+func copySaramaConfig(config *sarama.Config) *sarama.Config {
+	c := *config
+	if config.Net.TLS.Config != nil {
+		c.Net.TLS.Config = config.Net.TLS.Config.Clone()
+	}
+	c.Consumer.Group.Rebalance.GroupStrategies = append(
+		[]sarama.BalanceStrategy(nil),
+		config.Consumer.Group.Rebalance.GroupStrategies...,
+	)
+	c.Consumer.Group.Member.UserData = append(
+		[]byte(nil),
+		config.Consumer.Group.Member.UserData...,
+	)
+	return &c
+}
+
+// consumerSaramaConfig returns a copy of the config with the consumer-defaults
+// of go-kafkautils, which an explicit SaramaConfig would otherwise replace.
+func consumerSaramaConfig(config *sarama.Config) *sarama.Config {
+	c := copySaramaConfig(config)
+	c.Consumer.Offsets.Initial = sarama.OffsetOldest
+	c.Consumer.MaxProcessingTime = 10 * time.Second
+	c.Consumer.Return.Errors = true
+	if !c.Version.IsAtLeast(sarama.V2_0_0_0) {
+		c.Version = sarama.V2_0_0_0
 	}
+	return c
 }
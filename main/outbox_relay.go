@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/TerrexTech/agg-userauth-cmd/command"
+	"github.com/TerrexTech/agg-userauth-cmd/logger"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+type outboxRelayConfig struct {
+	ctx          context.Context
+	g            *errgroup.Group
+	outbox       *command.Outbox
+	mongoLock    *sync.RWMutex
+	prodConfig   *producerConfig
+	eventsTopic  string
+	pollInterval time.Duration
+	// txnProd is optional. If set, the Events of entries with several Events
+	// are published in one transaction, so either all of them are emitted or
+	// none are. Else they are published one at a time, and are published
+	// again if one of them fails.
+	txnProd *txnProducer
+
+	// maxAttempts is the number of failed attempts after which
+	// an entry is dead-lettered.
+	maxAttempts int
+	// lease is how long the relay's claim on an entry lasts.
+	lease time.Duration
+	// retention is how long sent entries are kept.
+	retention time.Duration
+}
+
+// pruneInterval is the interval at which sent entries are pruned.
+const pruneInterval = time.Hour
+
+// outboxRelay publishes the pending Outbox-entries in the order they were
+// recorded. An entry is only marked as sent once the broker acknowledges it,
+// failed entries are retried on the next poll until they are dead-lettered.
+// Relays of several replicas claim each entry before publishing it, so
+// entries are not published twice. The read-lock of mongoLock is held while
+// entries are relayed or pruned, so the MongoDB-Client is not replaced
+// meanwhile.
+func outboxRelay(config *outboxRelayConfig) error {
+	if config == nil {
+		return errors.New("config cannot be nil")
+	}
+	if config.outbox == nil {
+		return errors.New("outbox cannot be nil")
+	}
+	if config.mongoLock == nil {
+		return errors.New("mongoLock cannot be nil")
+	}
+	if config.eventsTopic == "" {
+		return errors.New("eventsTopic cannot be empty")
+	}
+	if config.pollInterval <= 0 {
+		return errors.New("pollInterval must be greater than 0")
+	}
+	if config.maxAttempts <= 0 {
+		return errors.New("maxAttempts must be greater than 0")
+	}
+	if config.lease <= 0 {
+		return errors.New("lease must be greater than 0")
+	}
+	if config.retention <= 0 {
+		return errors.New("retention must be greater than 0")
+	}
+
+	prodChan := make(chan *producerInput, config.prodConfig.queueSize)
+	config.prodConfig.queues.add("outbox", func() int {
+		return len(prodChan)
+	}, cap(prodChan))
+	err := producer(config.prodConfig, (<-chan *producerInput)(prodChan))
+	if err != nil {
+		err = errors.Wrap(err, "Error creating Outbox-Producer")
+		return err
+	}
+
+	config.g.Go(func() error {
+		logger.DefaultLogger().Infof("Starting Outbox-Relay")
+		ticker := time.NewTicker(config.pollInterval)
+		defer ticker.Stop()
+		pruneTicker := time.NewTicker(pruneInterval)
+		defer pruneTicker.Stop()
+
+		for {
+			select {
+			case <-config.ctx.Done():
+				return errors.New("Outbox-Relay: session closed")
+			case <-ticker.C:
+				config.mongoLock.RLock()
+				relayPending(config, prodChan)
+				config.mongoLock.RUnlock()
+			case <-pruneTicker.C:
+				config.mongoLock.RLock()
+				pruneSent(config)
+				config.mongoLock.RUnlock()
+			}
+		}
+	})
+	return nil
+}
+
+func relayPending(config *outboxRelayConfig, prodChan chan<- *producerInput) {
+	entries, err := config.outbox.Pending()
+	if err != nil {
+		err = errors.Wrap(err, "Outbox-Relay: Error fetching pending entries")
+		log.Println(err)
+		return
+	}
+
+	for _, entry := range entries {
+		claimed, err := config.outbox.Claim(entry.EntryID, config.lease)
+		if err != nil {
+			err = errors.Wrap(err, "Outbox-Relay: Error claiming entry")
+			log.Println(err)
+			return
+		}
+		if !claimed {
+			// Another relay is publishing the entry, and later entries wait for it
+			return
+		}
+
+		pubErr := relayEntry(config, prodChan, entry)
+		if pubErr != nil {
+			pubErr = errors.Wrapf(pubErr, "Outbox-Relay: Error publishing entry %s", entry.EntryID)
+			log.Println(pubErr)
+
+			if entry.Attempts+1 >= config.maxAttempts {
+				err = config.outbox.DeadLetter(entry, pubErr)
+				if err != nil {
+					err = errors.Wrap(err, "Outbox-Relay: Error dead-lettering entry")
+					log.Println(err)
+					return
+				}
+				logger.DefaultLogger().Warnf(
+					"Outbox-Relay: Dead-lettered entry %s after %d attempts",
+					entry.EntryID, entry.Attempts+1,
+				)
+				continue
+			}
+
+			err = config.outbox.MarkFailed(entry, pubErr)
+			if err != nil {
+				err = errors.Wrap(err, "Outbox-Relay: Error marking entry as failed")
+				log.Println(err)
+			}
+			// Later entries wait for this one, so they are emitted in order
+			return
+		}
+
+		err = config.outbox.MarkSent(entry.EntryID)
+		if err != nil {
+			err = errors.Wrap(err, "Outbox-Relay: Error marking entry as sent")
+			log.Println(err)
+			return
+		}
+	}
+}
+
+// pruneSent removes the entries sent before the retention-period.
+func pruneSent(config *outboxRelayConfig) {
+	pruned, err := config.outbox.Prune(time.Now().Add(-config.retention))
+	if err != nil {
+		err = errors.Wrap(err, "Outbox-Relay: Error pruning sent entries")
+		log.Println(err)
+		return
+	}
+	if pruned > 0 {
+		logger.DefaultLogger().Infof("Outbox-Relay: Pruned %d sent entries", pruned)
+	}
+}
+
+func relayEntry(
+	config *outboxRelayConfig,
+	prodChan chan<- *producerInput,
+	entry *command.OutboxEntry,
+) error {
+	if !entry.EventSent && len(entry.Events) > 0 {
+		err := relayEvents(config, prodChan, entry)
+		if err != nil {
+			return err
+		}
+		// The Response is only sent after the Events, so if the Response fails,
+		// the retry must not publish the Events again.
+		err = config.outbox.MarkEventSent(entry.EntryID)
+		if err != nil {
+			err = errors.Wrap(err, "Error marking Events as sent")
+			return err
+		}
+	}
+
+	if len(entry.Response) > 0 {
+		if entry.ResponseTopic == "" {
+			logger.DefaultLogger().Warnf(
+				"Outbox-Relay: Empty Topic in Response for entry: %s", entry.EntryID,
+			)
+			return nil
+		}
+		err := publishAndWait(config.ctx, prodChan, &producerInput{
+			data:    json.RawMessage(entry.Response),
+			topic:   entry.ResponseTopic,
+			headers: entry.RespHeaders,
+		})
+		if err != nil {
+			err = errors.Wrap(err, "Error publishing Response")
+			return err
+		}
+	}
+	return nil
+}
+
+// relayEvents publishes the Events of the entry in order.
+func relayEvents(
+	config *outboxRelayConfig,
+	prodChan chan<- *producerInput,
+	entry *command.OutboxEntry,
+) error {
+	inputs := make([]*producerInput, 0, len(entry.Events))
+	for _, event := range entry.Events {
+		inputs = append(inputs, &producerInput{
+			data:    json.RawMessage(event.Event),
+			topic:   config.eventsTopic,
+			key:     event.Key,
+			headers: event.Headers,
+		})
+	}
+
+	if len(inputs) > 1 && config.txnProd != nil {
+		err := config.txnProd.produceInputs(nil, inputs)
+		if err != nil {
+			err = errors.Wrap(err, "Error publishing Events")
+			return err
+		}
+		return nil
+	}
+	for _, input := range inputs {
+		err := publishAndWait(config.ctx, prodChan, input)
+		if err != nil {
+			err = errors.Wrap(err, "Error publishing Event")
+			return err
+		}
+	}
+	return nil
+}
+
+// publishAndWait produces the input and waits for its delivery-result.
+func publishAndWait(
+	ctx context.Context,
+	prodChan chan<- *producerInput,
+	input *producerInput,
+) error {
+	resultChan := make(chan error, 1)
+	input.result = resultChan
+
+	select {
+	case <-ctx.Done():
+		return errors.New("session closed")
+	case prodChan <- input:
+	}
+
+	select {
+	case <-ctx.Done():
+		return errors.New("session closed")
+	case err := <-resultChan:
+		return err
+	}
+}
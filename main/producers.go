@@ -5,26 +5,44 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"sort"
 
 	"golang.org/x/sync/errgroup"
 
-	"github.com/TerrexTech/go-common-models/model"
+	"github.com/Shopify/sarama"
+	"github.com/TerrexTech/agg-userauth-cmd/broker"
+	"github.com/TerrexTech/agg-userauth-cmd/command"
+	"github.com/TerrexTech/agg-userauth-cmd/metrics"
 	"github.com/TerrexTech/go-kafkautils/kafka"
 	"github.com/pkg/errors"
 )
 
 type producerInput struct {
-	data  interface{}
-	topic string
+	data    interface{}
+	topic   string
+	key     string
+	headers map[string]string
+	// result, if not nil, receives the delivery-result of the message.
+	result chan<- error
 }
 
 type producerConfig struct {
 	ctx         context.Context
 	kafkaConfig *kafka.ProducerConfig
-	g           *errgroup.Group
+	// memBroker is optional. If set, messages are produced
+	// to the in-memory broker instead of Kafka.
+	memBroker *broker.Broker
+	g         *errgroup.Group
+
+	// queueSize is the capacity of the producer-queues
+	queueSize int
+	// queues is optional, and tracks the producer-queues if set
+	queues *queueMonitor
+	// stats is optional, and tracks delivery-results if set
+	stats *deliveryStats
 }
 
-func eventProducer(config *producerConfig, topic string) (chan<- *model.Event, error) {
+func eventProducer(config *producerConfig, topic string) (chan<- *command.EventMsg, error) {
 	if config == nil {
 		return nil, errors.New("config cannot be nil")
 	}
@@ -32,8 +50,11 @@ func eventProducer(config *producerConfig, topic string) (chan<- *model.Event, e
 		return nil, errors.New("topic cannot be empty")
 	}
 
-	eventChan := make(chan *model.Event, 256)
-	prodChan := make(chan *producerInput, 256)
+	eventChan := make(chan *command.EventMsg, config.queueSize)
+	prodChan := make(chan *producerInput, config.queueSize)
+	config.queues.add("events", func() int {
+		return len(eventChan) + len(prodChan)
+	}, cap(eventChan)+cap(prodChan))
 
 	err := producer(config, (<-chan *producerInput)(prodChan))
 	if err != nil {
@@ -41,25 +62,31 @@ func eventProducer(config *producerConfig, topic string) (chan<- *model.Event, e
 		return nil, err
 	}
 	go func() {
-		for event := range eventChan {
+		for msg := range eventChan {
 			prodChan <- &producerInput{
-				data:  event,
-				topic: topic,
+				data:    msg.Event,
+				topic:   topic,
+				key:     msg.Key,
+				headers: msg.Headers,
+				result:  msg.Result,
 			}
 		}
 		close(prodChan)
 	}()
 
-	return (chan<- *model.Event)(eventChan), nil
+	return (chan<- *command.EventMsg)(eventChan), nil
 }
 
-func respProducer(config *producerConfig) (chan<- *model.Document, error) {
+func respProducer(config *producerConfig) (chan<- *command.ResponseMsg, error) {
 	if config == nil {
 		return nil, errors.New("config cannot be nil")
 	}
 
-	respChan := make(chan *model.Document, 256)
-	prodChan := make(chan *producerInput, 256)
+	respChan := make(chan *command.ResponseMsg, config.queueSize)
+	prodChan := make(chan *producerInput, config.queueSize)
+	config.queues.add("responses", func() int {
+		return len(respChan) + len(prodChan)
+	}, cap(respChan)+cap(prodChan))
 
 	err := producer(config, (<-chan *producerInput)(prodChan))
 	if err != nil {
@@ -67,30 +94,107 @@ func respProducer(config *producerConfig) (chan<- *model.Document, error) {
 		return nil, err
 	}
 	go func() {
-		for resp := range respChan {
+		for msg := range respChan {
+			resp := msg.Document
 			if resp.Topic == "" {
 				err = fmt.Errorf("RespProducer: Empty Topic in Response: %s", resp.UUID)
 				log.Println(err)
 			}
 			prodChan <- &producerInput{
-				data:  resp,
-				topic: resp.Topic,
+				data:    resp,
+				topic:   resp.Topic,
+				headers: msg.Headers,
 			}
 		}
 		close(prodChan)
 	}()
 
-	return (chan<- *model.Document)(respChan), nil
+	return (chan<- *command.ResponseMsg)(respChan), nil
+}
+
+// createMessage creates a Kafka-message from the producer-input.
+func createMessage(input *producerInput, value []byte) *sarama.ProducerMessage {
+	msg := kafka.CreateMessage(input.topic, value)
+	if input.key != "" {
+		msg.Key = sarama.StringEncoder(input.key)
+	}
+
+	// Sorted for a deterministic header-order
+	headerKeys := make([]string, 0, len(input.headers))
+	for k := range input.headers {
+		headerKeys = append(headerKeys, k)
+	}
+	sort.Strings(headerKeys)
+	for _, k := range headerKeys {
+		msg.Headers = append(msg.Headers, sarama.RecordHeader{
+			Key:   []byte(k),
+			Value: []byte(input.headers[k]),
+		})
+	}
+
+	msg.Metadata = input
+	return msg
+}
+
+// reportResult sends the delivery-result to the input's result-channel, if any.
+func reportResult(input *producerInput, err error) {
+	if input != nil && input.result != nil {
+		input.result <- err
+	}
 }
 
 func producer(config *producerConfig, inputChan <-chan *producerInput) error {
-	prod, err := kafka.NewProducer(config.kafkaConfig)
+	// Successes are required so delivery can be confirmed to the requester.
+	saramaConfig := sarama.NewConfig()
+	if config.kafkaConfig.SaramaConfig != nil {
+		saramaConfig = copySaramaConfig(config.kafkaConfig.SaramaConfig)
+	}
+	saramaConfig.Producer.RequiredAcks = sarama.WaitForAll
+	saramaConfig.Producer.Return.Errors = true
+	saramaConfig.Producer.Return.Successes = true
+	// Message-headers require Kafka 0.11
+	if !saramaConfig.Version.IsAtLeast(sarama.V0_11_0_0) {
+		saramaConfig.Version = sarama.V0_11_0_0
+	}
+
+	prod, err := newAsyncProducer(config.kafkaConfig, saramaConfig, config.memBroker)
 	if err != nil {
 		err = errors.Wrap(err, "Error creating Event-Producer")
 		log.Println(err)
 		return err
 	}
 
+	// Delivery-results are drained separately so a slow broker cannot
+	// deadlock the input-loop below.
+	go func() {
+		for {
+			select {
+			case <-config.ctx.Done():
+				return
+
+			case prodErr := <-prod.Errors():
+				if prodErr == nil {
+					continue
+				}
+				err := errors.Wrap(prodErr, "Error in Producer")
+				log.Println(err)
+				config.stats.record(false)
+				if prodErr.Msg != nil {
+					metrics.ProducerSendFailures.WithLabelValues(prodErr.Msg.Topic).Inc()
+					input, _ := prodErr.Msg.Metadata.(*producerInput)
+					reportResult(input, err)
+				}
+
+			case msg := <-prod.Successes():
+				if msg != nil {
+					config.stats.record(true)
+					input, _ := msg.Metadata.(*producerInput)
+					reportResult(input, nil)
+				}
+			}
+		}
+	}()
+
 	config.g.Go(func() error {
 		var prodErr error
 	prodLoop:
@@ -100,22 +204,23 @@ func producer(config *producerConfig, inputChan <-chan *producerInput) error {
 				prodErr = errors.New("Event-Producer: session closed")
 				break prodLoop
 
-			case err := <-prod.Errors():
-				if err != nil && err.Err != nil {
-					parsedErr := errors.Wrap(err.Err, "Error in ESQueryRequest-Producer")
-					log.Println(parsedErr)
-					log.Println(err)
+			case input, ok := <-inputChan:
+				if !ok {
+					// Input closed, keep serving until the session closes
+					inputChan = nil
+					continue
+				}
+				if input == nil {
+					continue
 				}
-
-			case input := <-inputChan:
 				marshalInput, err := json.Marshal(input.data)
 				if err != nil {
 					err = errors.Wrap(err, "Error Marshalling Input")
 					log.Println(err)
+					reportResult(input, err)
 					continue
 				}
-				msg := kafka.CreateMessage(input.topic, marshalInput)
-				prod.Input() <- msg
+				prod.Input() <- createMessage(input, marshalInput)
 			}
 		}
 		return prodErr
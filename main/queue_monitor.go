@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/TerrexTech/agg-userauth-cmd/logger"
+	"github.com/TerrexTech/agg-userauth-cmd/metrics"
+	"github.com/pkg/errors"
+)
+
+// queueMonitor tracks how full the producer-queues are, so consumption can be
+// paused while the producers are backed up.
+type queueMonitor struct {
+	mu     sync.RWMutex
+	queues map[string]func() float64
+	depths map[string]func() int
+}
+
+func newQueueMonitor() *queueMonitor {
+	return &queueMonitor{
+		queues: map[string]func() float64{},
+		depths: map[string]func() int{},
+	}
+}
+
+// add registers a queue. depth must return the number of messages
+// waiting in the queue. Adding a queue again replaces it.
+func (q *queueMonitor) add(name string, depth func() int, capacity int) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.queues[name] = func() float64 {
+		return fillRatio(depth(), capacity)
+	}
+	q.depths[name] = depth
+	// The exported gauge reads the current queue, so it is only registered once
+	err := metrics.RegisterQueueDepth(name, func() float64 {
+		return float64(q.depth(name))
+	})
+	if err != nil {
+		err = errors.Wrapf(err, "Error registering depth-metric of queue %s", name)
+		log.Println(err)
+	}
+}
+
+// depth returns the number of messages waiting in the named queue.
+func (q *queueMonitor) depth(name string) int {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if depth, ok := q.depths[name]; ok {
+		return depth()
+	}
+	return 0
+}
+
+// load returns the fill-ratio of the fullest queue.
+func (q *queueMonitor) load() float64 {
+	if q == nil {
+		return 0
+	}
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	var maxLoad float64
+	for _, fillRatio := range q.queues {
+		if l := fillRatio(); l > maxLoad {
+			maxLoad = l
+		}
+	}
+	return maxLoad
+}
+
+// waitBelow blocks while the load is above highWaterMark,
+// or until the context is done.
+func (q *queueMonitor) waitBelow(ctx context.Context, highWaterMark float64) {
+	if q == nil || highWaterMark <= 0 || q.load() < highWaterMark {
+		return
+	}
+
+	logger.DefaultLogger().Warnf(
+		"Producer-queues above high-water mark of %.2f, pausing consumption",
+		highWaterMark,
+	)
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for q.load() >= highWaterMark {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+	logger.DefaultLogger().Infof("Producer-queues below high-water mark, resuming consumption")
+}
+
+// fillRatio returns the length of the channel divided by its capacity.
+func fillRatio(length int, capacity int) float64 {
+	if capacity == 0 {
+		return 0
+	}
+	return float64(length) / float64(capacity)
+}
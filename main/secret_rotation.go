@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/TerrexTech/agg-userauth-cmd/config"
+	"github.com/TerrexTech/agg-userauth-cmd/logger"
+	"github.com/TerrexTech/agg-userauth-cmd/secrets"
+	"github.com/TerrexTech/agg-userauth-cmd/util"
+	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+type secretRotationConfig struct {
+	ctx        context.Context
+	g          *errgroup.Group
+	cfg        *config.Config
+	mongoConn  *mongo.ConnectionConfig
+	signingKey *secrets.Value
+	log        *logger.Logger
+
+	// mongoCfg is the Mongo-config with the rotated credentials, shared
+	// by the secrets so rotating one keeps the others.
+	mongoLock sync.Mutex
+	mongoCfg  config.Mongo
+}
+
+// watchSecrets applies the secrets rotated in the configured secret-files.
+// MongoDB is reconnected using the new credentials, and the new signing-key
+// is used for the following Commands. The Agg-Builder's own Collection keeps
+// the previous MongoDB-connection until restart. Other secrets are only applied on
+// restart.
+func watchSecrets(config *secretRotationConfig) error {
+	if config == nil {
+		return errors.New("config cannot be nil")
+	}
+	if len(config.cfg.SecretFiles) == 0 {
+		return nil
+	}
+	// Mongo-settings are copied so the rotated credentials do not race
+	// with readers of the Config.
+	config.mongoCfg = config.cfg.Mongo
+
+	watcher, err := secrets.NewWatcher(config.log)
+	if err != nil {
+		return err
+	}
+
+	// Sorted for a stable order of log-output
+	envs := []string{}
+	for env := range config.cfg.SecretFiles {
+		envs = append(envs, env)
+	}
+	sort.Strings(envs)
+
+	for _, env := range envs {
+		path := config.cfg.SecretFiles[env]
+		err = watcher.Watch(path, rotateSecret(config, env))
+		if err != nil {
+			err = errors.Wrapf(err, "Error watching secret-file for %s", env)
+			return err
+		}
+	}
+
+	config.g.Go(func() error {
+		config.log.Infof("Watching %d secret-files for rotation", len(envs))
+		return watcher.Run(config.ctx)
+	})
+	return nil
+}
+
+func rotateSecret(config *secretRotationConfig, env string) secrets.OnChange {
+	return func(value string) error {
+		switch env {
+		case "AUTH_SIGNING_KEY":
+			config.signingKey.Set(value)
+			return nil
+
+		case "MONGO_PASSWORD", "MONGO_URI":
+			config.mongoLock.Lock()
+			defer config.mongoLock.Unlock()
+
+			mongoCfg := config.mongoCfg
+			if env == "MONGO_PASSWORD" {
+				mongoCfg.Password = value
+			} else {
+				mongoCfg.URI = value
+			}
+			err := util.ReconnectMongo(config.mongoConn, &mongoCfg)
+			if err != nil {
+				err = errors.Wrap(err, "Error reconnecting to MongoDB")
+				return err
+			}
+			config.mongoCfg = mongoCfg
+			config.log.Warnf(
+				"MongoDB reconnected, the Agg-Builder keeps its previous connection " +
+					"until the service restarts",
+			)
+			return nil
+
+		default:
+			config.log.Warnf("Secret %s was rotated, restart the service to apply it", env)
+			return nil
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Shopify/sarama"
+	"github.com/TerrexTech/agg-userauth-cmd/broker"
+	"github.com/TerrexTech/agg-userauth-cmd/logger"
+	"github.com/TerrexTech/agg-userauth-model/user"
+	"github.com/TerrexTech/go-agg-builder/builder"
+	"github.com/TerrexTech/go-kafkautils/kafka"
+	"github.com/TerrexTech/uuuid"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// aggEventsIO provides the context and error-group of the service,
+// and fetches the Events for building the Aggregate-state.
+type aggEventsIO interface {
+	Context() context.Context
+	ErrGroup() *errgroup.Group
+	BuildState(correlationID uuuid.UUID, timeoutSec int) (<-chan *builder.EventResponse, error)
+}
+
+// groupConsumer consumes topics as a consumer-group.
+type groupConsumer interface {
+	Consume(ctx context.Context, handler sarama.ConsumerGroupHandler) error
+}
+
+// standaloneIO is the aggEventsIO for standalone-mode, which builds
+// the Aggregate-state from the Events in the in-memory broker.
+type standaloneIO struct {
+	*broker.EventStore
+	ctx context.Context
+	g   *errgroup.Group
+}
+
+// newStandaloneIO creates the standaloneIO, whose context is closed
+// on SIGINT or SIGTERM, or when a routine in its error-group fails.
+func newStandaloneIO(memBroker *broker.Broker, eventsTopic string) (*standaloneIO, error) {
+	eventStore, err := memBroker.NewEventStore(eventsTopic, user.AggregateID)
+	if err != nil {
+		err = errors.Wrap(err, "Error creating EventStore")
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	g, ctx := errgroup.WithContext(ctx)
+	go func() {
+		sigChan := make(chan os.Signal, 1)
+		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+		select {
+		case sig := <-sigChan:
+			logger.DefaultLogger().Infof("Received signal %s, shutting down", sig)
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+
+	return &standaloneIO{
+		EventStore: eventStore,
+		ctx:        ctx,
+		g:          g,
+	}, nil
+}
+
+func (s *standaloneIO) Context() context.Context {
+	return s.ctx
+}
+
+func (s *standaloneIO) ErrGroup() *errgroup.Group {
+	return s.g
+}
+
+// newAsyncProducer creates a producer for the in-memory broker if set,
+// else for Kafka.
+func newAsyncProducer(
+	kafkaConfig *kafka.ProducerConfig,
+	saramaConfig *sarama.Config,
+	memBroker *broker.Broker,
+) (sarama.AsyncProducer, error) {
+	if memBroker != nil {
+		return memBroker.NewAsyncProducer(saramaConfig), nil
+	}
+	return sarama.NewAsyncProducer(kafkaConfig.KafkaBrokers, saramaConfig)
+}
+
+// newGroupConsumer creates a consumer for the in-memory broker if set,
+// else for Kafka.
+func newGroupConsumer(
+	kafkaConfig *kafka.ConsumerConfig,
+	memBroker *broker.Broker,
+) (groupConsumer, error) {
+	if memBroker != nil {
+		return memBroker.NewConsumer(kafkaConfig.GroupName, kafkaConfig.Topics)
+	}
+	return kafka.NewConsumer(kafkaConfig)
+}
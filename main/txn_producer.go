@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"github.com/TerrexTech/agg-userauth-cmd/command"
+	"github.com/TerrexTech/agg-userauth-cmd/logger"
+	"github.com/pkg/errors"
+)
+
+// txnProducer produces the Events and Response for a Command, and commits the
+// Command's consumer-offset, in a single Kafka-transaction. This ensures that
+// a Command's output is emitted exactly once, even if the service restarts
+// mid-batch.
+type txnProducer struct {
+	// Only one transaction can be open at a time on a producer
+	mu sync.Mutex
+
+	prod        sarama.AsyncProducer
+	groupName   string
+	eventsTopic string
+}
+
+type txnProducerConfig struct {
+	*producerConfig
+	transactionalID string
+	// groupName is the consumer-group whose offsets are committed
+	groupName   string
+	eventsTopic string
+}
+
+func newTxnProducer(config *txnProducerConfig) (*txnProducer, error) {
+	if config == nil || config.producerConfig == nil {
+		return nil, errors.New("config cannot be nil")
+	}
+	if config.transactionalID == "" {
+		return nil, errors.New("transactionalID cannot be blank")
+	}
+	if config.groupName == "" {
+		return nil, errors.New("groupName cannot be blank")
+	}
+	if config.eventsTopic == "" {
+		return nil, errors.New("eventsTopic cannot be blank")
+	}
+
+	saramaConfig := sarama.NewConfig()
+	if config.kafkaConfig.SaramaConfig != nil {
+		saramaConfig = copySaramaConfig(config.kafkaConfig.SaramaConfig)
+	}
+	// Transactions require Kafka 0.11 and an idempotent producer
+	if !saramaConfig.Version.IsAtLeast(sarama.V0_11_0_0) {
+		saramaConfig.Version = sarama.V0_11_0_0
+	}
+	saramaConfig.Net.MaxOpenRequests = 1
+	saramaConfig.Producer.Idempotent = true
+	saramaConfig.Producer.RequiredAcks = sarama.WaitForAll
+	saramaConfig.Producer.Return.Errors = true
+	saramaConfig.Producer.Transaction.ID = config.transactionalID
+
+	prod, err := sarama.NewAsyncProducer(config.kafkaConfig.KafkaBrokers, saramaConfig)
+	if err != nil {
+		err = errors.Wrap(err, "Error creating Transactional-Producer")
+		return nil, err
+	}
+
+	// Errors surface through CommitTxn, but the channel must still be drained
+	go func() {
+		for {
+			select {
+			case <-config.ctx.Done():
+				return
+			case prodErr := <-prod.Errors():
+				if prodErr != nil {
+					err := errors.Wrap(prodErr, "Error in Transactional-Producer")
+					log.Println(err)
+				}
+			}
+		}
+	}()
+
+	return &txnProducer{
+		prod:        prod,
+		groupName:   config.groupName,
+		eventsTopic: config.eventsTopic,
+	}, nil
+}
+
+// produce emits the Events (in order) and Response (can be nil) and marks
+// the consumed message (can be nil) as processed, all in one transaction.
+// If the transaction fails, it is aborted and the consumer-offset is not
+// committed.
+func (t *txnProducer) produce(
+	consumed *sarama.ConsumerMessage,
+	eventMsgs []*command.EventMsg,
+	respMsg *command.ResponseMsg,
+) error {
+	inputs := []*producerInput{}
+	for _, eventMsg := range eventMsgs {
+		inputs = append(inputs, &producerInput{
+			data:    eventMsg.Event,
+			topic:   t.eventsTopic,
+			key:     eventMsg.Key,
+			headers: eventMsg.Headers,
+		})
+	}
+	if respMsg != nil {
+		if respMsg.Document.Topic == "" {
+			logger.DefaultLogger().Warnf(
+				"Transactional-Producer: Empty Topic in Response: %s",
+				respMsg.Document.UUID,
+			)
+		} else {
+			inputs = append(inputs, &producerInput{
+				data:    respMsg.Document,
+				topic:   respMsg.Document.Topic,
+				headers: respMsg.Headers,
+			})
+		}
+	}
+
+	return t.produceInputs(consumed, inputs)
+}
+
+// produceEvents emits the Events (in order) in one transaction, so either
+// all of them are committed, or none are.
+func (t *txnProducer) produceEvents(eventMsgs []*command.EventMsg) error {
+	return t.produce(nil, eventMsgs, nil)
+}
+
+// produceInputs emits the inputs (in order) and marks the consumed message
+// (can be nil) as processed, all in one transaction.
+func (t *txnProducer) produceInputs(
+	consumed *sarama.ConsumerMessage,
+	inputs []*producerInput,
+) error {
+	messages := make([]*sarama.ProducerMessage, 0, len(inputs))
+	for _, input := range inputs {
+		marshalInput, err := json.Marshal(input.data)
+		if err != nil {
+			err = errors.Wrap(err, "Error Marshalling Input")
+			return err
+		}
+		messages = append(messages, createMessage(input, marshalInput))
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	err := t.prod.BeginTxn()
+	if err != nil {
+		err = errors.Wrap(err, "Error beginning transaction")
+		return err
+	}
+	for _, msg := range messages {
+		t.prod.Input() <- msg
+	}
+
+	if consumed != nil {
+		err = t.prod.AddMessageToTxn(consumed, t.groupName, nil)
+		if err != nil {
+			err = errors.Wrap(err, "Error adding consumer-offset to transaction")
+			t.abort()
+			return err
+		}
+	}
+	err = t.prod.CommitTxn()
+	if err != nil {
+		err = errors.Wrap(err, "Error committing transaction")
+		t.abort()
+		return err
+	}
+	return nil
+}
+
+// abort aborts the current transaction. The offset of the consumed message
+// is then left uncommitted, so the message is consumed again once the
+// consumer-session is restarted.
+func (t *txnProducer) abort() {
+	if t.prod.TxnStatus()&sarama.ProducerTxnFlagFatalError != 0 {
+		log.Println("Transactional-Producer is in a fatal state and cannot recover")
+	} else if t.prod.TxnStatus()&sarama.ProducerTxnFlagInTransaction != 0 ||
+		t.prod.TxnStatus()&sarama.ProducerTxnFlagAbortableError != 0 {
+		err := t.prod.AbortTxn()
+		if err != nil {
+			err = errors.Wrap(err, "Error aborting transaction")
+			log.Println(err)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+// Package metrics defines the Prometheus-metrics exported by the service.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "agg_userauth_cmd"
+
+// UnknownAction is the action-label of Commands and Events with unregistered
+// actions, so untrusted actions cannot add label-values.
+const UnknownAction = "unknown"
+
+// Outcomes of handled commands.
+const (
+	OutcomeSuccess = "success"
+	OutcomeError   = "error"
+)
+
+var (
+	// CommandsReceived counts the Commands consumed, by action.
+	CommandsReceived = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "commands_received_total",
+			Help:      "Number of Commands received.",
+		},
+		[]string{"action"},
+	)
+
+	// CommandsHandled counts the Commands handled, by action and outcome.
+	CommandsHandled = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "commands_handled_total",
+			Help:      "Number of Commands handled.",
+		},
+		[]string{"action", "outcome"},
+	)
+
+	// CommandErrors counts the errors returned for Commands, by action and error-code.
+	CommandErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "command_errors_total",
+			Help:      "Number of errors returned for Commands.",
+		},
+		[]string{"action", "code"},
+	)
+
+	// CommandsExpired counts the Commands ignored because their TTL expired.
+	CommandsExpired = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "commands_expired_total",
+			Help:      "Number of Commands ignored because their TTL expired.",
+		},
+	)
+
+	// BuildStateDuration observes the time taken for building Aggregate-state.
+	BuildStateDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "build_state_duration_seconds",
+			Help:      "Time taken for building Aggregate-state.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"outcome"},
+	)
+
+	// BuildStateEvents counts the Events applied while building Aggregate-state.
+	BuildStateEvents = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "build_state_events_total",
+			Help:      "Number of Events applied while building Aggregate-state.",
+		},
+		[]string{"action"},
+	)
+
+	// ProducerSendFailures counts the messages the producers failed to deliver.
+	ProducerSendFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "producer_send_failures_total",
+			Help:      "Number of messages that failed to be delivered.",
+		},
+		[]string{"topic"},
+	)
+
+	// PasswordHashDuration observes the time taken for hashing passwords.
+	PasswordHashDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "password_hash_duration_seconds",
+			Help:      "Time taken for hashing passwords with bcrypt.",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2, 10),
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		CommandsReceived,
+		CommandsHandled,
+		CommandErrors,
+		CommandsExpired,
+		BuildStateDuration,
+		BuildStateEvents,
+		ProducerSendFailures,
+		PasswordHashDuration,
+	)
+}
+
+// Outcome returns the outcome-label for the error.
+func Outcome(err error) string {
+	if err != nil {
+		return OutcomeError
+	}
+	return OutcomeSuccess
+}
+
+// ErrorCode returns the code-label for an error-code.
+func ErrorCode(code int16) string {
+	return strconv.Itoa(int(code))
+}
+
+// RegisterQueueDepth exports the number of messages waiting
+// in the named producer-queue. Queues registered again keep
+// their first depth-function.
+func RegisterQueueDepth(queue string, depth func() float64) error {
+	err := prometheus.Register(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "producer_queue_depth",
+			Help:        "Number of messages waiting in producer-queues.",
+			ConstLabels: prometheus.Labels{"queue": queue},
+		},
+		depth,
+	))
+	if _, ok := err.(prometheus.AlreadyRegisteredError); ok {
+		return nil
+	}
+	return err
+}
+
+// Handler serves the registered metrics.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,289 @@
+// Package rbac holds the role-catalogue and the roles assigned to users.
+//
+// Roles are defined in the config-file or using DefineRole-commands, and are
+// assigned to users using AssignRole and RevokeRole-commands. A user can hold
+// multiple roles. The catalogue and assignments are projections, which are
+// updated when the corresponding Events are applied by domain.BuildState.
+package rbac
+
+import (
+	"github.com/TerrexTech/agg-userauth-cmd/util"
+	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/pkg/errors"
+)
+
+// PermissionAll grants every permission.
+const PermissionAll = "*"
+
+// Role is a named set of permissions. Permissions are the Command-Actions
+// that users with the role may issue, or PermissionAll.
+type Role struct {
+	Name        string   `bson:"name,omitempty" json:"name,omitempty"`
+	Description string   `bson:"description,omitempty" json:"description,omitempty"`
+	Permissions []string `bson:"permissions,omitempty" json:"permissions,omitempty"`
+}
+
+// Validate checks that the Role has a name and permissions.
+func (r *Role) Validate() error {
+	if r.Name == "" {
+		return errors.New("missing Name for role")
+	}
+	if len(r.Permissions) == 0 {
+		return errors.Errorf("missing Permissions for role %s", r.Name)
+	}
+	for _, p := range r.Permissions {
+		if p == "" {
+			return errors.Errorf("found blank permission for role %s", r.Name)
+		}
+	}
+	return nil
+}
+
+// UserRoles are the roles assigned to a user.
+type UserRoles struct {
+	UserID string   `bson:"userID,omitempty" json:"userID,omitempty"`
+	Roles  []string `bson:"roles" json:"roles"`
+}
+
+// StoreConfig is the config for the Store.
+type StoreConfig struct {
+	Conn                *mongo.ConnectionConfig
+	Database            string
+	RolesCollection     string
+	UserRolesCollection string
+
+	// Roles are the roles defined in the config. They are added to the
+	// catalogue when the Store is created, and cannot be redefined.
+	Roles []*Role
+}
+
+// Store holds the role-catalogue and role-assignments in Mongo-collections.
+type Store struct {
+	roles     *mongo.Collection
+	userRoles *mongo.Collection
+	// configured are the names of the roles defined in the config
+	configured map[string]bool
+}
+
+// NewStore creates the collections (if required) and returns a Store backed
+// by them. The roles from the config are added to the catalogue.
+func NewStore(config *StoreConfig) (*Store, error) {
+	if config == nil {
+		return nil, errors.New("config cannot be nil")
+	}
+	if config.Conn == nil {
+		return nil, errors.New("Conn cannot be nil")
+	}
+	if config.Database == "" {
+		return nil, errors.New("Database cannot be blank")
+	}
+	if config.RolesCollection == "" {
+		return nil, errors.New("RolesCollection cannot be blank")
+	}
+	if config.UserRolesCollection == "" {
+		return nil, errors.New("UserRolesCollection cannot be blank")
+	}
+
+	roles, err := util.EnsureCollection(&mongo.Collection{
+		Connection:   config.Conn,
+		Database:     config.Database,
+		Name:         config.RolesCollection,
+		SchemaStruct: &Role{},
+		Indexes:      []mongo.IndexConfig{uniqueIndex("name")},
+	})
+	if err != nil {
+		err = errors.Wrap(err, "Error creating Roles-collection")
+		return nil, err
+	}
+	userRoles, err := util.EnsureCollection(&mongo.Collection{
+		Connection:   config.Conn,
+		Database:     config.Database,
+		Name:         config.UserRolesCollection,
+		SchemaStruct: &UserRoles{},
+		Indexes:      []mongo.IndexConfig{uniqueIndex("userID")},
+	})
+	if err != nil {
+		err = errors.Wrap(err, "Error creating UserRoles-collection")
+		return nil, err
+	}
+
+	store := &Store{
+		roles:      roles,
+		userRoles:  userRoles,
+		configured: map[string]bool{},
+	}
+	for _, role := range config.Roles {
+		err = role.Validate()
+		if err != nil {
+			err = errors.Wrap(err, "Error in configured role")
+			return nil, err
+		}
+		err = store.PutRole(role)
+		if err != nil {
+			err = errors.Wrapf(err, "Error adding configured role %s", role.Name)
+			return nil, err
+		}
+		store.configured[role.Name] = true
+	}
+	return store, nil
+}
+
+func uniqueIndex(field string) mongo.IndexConfig {
+	return mongo.IndexConfig{
+		ColumnConfig: []mongo.IndexColumnConfig{
+			mongo.IndexColumnConfig{
+				Name: field,
+			},
+		},
+		IsUnique: true,
+		Name:     field + "_index",
+	}
+}
+
+// IsConfigured returns true if the role is defined in the config.
+func (s *Store) IsConfigured(name string) bool {
+	return s.configured[name]
+}
+
+// Role returns the role from the catalogue, or nil if it is not defined.
+func (s *Store) Role(name string) (*Role, error) {
+	results, err := s.roles.Find(map[string]interface{}{
+		"name": name,
+	})
+	if err != nil {
+		err = errors.Wrap(err, "Error finding role")
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, nil
+	}
+	role, assertOK := results[0].(*Role)
+	if !assertOK {
+		err = errors.New("error asserting find-result to Role")
+		return nil, err
+	}
+	return role, nil
+}
+
+// PutRole adds the role to the catalogue, or replaces the role's
+// description and permissions if it is already defined.
+func (s *Store) PutRole(role *Role) error {
+	result, err := s.roles.UpdateMany(
+		map[string]interface{}{
+			"name": role.Name,
+		},
+		map[string]interface{}{
+			"description": role.Description,
+			"permissions": role.Permissions,
+		},
+	)
+	if err != nil {
+		err = errors.Wrap(err, "Error updating role")
+		return err
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+	_, err = s.roles.InsertOne(role)
+	if err != nil {
+		err = errors.Wrap(err, "Error inserting role")
+		return err
+	}
+	return nil
+}
+
+// UserRoles returns the names of the roles assigned to the user.
+func (s *Store) UserRoles(userID string) ([]string, error) {
+	results, err := s.userRoles.Find(map[string]interface{}{
+		"userID": userID,
+	})
+	if err != nil {
+		err = errors.Wrap(err, "Error finding user-roles")
+		return nil, err
+	}
+	if len(results) == 0 {
+		return []string{}, nil
+	}
+	userRoles, assertOK := results[0].(*UserRoles)
+	if !assertOK {
+		err = errors.New("error asserting find-result to UserRoles")
+		return nil, err
+	}
+	return userRoles.Roles, nil
+}
+
+// AssignRole adds the role to the user's roles.
+func (s *Store) AssignRole(userID string, role string) error {
+	roles, err := s.UserRoles(userID)
+	if err != nil {
+		return err
+	}
+	if HasRole(roles, role) {
+		return nil
+	}
+	return s.setUserRoles(userID, append(roles, role))
+}
+
+// RevokeRole removes the role from the user's roles.
+func (s *Store) RevokeRole(userID string, role string) error {
+	roles, err := s.UserRoles(userID)
+	if err != nil {
+		return err
+	}
+	remaining := make([]string, 0, len(roles))
+	for _, r := range roles {
+		if r != role {
+			remaining = append(remaining, r)
+		}
+	}
+	return s.setUserRoles(userID, remaining)
+}
+
+// DeleteUser removes the role-assignments of the user.
+func (s *Store) DeleteUser(userID string) error {
+	_, err := s.userRoles.DeleteMany(map[string]interface{}{
+		"userID": userID,
+	})
+	if err != nil {
+		err = errors.Wrap(err, "Error deleting user-roles")
+		return err
+	}
+	return nil
+}
+
+func (s *Store) setUserRoles(userID string, roles []string) error {
+	result, err := s.userRoles.UpdateMany(
+		map[string]interface{}{
+			"userID": userID,
+		},
+		map[string]interface{}{
+			"roles": roles,
+		},
+	)
+	if err != nil {
+		err = errors.Wrap(err, "Error updating user-roles")
+		return err
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+	_, err = s.userRoles.InsertOne(&UserRoles{
+		UserID: userID,
+		Roles:  roles,
+	})
+	if err != nil {
+		err = errors.Wrap(err, "Error inserting user-roles")
+		return err
+	}
+	return nil
+}
+
+// HasRole returns true if the role is in the roles.
+func HasRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
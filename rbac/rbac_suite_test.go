@@ -0,0 +1,35 @@
+package rbac
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// TestRBAC tests the role-catalogue.
+func TestRBAC(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "RBAC Suite")
+}
+
+var _ = Describe("Role", func() {
+	It("should validate name and permissions", func() {
+		role := &Role{
+			Name:        "admin",
+			Permissions: []string{PermissionAll},
+		}
+		Expect(role.Validate()).To(Succeed())
+
+		Expect((&Role{Permissions: []string{"UpdateUser"}}).Validate()).ToNot(Succeed())
+		Expect((&Role{Name: "admin"}).Validate()).ToNot(Succeed())
+		Expect((&Role{Name: "admin", Permissions: []string{""}}).Validate()).ToNot(Succeed())
+	})
+
+	It("should check if roles contain a role", func() {
+		roles := []string{"admin", "customer"}
+		Expect(HasRole(roles, "customer")).To(BeTrue())
+		Expect(HasRole(roles, "owner")).To(BeFalse())
+		Expect(HasRole(nil, "admin")).To(BeFalse())
+	})
+})
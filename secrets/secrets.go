@@ -0,0 +1,169 @@
+// Package secrets reads secrets from mounted files (such as Docker or
+// Kubernetes secrets), and watches the files so secrets can be rotated
+// without restarting the service.
+package secrets
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/TerrexTech/agg-userauth-cmd/logger"
+	"github.com/pkg/errors"
+	fsnotify "gopkg.in/fsnotify.v1"
+)
+
+// ReadFile returns the secret in the file, with surrounding
+// whitespace (such as a trailing newline) removed.
+func ReadFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		err = errors.Wrapf(err, "Error reading secret-file %s", path)
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Value holds a secret that can be swapped while it is in use.
+type Value struct {
+	mu    sync.RWMutex
+	value string
+}
+
+// NewValue creates a Value holding the secret.
+func NewValue(value string) *Value {
+	return &Value{
+		value: value,
+	}
+}
+
+// Get returns the current secret.
+func (v *Value) Get() string {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.value
+}
+
+// Set replaces the secret.
+func (v *Value) Set(value string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.value = value
+}
+
+// OnChange is called with the new secret when a watched file changes.
+type OnChange func(value string) error
+
+type watchedFile struct {
+	path     string
+	value    string
+	onChange OnChange
+}
+
+// Watcher watches secret-files for changes.
+//
+// The directories containing the files are watched rather than the files
+// themselves, since mounted secrets are usually replaced by swapping
+// symlinks, which is not reported as a change to the file.
+type Watcher struct {
+	mu      sync.Mutex
+	watcher *fsnotify.Watcher
+	// files by their directory
+	files map[string][]*watchedFile
+	log   *logger.Logger
+}
+
+// NewWatcher creates a Watcher. The log is optional.
+func NewWatcher(log *logger.Logger) (*Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		err = errors.Wrap(err, "Error creating file-watcher")
+		return nil, err
+	}
+	return &Watcher{
+		watcher: watcher,
+		files:   map[string][]*watchedFile{},
+		log:     log,
+	}, nil
+}
+
+// Watch calls onChange whenever the contents of the file change.
+func (w *Watcher) Watch(path string, onChange OnChange) error {
+	if onChange == nil {
+		return errors.New("onChange cannot be nil")
+	}
+	value, err := ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	dir := filepath.Dir(path)
+	if _, ok := w.files[dir]; !ok {
+		err := w.watcher.Add(dir)
+		if err != nil {
+			err = errors.Wrapf(err, "Error watching directory %s", dir)
+			return err
+		}
+	}
+	w.files[dir] = append(w.files[dir], &watchedFile{
+		path:     path,
+		value:    value,
+		onChange: onChange,
+	})
+	return nil
+}
+
+// Run processes file-changes until the context is done.
+func (w *Watcher) Run(ctx context.Context) error {
+	defer w.watcher.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return nil
+			}
+			err = errors.Wrap(err, "Error in file-watcher")
+			w.log.Error(err)
+
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return nil
+			}
+			w.reload(filepath.Dir(event.Name))
+		}
+	}
+}
+
+// reload re-reads the files in the directory, and calls onChange
+// for the files whose contents changed.
+func (w *Watcher) reload(dir string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, file := range w.files[dir] {
+		value, err := ReadFile(file.path)
+		// The file can be briefly missing or empty while it is replaced,
+		// and is read again on the next event.
+		if err != nil || value == "" || value == file.value {
+			continue
+		}
+
+		err = file.onChange(value)
+		if err != nil {
+			err = errors.Wrapf(err, "Error applying rotated secret from %s", file.path)
+			w.log.Error(err)
+			continue
+		}
+		file.value = value
+		w.log.Infof("Rotated secret from %s", file.path)
+	}
+}
@@ -0,0 +1,99 @@
+package secrets
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// TestSecrets tests reading and rotating secrets.
+func TestSecrets(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Secrets Suite")
+}
+
+var _ = Describe("Secrets", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "secrets-test")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	writeSecret := func(name string, value string) string {
+		path := filepath.Join(dir, name)
+		err := ioutil.WriteFile(path, []byte(value), 0600)
+		Expect(err).ToNot(HaveOccurred())
+		return path
+	}
+
+	It("should read secrets without surrounding whitespace", func() {
+		path := writeSecret("password", "  secret\n")
+		value, err := ReadFile(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal("secret"))
+
+		_, err = ReadFile(filepath.Join(dir, "missing"))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should swap Values", func() {
+		v := NewValue("old")
+		Expect(v.Get()).To(Equal("old"))
+		v.Set("new")
+		Expect(v.Get()).To(Equal("new"))
+	})
+
+	It("should call onChange when the secret-file changes", func() {
+		path := writeSecret("password", "old\n")
+
+		watcher, err := NewWatcher(nil)
+		Expect(err).ToNot(HaveOccurred())
+		changes := make(chan string, 10)
+		err = watcher.Watch(path, func(value string) error {
+			changes <- value
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		done := make(chan error)
+		go func() {
+			done <- watcher.Run(ctx)
+		}()
+
+		// Replace the file the way mounted secrets are updated
+		tmpPath := writeSecret(".password.tmp", "new\n")
+		err = os.Rename(tmpPath, path)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(changes, 5*time.Second).Should(Receive(Equal("new")))
+
+		// Unchanged contents are not reported again
+		writeSecret("other", "value")
+		Consistently(changes, 200*time.Millisecond).ShouldNot(Receive())
+
+		cancel()
+		Eventually(done).Should(Receive(BeNil()))
+	})
+
+	It("should return error when watching missing files", func() {
+		watcher, err := NewWatcher(nil)
+		Expect(err).ToNot(HaveOccurred())
+		err = watcher.Watch(filepath.Join(dir, "missing"), func(string) error {
+			return nil
+		})
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,186 @@
+// Package shred encrypts the personal data of users in Events with a key per
+// user, which is held in a key-store collection. Users are erased by
+// destroying their key ("crypto-shredding"), which leaves their data in the
+// immutable event-store unreadable.
+//
+// Encrypted values are prefixed with EncryptedPrefix, so Events produced
+// before encryption was enabled are still read as they are.
+package shred
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/TerrexTech/agg-userauth-model/user"
+	"github.com/pkg/errors"
+)
+
+// EncryptedPrefix prefixes encrypted values.
+const EncryptedPrefix = "shred:v1:"
+
+// KeySize is the size of user-keys, for AES-256.
+const KeySize = 32
+
+// Fields are the user-fields holding personal data, which are encrypted.
+var Fields = []string{"email", "firstName", "lastName", "password"}
+
+// NewKey generates a random user-key.
+func NewKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	_, err := io.ReadFull(rand.Reader, key)
+	if err != nil {
+		err = errors.Wrap(err, "Error generating key")
+		return nil, err
+	}
+	return key, nil
+}
+
+// IsEncrypted returns true if the value was encrypted by Encrypt.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, EncryptedPrefix)
+}
+
+// Encrypt encrypts the value with the key using AES-GCM. Blank and already
+// encrypted values are returned as they are.
+func Encrypt(key []byte, value string) (string, error) {
+	if value == "" || IsEncrypted(value) {
+		return value, nil
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		err = errors.Wrap(err, "Error generating nonce")
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(value), nil)
+	return EncryptedPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt decrypts the value encrypted by Encrypt. Values which are not
+// encrypted are returned as they are. Values which cannot be decrypted, such
+// as when the key was destroyed (and so is nil), are returned blank.
+func Decrypt(key []byte, value string) string {
+	if !IsEncrypted(value) {
+		return value
+	}
+	if key == nil {
+		return ""
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(value, EncryptedPrefix))
+	if err != nil {
+		return ""
+	}
+	gcm, err := newGCM(key)
+	if err != nil || len(sealed) < gcm.NonceSize() {
+		return ""
+	}
+	nonceSize := gcm.NonceSize()
+	plain, err := gcm.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return ""
+	}
+	return string(plain)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		err = errors.Wrap(err, "Error creating cipher")
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		err = errors.Wrap(err, "Error creating GCM")
+		return nil, err
+	}
+	return gcm, nil
+}
+
+// userFields returns the Fields of the user.
+func userFields(u *user.User) []*string {
+	return []*string{&u.Email, &u.FirstName, &u.LastName, &u.Password}
+}
+
+// EncryptUser encrypts the Fields of the user.
+func EncryptUser(key []byte, u *user.User) error {
+	for _, field := range userFields(u) {
+		value, err := Encrypt(key, *field)
+		if err != nil {
+			return err
+		}
+		*field = value
+	}
+	return nil
+}
+
+// DecryptUser decrypts the Fields of the user.
+func DecryptUser(key []byte, u *user.User) {
+	for _, field := range userFields(u) {
+		*field = Decrypt(key, *field)
+	}
+}
+
+// EncryptMap encrypts the Fields of the user-map.
+func EncryptMap(key []byte, userMap map[string]interface{}) error {
+	for _, field := range Fields {
+		value, ok := userMap[field].(string)
+		if !ok {
+			continue
+		}
+		encrypted, err := Encrypt(key, value)
+		if err != nil {
+			return err
+		}
+		userMap[field] = encrypted
+	}
+	return nil
+}
+
+// DecryptMap decrypts the Fields of the user-map.
+func DecryptMap(key []byte, userMap map[string]interface{}) {
+	for _, field := range Fields {
+		if value, ok := userMap[field].(string); ok {
+			userMap[field] = Decrypt(key, value)
+		}
+	}
+}
+
+// DecryptJSON decrypts all encrypted values in the JSON-data, including in
+// nested objects.
+func DecryptJSON(key []byte, data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+	var value interface{}
+	err := json.Unmarshal(data, &value)
+	if err != nil {
+		err = errors.Wrap(err, "Error unmarshalling data")
+		return nil, err
+	}
+	return json.Marshal(decryptValue(key, value))
+}
+
+func decryptValue(key []byte, value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return Decrypt(key, v)
+	case map[string]interface{}:
+		for field, fieldValue := range v {
+			v[field] = decryptValue(key, fieldValue)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = decryptValue(key, item)
+		}
+	}
+	return value
+}
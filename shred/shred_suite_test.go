@@ -0,0 +1,101 @@
+package shred
+
+import (
+	"testing"
+
+	"github.com/TerrexTech/agg-userauth-model/user"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// TestShred tests encrypting personal data with user-keys.
+func TestShred(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Shred Suite")
+}
+
+var _ = Describe("Shred", func() {
+	var key []byte
+
+	BeforeEach(func() {
+		var err error
+		key, err = NewKey()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(key).To(HaveLen(KeySize))
+	})
+
+	It("should decrypt encrypted values", func() {
+		encrypted, err := Encrypt(key, "test@example.com")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(IsEncrypted(encrypted)).To(BeTrue())
+		Expect(encrypted).ToNot(ContainSubstring("test@example.com"))
+		Expect(Decrypt(key, encrypted)).To(Equal("test@example.com"))
+	})
+
+	It("should return values which are not encrypted as they are", func() {
+		Expect(Decrypt(key, "test@example.com")).To(Equal("test@example.com"))
+
+		encrypted, err := Encrypt(key, "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(encrypted).To(BeEmpty())
+	})
+
+	It("should blank values whose key was destroyed", func() {
+		encrypted, err := Encrypt(key, "test@example.com")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(Decrypt(nil, encrypted)).To(BeEmpty())
+
+		otherKey, err := NewKey()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(Decrypt(otherKey, encrypted)).To(BeEmpty())
+	})
+
+	It("should only encrypt the personal data of users", func() {
+		u := &user.User{
+			UserID:    "test-user",
+			Email:     "test@example.com",
+			FirstName: "Test",
+			LastName:  "User",
+			UserName:  "test-user-name",
+			Password:  "hash",
+			Role:      "customer",
+		}
+		encrypted := *u
+		err := EncryptUser(key, &encrypted)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(encrypted.UserID).To(Equal(u.UserID))
+		Expect(encrypted.UserName).To(Equal(u.UserName))
+		Expect(encrypted.Role).To(Equal(u.Role))
+		Expect(IsEncrypted(encrypted.Email)).To(BeTrue())
+		Expect(IsEncrypted(encrypted.Password)).To(BeTrue())
+
+		DecryptUser(key, &encrypted)
+		Expect(&encrypted).To(Equal(u))
+	})
+
+	It("should encrypt user-maps", func() {
+		userMap := map[string]interface{}{
+			"userID":    "test-user",
+			"firstName": "Test",
+		}
+		err := EncryptMap(key, userMap)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(userMap["userID"]).To(Equal("test-user"))
+		Expect(IsEncrypted(userMap["firstName"].(string))).To(BeTrue())
+
+		DecryptMap(key, userMap)
+		Expect(userMap["firstName"]).To(Equal("Test"))
+	})
+
+	It("should decrypt nested values in JSON-data", func() {
+		email, err := Encrypt(key, "test@example.com")
+		Expect(err).ToNot(HaveOccurred())
+		data := []byte(`{"users": [{"userID": "test-user", "email": "` + email + `"}]}`)
+
+		decrypted, err := DecryptJSON(key, data)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(decrypted).To(MatchJSON(
+			`{"users": [{"userID": "test-user", "email": "test@example.com"}]}`,
+		))
+	})
+})
@@ -0,0 +1,145 @@
+package shred
+
+import (
+	"encoding/base64"
+	"time"
+
+	"github.com/TerrexTech/agg-userauth-cmd/util"
+	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/pkg/errors"
+)
+
+// Key is the key of a user.
+type Key struct {
+	UserID string `bson:"userID,omitempty" json:"userID,omitempty"`
+	// Key is base64-encoded.
+	Key       string `bson:"key,omitempty" json:"key,omitempty"`
+	CreatedAt int64  `bson:"createdAt,omitempty" json:"createdAt,omitempty"`
+}
+
+// StoreConfig is the config for Store.
+type StoreConfig struct {
+	Conn       *mongo.ConnectionConfig
+	Database   string
+	Collection string
+}
+
+// Store holds the Keys of users in a Mongo-collection. Unlike projections,
+// it is not rebuilt from Events, since the Keys are not part of any Event.
+type Store struct {
+	coll *mongo.Collection
+}
+
+// NewStore creates the collection (if required) and returns a Store backed
+// by it.
+func NewStore(config *StoreConfig) (*Store, error) {
+	if config == nil {
+		return nil, errors.New("config cannot be nil")
+	}
+	if config.Conn == nil {
+		return nil, errors.New("Conn cannot be nil")
+	}
+	if config.Database == "" {
+		return nil, errors.New("Database cannot be blank")
+	}
+	if config.Collection == "" {
+		return nil, errors.New("Collection cannot be blank")
+	}
+
+	coll, err := util.EnsureCollection(&mongo.Collection{
+		Connection:   config.Conn,
+		Database:     config.Database,
+		Name:         config.Collection,
+		SchemaStruct: &Key{},
+		Indexes: []mongo.IndexConfig{
+			mongo.IndexConfig{
+				ColumnConfig: []mongo.IndexColumnConfig{
+					mongo.IndexColumnConfig{
+						Name: "userID",
+					},
+				},
+				IsUnique: true,
+				Name:     "userID_index",
+			},
+		},
+	})
+	if err != nil {
+		err = errors.Wrap(err, "Error creating Keys-collection")
+		return nil, err
+	}
+	return &Store{
+		coll: coll,
+	}, nil
+}
+
+// Key returns the key of the user, or nil if the user has no key, such as
+// when it was destroyed.
+func (s *Store) Key(userID string) ([]byte, error) {
+	results, err := s.coll.Find(map[string]interface{}{
+		"userID": userID,
+	})
+	if err != nil {
+		err = errors.Wrap(err, "Error finding key")
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, nil
+	}
+	userKey, assertOK := results[0].(*Key)
+	if !assertOK {
+		err = errors.New("error asserting find-result to Key")
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(userKey.Key)
+	if err != nil {
+		err = errors.Wrap(err, "Error decoding key")
+		return nil, err
+	}
+	return key, nil
+}
+
+// CreateKey returns the key of the user, creating it if the user has none.
+// If another Command created the user's key meanwhile, that key is returned.
+func (s *Store) CreateKey(userID string) ([]byte, error) {
+	if userID == "" {
+		return nil, errors.New("userID cannot be blank")
+	}
+	key, err := s.Key(userID)
+	if err != nil || key != nil {
+		return key, err
+	}
+
+	key, err = NewKey()
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.coll.InsertOne(&Key{
+		UserID:    userID,
+		Key:       base64.StdEncoding.EncodeToString(key),
+		CreatedAt: time.Now().Unix(),
+	})
+	if err != nil {
+		// The insert fails on the unique userID_index if the key was created
+		// concurrently, so the key is read again
+		existing, findErr := s.Key(userID)
+		if findErr == nil && existing != nil {
+			return existing, nil
+		}
+		err = errors.Wrap(err, "Error inserting key")
+		return nil, err
+	}
+	return key, nil
+}
+
+// Destroy destroys the key of the user, which makes the user's encrypted
+// data unreadable.
+func (s *Store) Destroy(userID string) error {
+	_, err := s.coll.DeleteMany(map[string]interface{}{
+		"userID": userID,
+	})
+	if err != nil {
+		err = errors.Wrap(err, "Error deleting key")
+		return err
+	}
+	return nil
+}
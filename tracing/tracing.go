@@ -0,0 +1,148 @@
+// Package tracing sets up OpenTelemetry-tracing for the service, and
+// propagates trace-context through Kafka message-headers.
+package tracing
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exporters supported by Init.
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+)
+
+const tracerName = "github.com/TerrexTech/agg-userauth-cmd"
+
+// Config is the configuration for tracing.
+type Config struct {
+	ServiceName string
+	// Exporter is one of ExporterNone, ExporterStdout or ExporterFile.
+	// Spans are still created and propagated with ExporterNone,
+	// but are not exported.
+	Exporter string
+	// FilePath is the file spans are written to with ExporterFile.
+	FilePath string
+}
+
+// Init sets up the global tracer-provider and propagator.
+// The returned function flushes and stops the exporter.
+func Init(config *Config) (func(context.Context) error, error) {
+	if config == nil {
+		return nil, errors.New("config cannot be nil")
+	}
+
+	// W3C trace-context is propagated regardless of the exporter, so traces
+	// are not broken when passing through this service.
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	res := resource.NewSchemaless(semconv.ServiceName(config.ServiceName))
+
+	var writer io.Writer
+	var file *os.File
+	switch config.Exporter {
+	case "", ExporterNone:
+		// Spans are recorded without an exporter, so their trace-context
+		// is valid and propagated to the produced messages
+		provider := sdktrace.NewTracerProvider(sdktrace.WithResource(res))
+		otel.SetTracerProvider(provider)
+		return provider.Shutdown, nil
+	case ExporterStdout:
+		writer = os.Stdout
+	case ExporterFile:
+		if config.FilePath == "" {
+			return nil, errors.New("FilePath is required for file-exporter")
+		}
+		var err error
+		file, err = os.OpenFile(config.FilePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			err = errors.Wrap(err, "Error opening trace-file")
+			return nil, err
+		}
+		writer = file
+	default:
+		return nil, errors.Errorf("unknown trace-exporter: %s", config.Exporter)
+	}
+
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(writer))
+	if err != nil {
+		err = errors.Wrap(err, "Error creating trace-exporter")
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if file != nil {
+			file.Close()
+		}
+		return err
+	}, nil
+}
+
+// Start starts a span as child of the span in ctx. A nil ctx is treated as
+// context.Background().
+func Start(
+	ctx context.Context,
+	name string,
+	attrs ...attribute.KeyValue,
+) (context.Context, trace.Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records the error (if any) on the span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Inject writes the trace-context from ctx into the message-headers.
+func Inject(ctx context.Context, headers map[string]string) {
+	if ctx == nil || headers == nil {
+		return
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.MapCarrier(headers))
+}
+
+// Extract returns a context containing the trace-context
+// from the headers of the consumed message.
+func Extract(ctx context.Context, msg *sarama.ConsumerMessage) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	carrier := propagation.MapCarrier{}
+	for _, header := range msg.Headers {
+		if header != nil {
+			carrier[string(header.Key)] = string(header.Value)
+		}
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, carrier)
+}
@@ -0,0 +1,98 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TestTracing tests the trace-context propagation.
+func TestTracing(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Tracing Suite")
+}
+
+var _ = Describe("Tracing", func() {
+	var shutdown func(context.Context) error
+
+	BeforeEach(func() {
+		var err error
+		shutdown, err = Init(&Config{
+			ServiceName: "test",
+			Exporter:    ExporterNone,
+		})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		err := shutdown(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should return error on unknown exporter", func() {
+		_, err := Init(&Config{
+			Exporter: "invalid",
+		})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should return error if file-exporter has no FilePath", func() {
+		_, err := Init(&Config{
+			Exporter: ExporterFile,
+		})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should propagate trace-context through message-headers", func() {
+		traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+		spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+		parent := trace.ContextWithSpanContext(
+			context.Background(),
+			trace.NewSpanContext(trace.SpanContextConfig{
+				TraceID:    traceID,
+				SpanID:     spanID,
+				TraceFlags: trace.FlagsSampled,
+			}),
+		)
+
+		headers := map[string]string{}
+		Inject(parent, headers)
+		Expect(headers).To(HaveKey("traceparent"))
+
+		msg := &sarama.ConsumerMessage{}
+		for k, v := range headers {
+			msg.Headers = append(msg.Headers, &sarama.RecordHeader{
+				Key:   []byte(k),
+				Value: []byte(v),
+			})
+		}
+		ctx := Extract(context.Background(), msg)
+		sc := trace.SpanContextFromContext(ctx)
+		Expect(sc.TraceID()).To(Equal(traceID))
+		Expect(sc.SpanID()).To(Equal(spanID))
+	})
+
+	It("should create and propagate spans without an exporter", func() {
+		ctx, span := Start(context.Background(), "test")
+		defer End(span, nil)
+		Expect(span.SpanContext().IsValid()).To(BeTrue())
+
+		headers := map[string]string{}
+		Inject(ctx, headers)
+		Expect(headers).To(HaveKey("traceparent"))
+	})
+
+	It("should not fail on nil context", func() {
+		ctx, span := Start(nil, "test")
+		Expect(ctx).ToNot(BeNil())
+		End(span, nil)
+
+		headers := map[string]string{}
+		Inject(nil, headers)
+		Expect(headers).To(BeEmpty())
+	})
+})
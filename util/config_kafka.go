@@ -0,0 +1,84 @@
+package util
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+
+	"github.com/Shopify/sarama"
+	"github.com/TerrexTech/agg-userauth-cmd/config"
+	"github.com/pkg/errors"
+	"github.com/xdg-go/scram"
+)
+
+// NewSaramaConfig creates the base sarama.Config with the TLS and SASL
+// settings from the Kafka-configuration. Consumers and producers
+// should copy this config before changing their specific settings.
+func NewSaramaConfig(cfg *config.Kafka) (*sarama.Config, error) {
+	saramaConfig := sarama.NewConfig()
+
+	tlsConfig, err := NewTLSConfig(&cfg.TLS)
+	if err != nil {
+		err = errors.Wrap(err, "Error creating Kafka TLS-config")
+		return nil, err
+	}
+	if tlsConfig != nil {
+		saramaConfig.Net.TLS.Enable = true
+		saramaConfig.Net.TLS.Config = tlsConfig
+	}
+
+	sasl := &cfg.SASL
+	if sasl.Mechanism == "" {
+		return saramaConfig, nil
+	}
+	saramaConfig.Net.SASL.Enable = true
+	saramaConfig.Net.SASL.Handshake = true
+	saramaConfig.Net.SASL.User = sasl.Username
+	saramaConfig.Net.SASL.Password = sasl.Password
+
+	switch sasl.Mechanism {
+	case config.SASLMechanismPlain:
+		saramaConfig.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+	case config.SASLMechanismSCRAMSHA256:
+		saramaConfig.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+		saramaConfig.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{hashGen: scram.HashGeneratorFcn(sha256.New)}
+		}
+	case config.SASLMechanismSCRAMSHA512:
+		saramaConfig.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+		saramaConfig.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{hashGen: scram.HashGeneratorFcn(sha512.New)}
+		}
+	default:
+		return nil, errors.Errorf("unsupported SASL-mechanism: %s", sasl.Mechanism)
+	}
+	// SASL-handshake v1 requires Kafka 1.0
+	if !saramaConfig.Version.IsAtLeast(sarama.V1_0_0_0) {
+		saramaConfig.Version = sarama.V1_0_0_0
+	}
+
+	return saramaConfig, nil
+}
+
+// scramClient implements sarama.SCRAMClient.
+type scramClient struct {
+	hashGen scram.HashGeneratorFcn
+	conv    *scram.ClientConversation
+}
+
+func (s *scramClient) Begin(userName, password, authzID string) error {
+	client, err := s.hashGen.NewClient(userName, password, authzID)
+	if err != nil {
+		err = errors.Wrap(err, "Error creating SCRAM-client")
+		return err
+	}
+	s.conv = client.NewConversation()
+	return nil
+}
+
+func (s *scramClient) Step(challenge string) (string, error) {
+	return s.conv.Step(challenge)
+}
+
+func (s *scramClient) Done() bool {
+	return s.conv.Done()
+}
@@ -1,67 +1,55 @@
 package util
 
 import (
-	"log"
-	"os"
+	"net/url"
 	"strconv"
+	"strings"
 
+	"github.com/TerrexTech/agg-userauth-cmd/config"
 	"github.com/TerrexTech/agg-userauth-model/user"
 	"github.com/TerrexTech/go-agg-builder/builder"
-	"github.com/TerrexTech/go-commonutils/commonutil"
 	"github.com/TerrexTech/go-mongoutils/mongo"
 	"github.com/pkg/errors"
 )
 
+// LoadMongoConfig creates the MongoConfig using the Mongo-settings of the
+// service's Config loaded from env-vars.
 func LoadMongoConfig() (*builder.MongoConfig, error) {
-	hosts := *commonutil.ParseHosts(
-		os.Getenv("MONGO_HOSTS"),
-	)
-
-	database := os.Getenv("MONGO_DATABASE")
-	aggCollection := os.Getenv("MONGO_AGG_COLLECTION")
-	metaCollection := os.Getenv("MONGO_META_COLLECTION")
-
-	username := os.Getenv("MONGO_USERNAME")
-	password := os.Getenv("MONGO_PASSWORD")
-	connTimeoutStr := os.Getenv("MONGO_CONNECTION_TIMEOUT_MS")
-	connTimeout, err := strconv.Atoi(connTimeoutStr)
+	cfg, err := config.LoadMongo(nil)
 	if err != nil {
-		err = errors.Wrap(err, "Error converting MONGO_CONNECTION_TIMEOUT_MS to integer")
-		log.Println(err)
-		log.Println("A defalt value of 3000 will be used for MONGO_CONNECTION_TIMEOUT_MS")
-		connTimeout = 3000
+		err = errors.Wrap(err, "Error loading config")
+		return nil, err
 	}
+	return NewMongoConfig(&cfg.Mongo)
+}
 
-	mongoConfig := mongo.ClientConfig{
-		Hosts:               hosts,
-		Username:            username,
-		Password:            password,
-		TimeoutMilliseconds: uint32(connTimeout),
+// NewMongoConfig connects to MongoDB and creates the MongoConfig.
+func NewMongoConfig(cfg *config.Mongo) (*builder.MongoConfig, error) {
+	mongoConfig, err := newMongoClientConfig(cfg)
+	if err != nil {
+		err = errors.Wrap(err, "Error creating MongoClient-config")
+		return nil, err
 	}
 
 	// MongoDB Client
-	client, err := mongo.NewClient(mongoConfig)
+	client, err := mongo.NewClient(*mongoConfig)
 	if err != nil {
 		err = errors.Wrap(err, "Error creating MongoClient")
-		log.Fatalln(err)
+		return nil, err
 	}
 
-	resTimeoutStr := os.Getenv("MONGO_CONNECTION_TIMEOUT_MS")
-	resTimeout, err := strconv.Atoi(resTimeoutStr)
-	if err != nil {
-		err = errors.Wrap(err, "Error converting MONGO_RESOURCE_TIMEOUT_MS to integer")
-		log.Println(err)
-		log.Println("A defalt value of 5000 will be used for MONGO_RESOURCE_TIMEOUT_MS")
-		resTimeout = 5000
-	}
 	conn := &mongo.ConnectionConfig{
 		Client:  client,
-		Timeout: uint32(resTimeout),
+		Timeout: uint32(cfg.ResourceTimeoutMs),
 	}
 
-	aggMongoCollection, err := createMongoCollection(conn, database, aggCollection)
+	aggMongoCollection, err := createMongoCollection(conn, cfg.Database, cfg.AggCollection)
 	if err != nil {
 		err = errors.Wrap(err, "Error creating MongoCollection")
+		disconnectErr := client.Disconnect()
+		if disconnectErr != nil {
+			err = errors.Wrapf(err, "Error disconnecting MongoClient: %s", disconnectErr)
+		}
 		return nil, err
 	}
 
@@ -69,8 +57,8 @@ func LoadMongoConfig() (*builder.MongoConfig, error) {
 		AggregateID:        user.AggregateID,
 		AggCollection:      aggMongoCollection,
 		Connection:         conn,
-		MetaDatabaseName:   database,
-		MetaCollectionName: metaCollection,
+		MetaDatabaseName:   cfg.Database,
+		MetaCollectionName: cfg.MetaCollection,
 	}, nil
 }
 
@@ -100,7 +88,7 @@ func createMongoCollection(
 	}
 
 	// Create New Collection
-	collection, err := mongo.EnsureCollection(&mongo.Collection{
+	collection, err := EnsureCollection(&mongo.Collection{
 		Connection:   conn,
 		Database:     db,
 		Name:         coll,
@@ -113,3 +101,80 @@ func createMongoCollection(
 	}
 	return collection, nil
 }
+
+// newMongoClientConfig creates the MongoClient-config from the URI (if any)
+// and the other connection-settings, which override the URI's options.
+//
+// The MongoClient builds its connection-string as
+// "mongodb://<username>:<password>@<first host>", so all hosts are joined,
+// with the options appended, into a single host-entry, and the credentials
+// are escaped.
+func newMongoClientConfig(cfg *config.Mongo) (*mongo.ClientConfig, error) {
+	hosts := cfg.Hosts
+	username := cfg.Username
+	password := cfg.Password
+	options := url.Values{}
+
+	if cfg.URI != "" {
+		uri, err := url.Parse(cfg.URI)
+		if err != nil {
+			// The error is not wrapped, since it contains the URI's credentials
+			return nil, errors.New("Error parsing MONGO_URI")
+		}
+		if uri.Scheme != "mongodb" {
+			return nil, errors.Errorf("unsupported MONGO_URI scheme: %s", uri.Scheme)
+		}
+		if uri.Host == "" {
+			return nil, errors.New("MONGO_URI contains no hosts")
+		}
+		hosts = strings.Split(uri.Host, ",")
+		if uri.User != nil {
+			username = uri.User.Username()
+			password, _ = uri.User.Password()
+		}
+		options = uri.Query()
+		// The URI's database is the default authSource
+		if db := strings.TrimPrefix(uri.Path, "/"); db != "" && options.Get("authSource") == "" {
+			options.Set("authSource", db)
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, errors.New("no MongoDB hosts configured")
+	}
+
+	setOption := func(key string, value string) {
+		if value != "" {
+			options.Set(key, value)
+		}
+	}
+	setOption("authSource", cfg.AuthSource)
+	setOption("replicaSet", cfg.ReplicaSet)
+	setOption("readConcernLevel", cfg.ReadConcern)
+	setOption("w", cfg.WriteConcern)
+	if cfg.MaxPoolSize > 0 {
+		setOption("maxPoolSize", strconv.Itoa(cfg.MaxPoolSize))
+	}
+	if cfg.MinPoolSize > 0 {
+		setOption("minPoolSize", strconv.Itoa(cfg.MinPoolSize))
+	}
+	if cfg.TLS.Enabled {
+		setOption("ssl", "true")
+		setOption("sslCertificateAuthorityFile", cfg.TLS.CAFile)
+		setOption("sslClientCertificateKeyFile", cfg.TLS.CertKeyFile)
+		if cfg.TLS.InsecureSkipVerify {
+			setOption("sslInsecure", "true")
+		}
+	}
+
+	clientHost := strings.TrimSuffix(strings.Join(hosts, ","), "/")
+	if len(options) > 0 {
+		clientHost += "/?" + options.Encode()
+	}
+
+	return &mongo.ClientConfig{
+		Hosts:               []string{clientHost},
+		Username:            url.QueryEscape(username),
+		Password:            url.QueryEscape(password),
+		TimeoutMilliseconds: uint32(cfg.ConnectionTimeoutMs),
+	}, nil
+}
@@ -0,0 +1,46 @@
+package util
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+
+	"github.com/TerrexTech/agg-userauth-cmd/config"
+	"github.com/pkg/errors"
+)
+
+// NewTLSConfig creates the tls.Config from the TLS-configuration.
+// Returns nil if TLS is not enabled.
+func NewTLSConfig(cfg *config.TLS) (*tls.Config, error) {
+	if cfg == nil || !cfg.Enabled {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if cfg.CAFile != "" {
+		caCert, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			err = errors.Wrap(err, "Error reading CA-file")
+			return nil, err
+		}
+		caPool := x509.NewCertPool()
+		if !caPool.AppendCertsFromPEM(caCert) {
+			return nil, errors.Errorf("no valid certificates found in CA-file %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = caPool
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			err = errors.Wrap(err, "Error loading client certificate and key")
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,137 @@
+package util
+
+import (
+	"sync"
+
+	"github.com/TerrexTech/agg-userauth-cmd/config"
+	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/pkg/errors"
+)
+
+// mongoConnState tracks the Collections and the replaced Clients of a
+// Mongo-connection.
+type mongoConnState struct {
+	lock        sync.RWMutex
+	collections []*mongo.Collection
+	retired     []*mongo.Client
+}
+
+var mongoConns = struct {
+	sync.Mutex
+	states map[*mongo.ConnectionConfig]*mongoConnState
+}{
+	states: map[*mongo.ConnectionConfig]*mongoConnState{},
+}
+
+func connState(conn *mongo.ConnectionConfig) *mongoConnState {
+	mongoConns.Lock()
+	defer mongoConns.Unlock()
+
+	state, exists := mongoConns.states[conn]
+	if !exists {
+		state = &mongoConnState{}
+		mongoConns.states[conn] = state
+	}
+	return state
+}
+
+// MongoLock returns the lock guarding the Client of the connection.
+// Operations on the connection's Collections hold its read-lock, so the
+// Client is not replaced by ReconnectMongo while they are in progress.
+func MongoLock(conn *mongo.ConnectionConfig) *sync.RWMutex {
+	return &connState(conn).lock
+}
+
+// EnsureCollection creates the Collection using mongo.EnsureCollection,
+// and registers it so it is rebound to the new Client when its connection
+// is reconnected.
+func EnsureCollection(c *mongo.Collection) (*mongo.Collection, error) {
+	if c == nil || c.Connection == nil {
+		return nil, errors.New("Collection and its Connection cannot be nil")
+	}
+	state := connState(c.Connection)
+	state.lock.Lock()
+	defer state.lock.Unlock()
+
+	coll, err := mongo.EnsureCollection(c)
+	if err != nil {
+		return nil, err
+	}
+	state.collections = append(state.collections, coll)
+	return coll, nil
+}
+
+// ReconnectMongo replaces the Client of the connection with a new Client
+// created using the Config, such as after the credentials were rotated.
+// The registered Collections are rebound to the new Client while the
+// connection's lock is held, so no operation uses the old Client afterwards.
+//
+// Collections created by the Agg-Builder are not registered and keep using
+// the old Client, so it stays connected until CloseMongo is called.
+func ReconnectMongo(conn *mongo.ConnectionConfig, cfg *config.Mongo) error {
+	mongoConfig, err := newMongoClientConfig(cfg)
+	if err != nil {
+		err = errors.Wrap(err, "Error creating MongoClient-config")
+		return err
+	}
+	client, err := mongo.NewClient(*mongoConfig)
+	if err != nil {
+		err = errors.Wrap(err, "Error creating MongoClient")
+		return err
+	}
+
+	state := connState(conn)
+	state.lock.Lock()
+	defer state.lock.Unlock()
+
+	oldClient := conn.Client
+	conn.Client = client
+	for _, coll := range state.collections {
+		_, err = mongo.EnsureCollection(coll)
+		if err != nil {
+			err = errors.Wrapf(err, "Error rebinding Collection %s", coll.Name)
+			break
+		}
+	}
+	if err != nil {
+		// The Collections are rebound to the old Client
+		conn.Client = oldClient
+		for _, coll := range state.collections {
+			// The indexes were already created using the old Client
+			_, _ = mongo.EnsureCollection(coll)
+		}
+		disconnectErr := client.Disconnect()
+		if disconnectErr != nil {
+			err = errors.Wrapf(err, "Error disconnecting new MongoClient: %s", disconnectErr)
+		}
+		return err
+	}
+
+	if oldClient != nil {
+		state.retired = append(state.retired, oldClient)
+	}
+	return nil
+}
+
+// CloseMongo disconnects the current Client of the connection, and the
+// Clients it replaced.
+func CloseMongo(conn *mongo.ConnectionConfig) error {
+	state := connState(conn)
+	state.lock.Lock()
+	defer state.lock.Unlock()
+
+	clients := append(state.retired, conn.Client)
+	state.retired = nil
+
+	var closeErr error
+	for _, client := range clients {
+		if client == nil {
+			continue
+		}
+		err := client.Disconnect()
+		if err != nil && closeErr == nil {
+			closeErr = errors.Wrap(err, "Error disconnecting MongoClient")
+		}
+	}
+	return closeErr
+}